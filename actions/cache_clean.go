@@ -0,0 +1,53 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package actions
+
+import (
+	"path/filepath"
+
+	"github.com/ksonnet/ksonnet/metadata/app"
+	"github.com/ksonnet/ksonnet/pkg/pipeline"
+)
+
+// RunCacheClean runs `cache clean`
+func RunCacheClean(ksApp app.App) error {
+	cc, err := NewCacheClean(ksApp)
+	if err != nil {
+		return err
+	}
+
+	return cc.Run()
+}
+
+// CacheClean removes the cached objects of rendered components.
+type CacheClean struct {
+	app app.App
+}
+
+// NewCacheClean creates an instance of CacheClean.
+func NewCacheClean(ksApp app.App) (*CacheClean, error) {
+	cc := &CacheClean{
+		app: ksApp,
+	}
+
+	return cc, nil
+}
+
+// Run removes the cache.
+func (cc *CacheClean) Run() error {
+	dir := filepath.Join(cc.app.Root(), pipeline.CacheDir)
+	return pipeline.NewCache(cc.app.Fs(), dir).Clean()
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package actions
+
+import (
+	"testing"
+
+	amocks "github.com/ksonnet/ksonnet/metadata/app/mocks"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheClean(t *testing.T) {
+	withApp(t, func(appMock *amocks.App) {
+		fs := appMock.Fs()
+		require.NoError(t, afero.WriteFile(fs, "/.ksonnet/cache/entry.json", []byte("{}"), 0644))
+		require.NoError(t, afero.WriteFile(fs, "/.ksonnet/registries/incubator/registry.yaml", []byte(""), 0644))
+
+		a, err := NewCacheClean(appMock)
+		require.NoError(t, err)
+
+		err = a.Run()
+		require.NoError(t, err)
+
+		exists, err := afero.Exists(fs, "/.ksonnet/cache")
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		exists, err = afero.Exists(fs, "/.ksonnet/registries/incubator/registry.yaml")
+		require.NoError(t, err)
+		require.True(t, exists)
+
+		// Cleaning an empty cache is not an error.
+		require.NoError(t, a.Run())
+	})
+}
@@ -30,10 +30,17 @@ var (
 )
 
 const (
-	flagCreate = "create"
-	flagSkipGc = "skip-gc"
-	flagGcTag  = "gc-tag"
-	flagDryRun = "dry-run"
+	flagCreate  = "create"
+	flagSkipGc  = "skip-gc"
+	flagGcTag   = "gc-tag"
+	flagDryRun  = "dry-run"
+	flagWait    = "wait"
+	flagTimeout = "timeout"
+
+	flagGcUnlabeled = "gc-unlabeled"
+
+	flagParallelism = "parallelism"
+	flagForce       = "force"
 
 	// AnnotationGcTag annotation that triggers
 	// garbage collection. Objects with value equal to
@@ -60,10 +67,19 @@ func init() {
 	applyClientConfig = client.NewDefaultClientConfig()
 	applyClientConfig.BindClientGoFlags(applyCmd)
 	bindJsonnetFlags(applyCmd)
+	bindHistoryStorageFlag(applyCmd)
+	bindHistoryMaxFlag(applyCmd)
+	bindRetryFlags(applyCmd)
+	bindEventOutputFlag(applyCmd)
 	applyCmd.PersistentFlags().Bool(flagCreate, true, "Option to create resources if they do not already exist on the cluster")
 	applyCmd.PersistentFlags().Bool(flagSkipGc, false, "Option to skip garbage collection, even with --"+flagGcTag+" specified")
 	applyCmd.PersistentFlags().String(flagGcTag, "", "A tag that's (1) added to all updated objects (2) used to garbage collect existing objects that are no longer in the manifest")
+	applyCmd.PersistentFlags().Bool(flagGcUnlabeled, false, "Also garbage collect objects that only have the gc-tag annotation, like objects applied by earlier versions of ks. This lists every object without the gc-tag label")
 	applyCmd.PersistentFlags().Bool(flagDryRun, false, "Option to preview the list of operations without changing the cluster state")
+	applyCmd.PersistentFlags().Bool(flagWait, false, "Wait for Deployments, StatefulSets, DaemonSets, Jobs and PersistentVolumeClaims to become ready")
+	applyCmd.PersistentFlags().Duration(flagTimeout, kubecfg.DefaultWaitTimeout, "How long to wait for objects to become ready when --"+flagWait+" is specified")
+	applyCmd.PersistentFlags().Bool(flagForce, false, "Delete and recreate objects whose immutable fields changed")
+	applyCmd.PersistentFlags().Int(flagParallelism, kubecfg.DefaultParallelism, "Number of objects of the same dependency tier to apply at the same time")
 }
 
 var applyCmd = &cobra.Command{
@@ -95,11 +111,54 @@ var applyCmd = &cobra.Command{
 			return err
 		}
 
+		c.GcUnlabeled, err = flags.GetBool(flagGcUnlabeled)
+		if err != nil {
+			return err
+		}
+
 		c.DryRun, err = flags.GetBool(flagDryRun)
 		if err != nil {
 			return err
 		}
 
+		c.Wait, err = flags.GetBool(flagWait)
+		if err != nil {
+			return err
+		}
+
+		c.WaitTimeout, err = flags.GetDuration(flagTimeout)
+		if err != nil {
+			return err
+		}
+
+		c.HistoryStorage, err = flags.GetString(flagHistoryStorage)
+		if err != nil {
+			return err
+		}
+
+		c.HistoryMax, err = historyMax(cmd)
+		if err != nil {
+			return err
+		}
+
+		c.Events, err = eventWriter(cmd)
+		if err != nil {
+			return err
+		}
+
+		c.Force, err = flags.GetBool(flagForce)
+		if err != nil {
+			return err
+		}
+
+		c.Parallelism, err = flags.GetInt(flagParallelism)
+		if err != nil {
+			return err
+		}
+		if c.Parallelism < 1 {
+			return fmt.Errorf("--%s must be at least 1", flagParallelism)
+		}
+
 		c.ClientConfig = applyClientConfig
 		c.Env = env
 
@@ -107,24 +166,35 @@ var applyCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		c.Components = componentNames
 
 		cwd, err := os.Getwd()
 		if err != nil {
 			return err
 		}
 
+		c.App, err = appName(appFs, cwd)
+		if err != nil {
+			return err
+		}
+
+		c.Retry, err = retryOptions(cmd, appFs, cwd, env)
+		if err != nil {
+			return err
+		}
+
 		te := newCmdObjExpander(cmdObjExpanderConfig{
 			cmd:        cmd,
 			env:        env,
 			components: componentNames,
 			cwd:        cwd,
 		})
-		objs, err := te.Expand()
+		layers, err := te.ExpandLayers()
 		if err != nil {
 			return err
 		}
 
-		return c.Run(objs, cwd)
+		return c.RunLayers(layers, cwd)
 	},
 	Long: `
 The ` + "`apply`" + `command uses local manifest(s) to update (and optionally create)
@@ -138,12 +208,75 @@ expanded using the parameters of the specified environment.
 By default, all component manifests are applied. To apply a subset of components,
 use the ` + "`--component` " + `flag, as seen in the examples below.
 
+Objects are applied in dependency order: namespaces and custom resource
+definitions first, then RBAC, configuration and services, then workloads and
+finally webhooks. An object can name objects it must be applied after in the
+` + "`ksonnet.io/apply-after`" + ` annotation, as a comma separated list of
+` + "`<kind>/<name>`" + ` references. Objects of the same tier that don't depend on
+each other are applied concurrently (see ` + "`--parallelism`" + `). If some of them
+fail, the errors are reported for every object and later tiers are skipped.
+
+Components can depend on other components. The dependencies are declared in the
+` + "`dependencies`" + ` field of the ` + "`params.libsonnet`" + ` of a component namespace,
+which maps the name of a component to the names of the components it depends on,
+e.g. ` + "`dependencies: { api: [\"db\"] }`" + `. Names are looked up in the same
+namespace first, and then as fully namespaced names. Environments can override the
+field in their ` + "`params.libsonnet`" + `. Components are applied after the
+components they depend on, and with ` + "`--wait`" + `, only once the objects of
+those components are ready. Dependency cycles are reported as errors.
+
+Each applied object records its configuration in the
+` + "`kubecfg.ksonnet.io/last-applied-configuration`" + ` annotation. On subsequent
+applies, this is used to compute a three-way patch, so fields that are removed
+from a component are also removed from the cluster.
+
+Some fields, like a Job's pod template or a Service's ` + "`clusterIP`" + `, can't be
+changed once an object is created. With ` + "`--force`" + `, objects whose immutable
+fields changed are deleted, together with their dependents, and recreated.
+Single objects can opt into this with the
+` + "`kubecfg.ksonnet.io/force-replace: \"true\"`" + ` annotation. Replaced objects are
+listed at the end of the apply.
+
+With ` + "`--output json`" + `, a JSON event is written to stdout for every applied or
+garbage collected object, one per line. Events contain the ` + "`action`" + `
+(` + "`create`" + `, ` + "`patch`" + `, ` + "`unchanged`" + `, ` + "`replace`" + ` or ` + "`gc`" + `), the ` + "`apiVersion`" + `,
+` + "`kind`" + `, ` + "`namespace`" + `, ` + "`name`" + ` and ` + "`uid`" + ` of the object, the ` + "`duration`" + ` in
+seconds and, if the object failed, the ` + "`error`" + `. Log messages are still
+written to stderr.
+
+Requests that fail with a conflict, throttling or server error are retried with
+exponential backoff. The retries can be configured per environment in
+` + "`app.yaml`" + ` (` + "`retry.retries`" + `, ` + "`retry.initialDelay`" + ` and
+` + "`retry.maxDelay`" + `) or with ` + "`--retries`" + ` and ` + "`--retry-delay`" + `. If an
+object fails, the objects that were updated before it are listed.
+
+Applied objects are labeled with the application, the environment and, if
+` + "`--gc-tag`" + ` is given, the garbage collection tag. Garbage collection uses
+these labels to find candidates with server-side label selectors. It only looks
+at cluster-scoped resources and the namespaces the environment deploys to, and
+skips resource types the user isn't allowed to list.
+
+Objects applied with ` + "`--gc-tag`" + ` by earlier versions of ks only carry the
+` + "`kubecfg.ksonnet.io/garbage-collect-tag`" + ` annotation, so garbage collection
+doesn't find them. Objects that are still part of the app get the label when
+they are applied again. To migrate, run ` + "`ks apply`" + ` once with
+` + "`--gc-unlabeled`" + `, which also lists every object without the label and
+garbage collects the ones whose annotation matches the tag. This lists most
+objects of the cluster, so it isn't done by default.
+
+Every apply is recorded in the release history of the environment. Use
+` + "`ks history`" + ` to list the revisions and ` + "`ks rollback`" + ` to return to one of them.
+The latest ` + "`--history-max`" + ` revisions are kept. With
+` + "`--history-storage configmap`" + `, the values of Secrets aren't recorded, so
+revisions that include Secrets can't be rolled back to.
+
 Note that this command needs to be run *within* a ksonnet app directory.
 
 ### Related Commands
 
 * ` + "`ks diff` " + `— ` + diffShortDesc + `
 * ` + "`ks delete` " + `— ` + deleteShortDesc + `
+* ` + "`ks history` " + `— ` + historyShortDesc + `
 
 ### Syntax
 `,
@@ -165,6 +298,15 @@ ks apply dev --dry-run
 # This essentially deploys 'components/guestbook-ui.jsonnet'.
 ks apply dev -c guestbook-ui
 
+# Create or update all resources in the 'dev' environment, then wait up to ten
+# minutes for the workloads to finish rolling out. Exits with an error if any
+# of them is not ready in time.
+ks apply dev --wait --timeout 10m
+
+# Update the 'dev' environment, deleting and recreating objects that can't be
+# updated because an immutable field, e.g. a Job's pod template, changed.
+ks apply dev --force
+
 # Create or update multiple components in a ksonnet application (e.g. 'guestbook-ui'
 # and 'ngin-depl') for the 'dev' environment. Does not create resources that are
 # not already present on the cluster.
@@ -0,0 +1,45 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cmd
+
+import "github.com/spf13/cobra"
+
+// cacheCmd represents the cache command
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the cache of rendered components",
+	Long: `
+The ` + "`cache`" + ` subcommands manage the cache of rendered components in
+` + "`.ksonnet/cache`" + `. Commands that render components store the objects
+of each component there, and skip evaluating a component while its source, the
+files it imports, its parameters, the environment and the Jsonnet flags are
+unchanged. The cache isn't used when images are resolved from a registry.
+
+### Related Commands
+
+* ` + "`ks cache clean` " + `— ` + cacheShortDesc["clean"] + `
+
+### Syntax
+`,
+}
+
+var cacheShortDesc = map[string]string{
+	"clean": "Remove all cached components",
+}
+
+func init() {
+	RootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cmd
+
+import (
+	"github.com/ksonnet/ksonnet/actions"
+	"github.com/spf13/cobra"
+)
+
+// cacheCleanCmd represents the cache clean command
+var cacheCleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: cacheShortDesc["clean"],
+	Long: `
+The ` + "`clean`" + ` command removes all cached components of the app. They
+are rendered again the next time they are needed.
+
+### Related Commands
+
+* ` + "`ks show` " + `— ` + showShortDesc + `
+
+### Syntax
+`,
+	Example: `# Remove the cached components
+ks cache clean`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return actions.RunCacheClean(ka)
+	},
+}
+
+func init() {
+	cacheCmd.AddCommand(cacheCleanCmd)
+}
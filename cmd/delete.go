@@ -40,6 +40,8 @@ func init() {
 	deleteClientConfig = client.NewDefaultClientConfig()
 	deleteClientConfig.BindClientGoFlags(deleteCmd)
 	bindJsonnetFlags(deleteCmd)
+	bindRetryFlags(deleteCmd)
+	bindEventOutputFlag(deleteCmd)
 	deleteCmd.PersistentFlags().Int64(flagGracePeriod, -1, "Number of seconds given to resources to terminate gracefully. A negative value is ignored")
 }
 
@@ -75,6 +77,16 @@ var deleteCmd = &cobra.Command{
 		c.ClientConfig = deleteClientConfig
 		c.Env = env
 
+		c.Retry, err = retryOptions(cmd, appFs, cwd, env)
+		if err != nil {
+			return err
+		}
+
+		c.Events, err = eventWriter(cmd)
+		if err != nil {
+			return err
+		}
+
 		te := newCmdObjExpander(cmdObjExpanderConfig{
 			cmd:        cmd,
 			env:        env,
@@ -97,6 +109,10 @@ An entire ksonnet application can be removed from a cluster, or just its specifi
 components.
 
 **This command can be considered the inverse of the ` + "`ks apply`" + ` command.**
+Objects are deleted in the reverse of the order they are applied in.
+
+With ` + "`--output json`" + `, a JSON event with the ` + "`delete`" + ` action is written to
+stdout for every object, one per line. See ` + "`ks apply`" + ` for the format.
 
 ### Related Commands
 
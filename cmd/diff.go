@@ -18,26 +18,42 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	"github.com/ksonnet/ksonnet/client"
+	"github.com/ksonnet/ksonnet/component"
 	"github.com/ksonnet/ksonnet/metadata"
+	"github.com/ksonnet/ksonnet/metadata/app"
 	"github.com/ksonnet/ksonnet/pkg/kubecfg"
+	"github.com/ksonnet/ksonnet/pkg/pipeline"
+	"github.com/ksonnet/ksonnet/pkg/util/git"
+	"github.com/ksonnet/ksonnet/pkg/util/k8s"
 )
 
 const (
 	flagDiffStrategy = "diff-strategy"
+	flagSummary      = "summary"
+	flagThreeWay     = "three-way"
 	diffShortDesc    = "Compare manifests, based on environment or location (local or remote)"
 )
 
 func init() {
 	addEnvCmdFlags(diffCmd)
 	bindJsonnetFlags(diffCmd)
-	diffCmd.PersistentFlags().String(flagDiffStrategy, "all", "Diff strategy, all or subset.")
+	bindRetryFlags(diffCmd)
+	diffCmd.PersistentFlags().String(flagDiffStrategy, kubecfg.DiffStrategyAll, "Diff strategy, all, subset or normalized.")
+	diffCmd.PersistentFlags().StringP(flagOutput, shortOutput, "", "Output format. Valid options: unified, json")
+	diffCmd.PersistentFlags().Bool(flagSummary, false, "Only print the number of added, removed, changed and unchanged objects")
+	diffCmd.PersistentFlags().Bool(flagThreeWay, false, "Label changed fields as changed in config, drifted in the cluster or conflicting, using the last applied configuration")
+	diffCmd.PersistentFlags().String(flagGcTag, "", "List remote objects with this garbage collection tag that are not in the local manifests as removed")
+	diffCmd.PersistentFlags().Bool(flagGcUnlabeled, false, "With --"+flagGcTag+", also list objects that only have the gc-tag annotation, see `ks apply --"+flagGcUnlabeled+"`")
 	RootCmd.AddCommand(diffCmd)
 }
 
@@ -74,12 +90,37 @@ var diffCmd = &cobra.Command{
 			env2 = &args[1]
 		}
 
-		diffStrategy, err := flags.GetString(flagDiffStrategy)
+		var diff kubecfg.Diff
+
+		diff.DiffStrategy, err = flags.GetString(flagDiffStrategy)
+		if err != nil {
+			return err
+		}
+
+		diff.Output, err = flags.GetString(flagOutput)
 		if err != nil {
 			return err
 		}
+		switch diff.Output {
+		case "", kubecfg.DiffOutputUnified, kubecfg.DiffOutputJSON:
+		default:
+			return fmt.Errorf("invalid output format %q; valid options: %s, %s", diff.Output, kubecfg.DiffOutputUnified, kubecfg.DiffOutputJSON)
+		}
+
+		diff.Summary, err = flags.GetBool(flagSummary)
+		if err != nil {
+			return err
+		}
+
+		diff.ThreeWay, err = flags.GetBool(flagThreeWay)
+		if err != nil {
+			return err
+		}
+		if diff.ThreeWay && diff.Output == kubecfg.DiffOutputUnified {
+			return fmt.Errorf("--%s doesn't support --%s %s", flagThreeWay, flagOutput, kubecfg.DiffOutputUnified)
+		}
 
-		c, err := initDiffCmd(appFs, cmd, cwd, env1, env2, componentNames, diffStrategy)
+		c, err := initDiffCmd(appFs, cmd, cwd, env1, env2, componentNames, diff)
 		if err != nil {
 			return err
 		}
@@ -97,6 +138,13 @@ Using this command, you can compare:
 2. *Remote* manifests for two separate environments
 3. *Local* manifests for two separate environments
 4. A *remote* manifest in one environment and a *local* manifest in another environment
+5. The manifests of an environment at a *git* revision of the app with *local* or
+   *remote* manifests
+
+A location is one of ` + "`local:<env>`" + `, ` + "`remote:<env>`" + ` or ` + "`git:<ref>:<env>`" + `. Git
+locations render the environment from the app as it was at the commit ` + "`<ref>`" + `
+(a branch, tag or commit), read directly from the git repository that contains
+the app. Nothing is checked out, so the work tree is left untouched.
 
 To see the official syntax, see the examples below. Make sure that your $KUBECONFIG
 matches what you've defined in environments.
@@ -107,6 +155,61 @@ the files in the ` + "`components/`" + ` directory.
 When a component IS specified via the ` + "`-c`" + ` flag, this command only checks
 the manifest for that particular component.
 
+By default, every field of the objects is compared (` + "`--diff-strategy all`" + `).
+Use ` + "`--diff-strategy subset`" + ` to only compare the fields that are set locally.
+
+With ` + "`--diff-strategy normalized`" + `, objects are normalized before they are
+compared. Metadata and status that are managed by the server, labels and
+annotations added by ` + "`ks apply`" + `, empty fields and fields that have their
+default value are ignored. Defaults are taken from the OpenAPI schema
+(` + "`swagger.json`" + `) of the environment's Kubernetes version. Further fields can
+be ignored per kind in ` + "`app.yaml`" + `:
+
+    diff:
+      ignore:
+        Deployment:
+        - spec.replicas
+        "*":
+        - metadata.annotations[example.com/build-id]
+
+Elements of lists that have a merge key in the OpenAPI schema, like containers,
+environment variables, ports and volumes, are matched by their key (e.g. ` + "`name`" + `
+or ` + "`containerPort`" + `) instead of their position. Reordering them is not a
+change, and only the elements that changed are shown.
+
+With ` + "`--output unified`" + `, a unified diff of the YAML of every changed, added or
+removed object is printed, as with ` + "`diff -u`" + `. With ` + "`--output json`" + `, a JSON
+document lists the ` + "`status`" + ` of every object (` + "`added`" + `, ` + "`removed`" + `, ` + "`changed`" + ` or
+` + "`unchanged`" + `) and the ` + "`changes`" + ` of changed objects, each with the ` + "`path`" + ` of the
+field, the ` + "`type`" + ` of change and the ` + "`old`" + ` and ` + "`new`" + ` values. ` + "`--summary`" + `
+only prints the number of objects with each status.
+
+With ` + "`--gc-tag`" + `, remote objects that carry the garbage collection tag but are no
+longer in the local manifests are listed as removed, since
+` + "`ks apply --gc-tag`" + ` would delete them. This requires a local and a remote
+location.
+
+When someone changed an object in the cluster, e.g. with ` + "`kubectl edit`" + `, a
+normal diff can't tell that change from a change in the local manifests. With
+` + "`--three-way`" + `, the configuration an object was last applied with (stored in
+the ` + "`kubecfg.ksonnet.io/last-applied-configuration`" + ` annotation by ` + "`ks apply`" + `),
+the live object and the local manifest are compared. Every field that differs is
+labeled:
+
+* ` + "`changed-in-config`" + ` — the local manifest changed since the last apply
+* ` + "`drifted-in-cluster`" + ` — the live object changed since the last apply
+* ` + "`conflicting`" + ` — both changed, to different values
+
+Fields that are only set in the cluster, e.g. by the API server, are not
+compared. This requires a local and a remote location and can't be combined with
+` + "`--output unified`" + `.
+
+The values of the ` + "`data`" + ` and ` + "`stringData`" + ` fields of Secrets are never
+printed. They are replaced with a hash of the value, so the diff still shows
+whether a value changed.
+
+The command exits with a non-zero status if any object differs.
+
 ### Related Commands
 
 * ` + "`ks param diff` " + `— ` + paramShortDesc["diff"] + `
@@ -134,26 +237,87 @@ ks diff local:us-west/dev remote:us-west/prod
 # Show diff between what's in the local manifest and what's actually running in the
 # 'dev' environment, but for the Redis component ONLY
 ks diff dev -c redis
+
+# Show how a pull request changes the 'prod' environment, compared to the master
+# branch, without checking out the branch
+ks diff git:origin/master:prod local:prod
+
+# Diff the 'dev' environment, ignoring fields that are managed by the server or
+# have their default value
+ks diff dev --diff-strategy normalized
+
+# Show a unified diff of the YAML of the changed objects in the 'dev' environment
+ks diff dev --output unified
+
+# Also list the objects in the 'dev' environment that 'ks apply dev --gc-tag dev'
+# would garbage collect
+ks diff dev --gc-tag dev
+
+# Show which changes in the 'dev' environment were made in the cluster and which
+# in the local manifests since the last 'ks apply dev'
+ks diff dev --three-way
+
+# Print the number of added, removed, changed and unchanged objects as JSON
+ks diff dev --output json --summary
 `,
 }
 
-func initDiffCmd(fs afero.Fs, cmd *cobra.Command, wd string, envFq1, envFq2 *string, files []string, diffStrategy string) (kubecfg.DiffCmd, error) {
-	const (
-		remote = "remote"
-		local  = "local"
-	)
+const (
+	diffLocationLocal  = "local"
+	diffLocationRemote = "remote"
+	diffLocationGit    = "git"
+)
 
-	if envFq2 == nil {
-		return initDiffSingleEnv(fs, *envFq1, diffStrategy, files, cmd, wd)
+// diffLocation is where the objects of an environment come from: the local
+// app, a remote cluster or a revision of the app in git.
+type diffLocation struct {
+	kind string
+	// ref is the git revision of git locations.
+	ref string
+	env string
+}
+
+// parseDiffLocation parses locations of the form local:<env>, remote:<env>
+// or git:<ref>:<env>.
+func parseDiffLocation(s string) (diffLocation, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) == 2 && parts[1] != "" {
+		switch parts[0] {
+		case diffLocationLocal, diffLocationRemote:
+			return diffLocation{kind: parts[0], env: parts[1]}, nil
+		case diffLocationGit:
+			// Git refs can't contain colons.
+			gitParts := strings.SplitN(parts[1], ":", 2)
+			if len(gitParts) == 2 && gitParts[0] != "" && gitParts[1] != "" {
+				return diffLocation{kind: diffLocationGit, ref: gitParts[0], env: gitParts[1]}, nil
+			}
+		}
+	}
+
+	return diffLocation{}, fmt.Errorf("<env> must be prefaced by %s:, %s: or %s:<ref>:, ex: %s:us-west/prod",
+		diffLocationLocal, diffLocationRemote, diffLocationGit, diffLocationRemote)
+}
+
+// name identifies the location in the diff output.
+func (l diffLocation) name() string {
+	if l.kind == diffLocationGit {
+		return fmt.Sprintf("%s:%s:%s", l.kind, l.ref, l.env)
 	}
+	return l.env
+}
 
-	// expect envs to be of the format local:myenv or remote:myenv
-	env1 := strings.SplitN(*envFq1, ":", 2)
-	env2 := strings.SplitN(*envFq2, ":", 2)
+func initDiffCmd(fs afero.Fs, cmd *cobra.Command, wd string, envFq1, envFq2 *string, files []string, diff kubecfg.Diff) (kubecfg.DiffCmd, error) {
+	if envFq2 == nil {
+		return initDiffSingleEnv(fs, *envFq1, diff, files, cmd, wd)
+	}
 
-	// validation
-	if len(env1) < 2 || len(env2) < 2 || (env1[0] != local && env1[0] != remote) || (env2[0] != local && env2[0] != remote) {
-		return nil, fmt.Errorf("<env> must be prefaced by %s: or %s:, ex: %s:us-west/prod", local, remote, remote)
+	loc1, err := parseDiffLocation(*envFq1)
+	if err != nil {
+		return nil, err
+	}
+	loc2, err := parseDiffLocation(*envFq2)
+	if err != nil {
+		return nil, err
 	}
 	if len(files) > 0 {
 		return nil, fmt.Errorf("'-f' is not currently supported for multiple environments")
@@ -164,32 +328,53 @@ func initDiffCmd(fs afero.Fs, cmd *cobra.Command, wd string, envFq1, envFq2 *str
 		return nil, err
 	}
 
-	if env1[0] == local && env2[0] == local {
-		return initDiffLocalCmd(fs, env1[1], env2[1], diffStrategy, cmd, manager)
-	}
+	isRemote1 := loc1.kind == diffLocationRemote
+	isRemote2 := loc2.kind == diffLocationRemote
 
-	if env1[0] == remote && env2[0] == remote {
-		return initDiffRemotesCmd(fs, env1[1], env2[1], diffStrategy, cmd, manager)
+	gcTag, err := cmd.Flags().GetString(flagGcTag)
+	if err != nil {
+		return nil, err
+	}
+	if gcTag != "" && isRemote1 == isRemote2 {
+		return nil, fmt.Errorf("--%s requires a %s location and a %s or %s location",
+			flagGcTag, diffLocationRemote, diffLocationLocal, diffLocationGit)
+	}
+	if diff.ThreeWay && isRemote1 == isRemote2 {
+		return nil, fmt.Errorf("--%s requires a %s location and a %s or %s location",
+			flagThreeWay, diffLocationRemote, diffLocationLocal, diffLocationGit)
 	}
 
-	localEnv := env1[1]
-	remoteEnv := env2[1]
-	if env1[0] == remote {
-		localEnv = env2[1]
-		remoteEnv = env1[1]
+	switch {
+	case isRemote1 && isRemote2:
+		return initDiffRemotesCmd(fs, loc1.env, loc2.env, diff, cmd, manager)
+	case isRemote1:
+		return initDiffRemoteCmd(fs, loc2, loc1.env, diff, cmd, manager)
+	case isRemote2:
+		return initDiffRemoteCmd(fs, loc1, loc2.env, diff, cmd, manager)
+	default:
+		return initDiffLocalCmd(fs, loc1, loc2, diff, cmd, manager)
 	}
-	return initDiffRemoteCmd(fs, localEnv, remoteEnv, diffStrategy, cmd, manager)
 }
 
 // initDiffSingleEnv sets up configurations for diffing using one environment
-func initDiffSingleEnv(fs afero.Fs, env, diffStrategy string, files []string, cmd *cobra.Command, wd string) (kubecfg.DiffCmd, error) {
+func initDiffSingleEnv(fs afero.Fs, env string, diff kubecfg.Diff, files []string, cmd *cobra.Command, wd string) (kubecfg.DiffCmd, error) {
 	c := kubecfg.DiffRemoteCmd{}
-	c.DiffStrategy = diffStrategy
+	c.Diff = diff
 	c.Client = &kubecfg.Client{}
 	var err error
 
-	if strings.HasPrefix(env, "remote:") || strings.HasPrefix(env, "local:") {
-		return nil, fmt.Errorf("single <env> argument with prefix 'local:' or 'remote:' not allowed")
+	if strings.HasPrefix(env, "remote:") || strings.HasPrefix(env, "local:") || strings.HasPrefix(env, "git:") {
+		return nil, fmt.Errorf("single <env> argument with prefix 'local:', 'remote:' or 'git:' not allowed")
+	}
+
+	manager, err := metadata.Find(wd)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Normalizer, err = diffNormalizer(fs, manager, env)
+	if err != nil {
+		return nil, err
 	}
 
 	te := newCmdObjExpander(cmdObjExpanderConfig{
@@ -208,25 +393,45 @@ func initDiffSingleEnv(fs afero.Fs, env, diffStrategy string, files []string, cm
 		return nil, err
 	}
 
+	c.GcTag, err = cmd.Flags().GetString(flagGcTag)
+	if err != nil {
+		return nil, err
+	}
+
+	c.GcUnlabeled, err = cmd.Flags().GetBool(flagGcUnlabeled)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Retry, err = retryOptions(cmd, fs, wd, env)
+	if err != nil {
+		return nil, err
+	}
+
 	return &c, nil
 }
 
 // initDiffLocalCmd sets up configurations for diffing between two sets of expanded Kubernetes objects locally
-func initDiffLocalCmd(fs afero.Fs, env1, env2, diffStrategy string, cmd *cobra.Command, m metadata.Manager) (kubecfg.DiffCmd, error) {
+func initDiffLocalCmd(fs afero.Fs, loc1, loc2 diffLocation, diff kubecfg.Diff, cmd *cobra.Command, m metadata.Manager) (kubecfg.DiffCmd, error) {
 	c := kubecfg.DiffLocalCmd{}
-	c.DiffStrategy = diffStrategy
+	c.Diff = diff
 	var err error
 
+	c.Normalizer, err = diffNormalizer(fs, m, loc1.env)
+	if err != nil {
+		return nil, err
+	}
+
 	c.Env1 = &kubecfg.LocalEnv{}
-	c.Env1.Name = env1
-	c.Env1.APIObjects, err = expandEnvObjs(fs, cmd, c.Env1.Name, m)
+	c.Env1.Name = loc1.name()
+	c.Env1.APIObjects, err = diffLocationObjs(fs, cmd, loc1, m)
 	if err != nil {
 		return nil, err
 	}
 
 	c.Env2 = &kubecfg.LocalEnv{}
-	c.Env2.Name = env2
-	c.Env2.APIObjects, err = expandEnvObjs(fs, cmd, c.Env2.Name, m)
+	c.Env2.Name = loc2.name()
+	c.Env2.APIObjects, err = diffLocationObjs(fs, cmd, loc2, m)
 	if err != nil {
 		return nil, err
 	}
@@ -235,9 +440,9 @@ func initDiffLocalCmd(fs afero.Fs, env1, env2, diffStrategy string, cmd *cobra.C
 }
 
 // initDiffRemotesCmd sets up configurations for diffing between objects on two remote clusters
-func initDiffRemotesCmd(fs afero.Fs, env1, env2, diffStrategy string, cmd *cobra.Command, m metadata.Manager) (kubecfg.DiffCmd, error) {
+func initDiffRemotesCmd(fs afero.Fs, env1, env2 string, diff kubecfg.Diff, cmd *cobra.Command, m metadata.Manager) (kubecfg.DiffCmd, error) {
 	c := kubecfg.DiffRemotesCmd{}
-	c.DiffStrategy = diffStrategy
+	c.Diff = diff
 
 	c.ClientA = &kubecfg.Client{}
 	c.ClientB = &kubecfg.Client{}
@@ -246,6 +451,11 @@ func initDiffRemotesCmd(fs afero.Fs, env1, env2, diffStrategy string, cmd *cobra
 	c.ClientB.Name = env2
 
 	var err error
+	c.Normalizer, err = diffNormalizer(fs, m, env1)
+	if err != nil {
+		return nil, err
+	}
+
 	c.ClientA.APIObjects, err = expandEnvObjs(fs, cmd, c.ClientA.Name, m)
 	if err != nil {
 		return nil, err
@@ -269,13 +479,18 @@ func initDiffRemotesCmd(fs afero.Fs, env1, env2, diffStrategy string, cmd *cobra
 }
 
 // initDiffRemoteCmd sets up configurations for diffing between local objects and objects on a remote cluster
-func initDiffRemoteCmd(fs afero.Fs, localEnv, remoteEnv, diffStrategy string, cmd *cobra.Command, m metadata.Manager) (kubecfg.DiffCmd, error) {
+func initDiffRemoteCmd(fs afero.Fs, localLoc diffLocation, remoteEnv string, diff kubecfg.Diff, cmd *cobra.Command, m metadata.Manager) (kubecfg.DiffCmd, error) {
 	c := kubecfg.DiffRemoteCmd{}
-	c.DiffStrategy = diffStrategy
+	c.Diff = diff
 	c.Client = &kubecfg.Client{}
 
 	var err error
-	c.Client.APIObjects, err = expandEnvObjs(fs, cmd, localEnv, m)
+	c.Normalizer, err = diffNormalizer(fs, m, localLoc.env)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Client.APIObjects, err = diffLocationObjs(fs, cmd, localLoc, m)
 	if err != nil {
 		return nil, err
 	}
@@ -285,40 +500,108 @@ func initDiffRemoteCmd(fs afero.Fs, localEnv, remoteEnv, diffStrategy string, cm
 		return nil, err
 	}
 
+	c.GcTag, err = cmd.Flags().GetString(flagGcTag)
+	if err != nil {
+		return nil, err
+	}
+
+	c.GcUnlabeled, err = cmd.Flags().GetBool(flagGcUnlabeled)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Retry, err = retryOptions(cmd, fs, m.Root(), remoteEnv)
+	if err != nil {
+		return nil, err
+	}
+
 	return &c, nil
 }
 
-// expandEnvObjs finds and expands templates for an environment
-func expandEnvObjs(fs afero.Fs, cmd *cobra.Command, env string, manager metadata.Manager) ([]*unstructured.Unstructured, error) {
-	expander, err := newExpander(fs, cmd)
+// diffNormalizer creates the normalizer for diffing objects of env. It uses
+// the OpenAPI schema of the environment's Kubernetes version and the ignore
+// rules of the app.
+func diffNormalizer(fs afero.Fs, m metadata.Manager, env string) (*kubecfg.Normalizer, error) {
+	spec, err := app.Read(fs, m.Root())
 	if err != nil {
 		return nil, err
 	}
 
-	envPath, vendorPath := manager.LibPaths()
-	libPath, mainPath, paramsPath, err := manager.EnvPaths(env)
+	var ignore map[string][]string
+	if spec.Diff != nil {
+		ignore = spec.Diff.Ignore
+	}
+
+	var schema *k8s.Schema
+	libPath, _, _, err := m.EnvPaths(env)
+	if err == nil {
+		schema, err = k8s.ReadSchema(fs, filepath.Join(libPath, "swagger.json"))
+	}
 	if err != nil {
-		return nil, err
+		log.Debugf("Unable to read the OpenAPI schema of environment %q, defaulted fields won't be ignored: %s", env, err)
+		schema = nil
 	}
-	componentPaths, err := manager.ComponentPaths()
+
+	n, err := kubecfg.NewNormalizer(schema, ignore)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid diff ignore rule in app.yaml")
+	}
+	return n, nil
+}
+
+// diffLocationObjs renders the objects of a local or git location.
+func diffLocationObjs(fs afero.Fs, cmd *cobra.Command, loc diffLocation, m metadata.Manager) ([]*unstructured.Unstructured, error) {
+	if loc.kind == diffLocationGit {
+		return gitEnvObjs(fs, cmd, loc.ref, loc.env, m)
+	}
+	return expandEnvObjs(fs, cmd, loc.env, m)
+}
+
+// gitEnvObjs renders the objects of an environment as the app was at the
+// git revision ref.
+func gitEnvObjs(fs afero.Fs, cmd *cobra.Command, ref, env string, m metadata.Manager) ([]*unstructured.Unstructured, error) {
+	gitFs, err := git.NewFs(m.Root(), ref)
 	if err != nil {
 		return nil, err
 	}
 
-	baseObj, err := constructBaseObj(componentPaths, nil)
+	ksApp, err := app.Load(gitFs, m.Root())
+	if err != nil {
+		return nil, errors.Wrapf(err, "load app at git revision %q", ref)
+	}
+
+	expander, err := newExpander(fs, cmd)
 	if err != nil {
 		return nil, err
 	}
-	params := importParams(string(paramsPath))
-	envSpec, err := importEnv(manager, env)
+
+	opts, err := evalOptions(expander)
 	if err != nil {
 		return nil, err
 	}
 
-	expander.FlagJpath = append([]string{string(vendorPath), string(libPath), string(envPath)}, expander.FlagJpath...)
-	expander.ExtCodes = append([]string{baseObj, params, envSpec}, expander.ExtCodes...)
+	// Only the app is read from git; jpaths are read from disk.
+	importer, err := component.NewImporter(ksApp, env, nil)
+	if err != nil {
+		return nil, err
+	}
+	importer.AddExternalPaths(fs, opts.JPaths...)
+	opts.Importer = importer
 
-	envFiles := []string{string(mainPath)}
+	objs, err := pipeline.New(ksApp, env, pipeline.WithEvalOptions(opts)).Objects(nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "render environment %q at git revision %q", env, ref)
+	}
+	return objs, nil
+}
 
-	return expander.Expand(envFiles)
+// expandEnvObjs renders the objects of an environment.
+func expandEnvObjs(fs afero.Fs, cmd *cobra.Command, env string, manager metadata.Manager) ([]*unstructured.Unstructured, error) {
+	te := newCmdObjExpander(cmdObjExpanderConfig{
+		fs:  fs,
+		cmd: cmd,
+		env: env,
+		cwd: manager.Root(),
+	})
+	return te.Expand()
 }
@@ -0,0 +1,62 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cmd
+
+import "testing"
+
+func Test_parseDiffLocation(t *testing.T) {
+	cases := []struct {
+		location string
+		expected diffLocation
+		name     string
+		isErr    bool
+	}{
+		{location: "local:dev", expected: diffLocation{kind: "local", env: "dev"}, name: "dev"},
+		{location: "remote:us-west/prod", expected: diffLocation{kind: "remote", env: "us-west/prod"}, name: "us-west/prod"},
+		{
+			location: "git:origin/master:prod",
+			expected: diffLocation{kind: "git", ref: "origin/master", env: "prod"},
+			name:     "git:origin/master:prod",
+		},
+		{location: "dev", isErr: true},
+		{location: "local:", isErr: true},
+		{location: "git:prod", isErr: true},
+		{location: "git::prod", isErr: true},
+		{location: "cluster:prod", isErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.location, func(t *testing.T) {
+			got, err := parseDiffLocation(tc.location)
+			if tc.isErr {
+				if err == nil {
+					t.Errorf("parseDiffLocation expected error, but none was received")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseDiffLocation returned unexpected error: %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("parseDiffLocation got %#v; expected %#v", got, tc.expected)
+			}
+			if got.name() != tc.name {
+				t.Errorf("name() got %q; expected %q", got.name(), tc.name)
+			}
+		})
+	}
+}
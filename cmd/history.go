@@ -0,0 +1,126 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ksonnet/ksonnet/client"
+	"github.com/ksonnet/ksonnet/pkg/kubecfg"
+)
+
+const (
+	flagHistoryStorage = "history-storage"
+	flagHistoryMax     = "history-max"
+	historyShortDesc   = "List the revisions applied to an environment"
+)
+
+var (
+	historyClientConfig *client.Config
+)
+
+func init() {
+	RootCmd.AddCommand(historyCmd)
+	historyClientConfig = client.NewDefaultClientConfig()
+	historyClientConfig.BindClientGoFlags(historyCmd)
+	bindHistoryStorageFlag(historyCmd)
+}
+
+// bindHistoryStorageFlag adds the flag selecting where release history is
+// stored.
+func bindHistoryStorageFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().String(flagHistoryStorage, kubecfg.HistoryStorageSecret,
+		fmt.Sprintf("Where release history is stored. One of: %s, %s, %s",
+			kubecfg.HistoryStorageSecret, kubecfg.HistoryStorageConfigMap, kubecfg.HistoryStorageNone))
+}
+
+// bindHistoryMaxFlag adds the flag limiting the number of revisions kept in
+// the release history.
+func bindHistoryMaxFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().Int(flagHistoryMax, kubecfg.DefaultHistoryMax,
+		"Number of revisions kept in the release history of the environment. 0 keeps every revision")
+}
+
+// historyMax returns the value of the flag added by bindHistoryMaxFlag.
+func historyMax(cmd *cobra.Command) (int, error) {
+	max, err := cmd.Flags().GetInt(flagHistoryMax)
+	if err != nil {
+		return 0, err
+	}
+	if max < 0 {
+		return 0, fmt.Errorf("--%s must not be negative", flagHistoryMax)
+	}
+	return max, nil
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history <env-name>",
+	Short: historyShortDesc,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("'history' requires an environment name; use `env list` to see available environments\n\n%s", cmd.UsageString())
+		}
+
+		flags := cmd.Flags()
+		var err error
+
+		c := kubecfg.HistoryCmd{}
+
+		c.HistoryStorage, err = flags.GetString(flagHistoryStorage)
+		if err != nil {
+			return err
+		}
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		c.App, err = appName(appFs, cwd)
+		if err != nil {
+			return err
+		}
+
+		c.ClientConfig = historyClientConfig
+		c.Env = args[0]
+
+		return c.Run(cmd.OutOrStdout())
+	},
+	Long: `
+The ` + "`history`" + ` command lists the revisions that were applied to an
+environment. Every ` + "`ks apply`" + ` records the applied objects, together with
+the time, the git commit of the app and the user, in a Secret (or ConfigMap) in
+the environment's destination namespace.
+
+Only the latest revisions are kept (see ` + "`--history-max`" + ` of ` + "`ks apply`" + `).
+ConfigMaps can be read by more users than Secrets, so revisions stored in
+ConfigMaps don't contain the values of Secrets, and can't be rolled back to if
+they include Secrets.
+
+### Related Commands
+
+* ` + "`ks apply` " + `— ` + applyShortDesc + `
+* ` + "`ks rollback` " + `— ` + rollbackShortDesc + `
+
+### Syntax
+`,
+	Example: `
+# List the revisions applied to the 'dev' environment
+ks history dev`,
+}
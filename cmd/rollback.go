@@ -0,0 +1,127 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ksonnet/ksonnet/client"
+	"github.com/ksonnet/ksonnet/pkg/kubecfg"
+)
+
+const (
+	rollbackShortDesc = "Re-apply a revision from the history of an environment"
+)
+
+var (
+	rollbackClientConfig *client.Config
+)
+
+func init() {
+	RootCmd.AddCommand(rollbackCmd)
+	rollbackClientConfig = client.NewDefaultClientConfig()
+	rollbackClientConfig.BindClientGoFlags(rollbackCmd)
+	bindHistoryStorageFlag(rollbackCmd)
+	bindHistoryMaxFlag(rollbackCmd)
+	bindRetryFlags(rollbackCmd)
+	rollbackCmd.PersistentFlags().Bool(flagDryRun, false, "Option to preview the list of operations without changing the cluster state")
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <env-name> [revision]",
+	Short: rollbackShortDesc,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("'rollback' requires an environment name and an optional revision\n\n%s", cmd.UsageString())
+		}
+
+		flags := cmd.Flags()
+		var err error
+
+		c := kubecfg.RollbackCmd{}
+
+		if len(args) == 2 {
+			c.Revision, err = strconv.Atoi(args[1])
+			if err != nil || c.Revision < 1 {
+				return fmt.Errorf("invalid revision %q", args[1])
+			}
+		}
+
+		c.HistoryStorage, err = flags.GetString(flagHistoryStorage)
+		if err != nil {
+			return err
+		}
+
+		c.HistoryMax, err = historyMax(cmd)
+		if err != nil {
+			return err
+		}
+
+		c.DryRun, err = flags.GetBool(flagDryRun)
+		if err != nil {
+			return err
+		}
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		c.App, err = appName(appFs, cwd)
+		if err != nil {
+			return err
+		}
+
+		c.ClientConfig = rollbackClientConfig
+		c.Env = args[0]
+
+		c.Retry, err = retryOptions(cmd, appFs, cwd, c.Env)
+		if err != nil {
+			return err
+		}
+
+		return c.Run(cwd)
+	},
+	Long: `
+The ` + "`rollback`" + ` command re-applies the objects stored for a revision of an
+environment (see ` + "`ks history`" + `). Objects of the environment that are not
+part of that revision are garbage collected. The rollback itself is recorded as
+a new revision.
+
+If no revision is given, the environment is rolled back to the revision before
+the latest one.
+
+### Related Commands
+
+* ` + "`ks history` " + `— ` + historyShortDesc + `
+* ` + "`ks apply` " + `— ` + applyShortDesc + `
+
+### Syntax
+`,
+	Example: `
+# Roll the 'dev' environment back to the previous revision
+ks rollback dev
+
+# Roll the 'dev' environment back to revision 3
+ks rollback dev 3
+
+# Preview the operations of rolling back to revision 3
+ks rollback dev 3 --dry-run`,
+}
@@ -25,12 +25,14 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
-	"github.com/ksonnet/ksonnet/env"
+	"github.com/ksonnet/ksonnet/component"
 	"github.com/ksonnet/ksonnet/metadata"
 	"github.com/ksonnet/ksonnet/metadata/app"
+	"github.com/ksonnet/ksonnet/pkg/kubecfg"
 	"github.com/ksonnet/ksonnet/pkg/pipeline"
 	"github.com/ksonnet/ksonnet/plugin"
 	str "github.com/ksonnet/ksonnet/strings"
@@ -55,6 +57,8 @@ const (
 	flagResolver   = "resolve-images"
 	flagResolvFail = "resolve-images-error"
 	flagAPISpec    = "api-spec"
+	flagRetries    = "retries"
+	flagRetryDelay = "retry-delay"
 
 	// For use in the commands (e.g., diff, apply, delete) that require either an
 	// environment or the -f flag.
@@ -242,6 +246,30 @@ func (f *logFormatter) Format(e *log.Entry) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// evalOptions returns the options components are evaluated with, as given
+// by the Jsonnet flags of expander. Paths given with --jpath are searched before the ones
+// in $KUBECFG_JPATH.
+func evalOptions(expander *template.Expander) (component.EvalOptions, error) {
+	var opts component.EvalOptions
+
+	opts.JPaths = append(expander.FlagJpath, expander.EnvJPath...)
+
+	vars, err := expander.Vars()
+	if err != nil {
+		return opts, err
+	}
+	opts.ExtVars = vars.Ext
+	opts.ExtCodes = vars.ExtCode
+	opts.TLAVars = vars.TLA
+
+	opts.Resolver, err = expander.BuildResolver()
+	if err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
 func newExpander(fs afero.Fs, cmd *cobra.Command) (*template.Expander, error) {
 	flags := cmd.Flags()
 	spec := template.NewExpander(fs)
@@ -334,22 +362,11 @@ func newCmdObjExpander(c cmdObjExpanderConfig) *cmdObjExpander {
 
 // Expands expands the templates.
 func (te *cmdObjExpander) Expand() ([]*unstructured.Unstructured, error) {
-	// expander, err := te.templateExpanderFn(te.config.fs, te.config.cmd)
-	// if err != nil {
-	// 	return nil, errors.Wrap(err, "template expander")
-	// }
-
-	manager, err := metadata.Find(te.config.cwd)
-	if err != nil {
-		return nil, errors.Wrap(err, "find metadata")
-	}
-
-	ksApp, err := manager.App()
+	p, err := te.pipeline()
 	if err != nil {
 		return nil, err
 	}
 
-	p := pipeline.New(ksApp, te.config.env)
 	return p.Objects(te.config.components)
 
 	// //
@@ -406,6 +423,50 @@ func (te *cmdObjExpander) Expand() ([]*unstructured.Unstructured, error) {
 
 }
 
+// ExpandLayers expands the templates, grouped by the dependencies of the
+// components they were rendered from.
+func (te *cmdObjExpander) ExpandLayers() ([][]*unstructured.Unstructured, error) {
+	p, err := te.pipeline()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Layers(te.config.components)
+}
+
+// pipeline creates the pipeline which renders the components.
+func (te *cmdObjExpander) pipeline() (*pipeline.Pipeline, error) {
+	expander, err := te.templateExpanderFn(te.config.fs, te.config.cmd)
+	if err != nil {
+		return nil, errors.Wrap(err, "template expander")
+	}
+
+	manager, err := metadata.Find(te.config.cwd)
+	if err != nil {
+		return nil, errors.Wrap(err, "find metadata")
+	}
+
+	ksApp, err := manager.App()
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := evalOptions(expander)
+	if err != nil {
+		return nil, err
+	}
+
+	pipelineOpts := []pipeline.Opt{pipeline.WithEvalOptions(opts)}
+	// Resolved images can change while the components don't, so they are
+	// only cached when images aren't resolved.
+	if expander.Resolver == "noop" {
+		cache := pipeline.NewCache(ksApp.Fs(), filepath.Join(ksApp.Root(), pipeline.CacheDir))
+		pipelineOpts = append(pipelineOpts, pipeline.WithCache(cache))
+	}
+
+	return pipeline.New(ksApp, te.config.env, pipelineOpts...), nil
+}
+
 // constructBaseObj constructs the base Jsonnet object that represents k-v
 // pairs of component name -> component imports. For example,
 //
@@ -484,29 +545,107 @@ func constructBaseObj(componentPaths, componentNames []string) (string, error) {
 	return fmt.Sprintf("%s=%s", metadata.ComponentsExtCodeKey, obj.String()), nil
 }
 
-func importParams(path string) string {
-	return fmt.Sprintf(`%s=import "%s"`, metadata.ParamsExtCodeKey, path)
+// appName returns the name of the ksonnet application containing wd. If the
+// application doesn't declare a name, the name of its root directory is used.
+func appName(fs afero.Fs, wd string) (string, error) {
+	manager, err := metadata.Find(wd)
+	if err != nil {
+		return "", errors.Wrap(err, "find metadata")
+	}
+
+	spec, err := app.Read(fs, manager.Root())
+	if err != nil {
+		return "", errors.Wrap(err, "read app spec")
+	}
+
+	if spec.Name != "" {
+		return spec.Name, nil
+	}
+
+	return filepath.Base(manager.Root()), nil
+}
+
+// bindRetryFlags adds the flags that override the retry settings of the
+// environment.
+func bindRetryFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().Int(flagRetries, kubecfg.DefaultRetries,
+		"How often to retry requests that failed with a conflict, throttling or server error. Overrides the environment's retry settings")
+	cmd.PersistentFlags().Duration(flagRetryDelay, kubecfg.DefaultRetryDelay,
+		"Delay before the first retry; it doubles with every retry. Overrides the environment's retry settings")
 }
 
-func importEnv(manager metadata.Manager, envName string) (string, error) {
-	app, err := manager.App()
+// retryOptions returns the retry options for envName. The environment's
+// settings in app.yaml are used, unless they are overridden with flags.
+func retryOptions(cmd *cobra.Command, fs afero.Fs, wd, envName string) (kubecfg.RetryOptions, error) {
+	opts := kubecfg.DefaultRetryOptions()
+
+	manager, err := metadata.Find(wd)
 	if err != nil {
-		return "", err
+		return opts, errors.Wrap(err, "find metadata")
 	}
 
-	spec, err := app.Environment(envName)
+	spec, err := app.Read(fs, manager.Root())
 	if err != nil {
-		return "", fmt.Errorf("Environment '%s' does not exist in app.yaml", envName)
+		return opts, errors.Wrap(err, "read app spec")
 	}
 
-	destination := env.NewDestination(spec.Destination.Server, spec.Destination.Namespace)
+	if env, ok := spec.Environments[envName]; ok && env.Retry != nil {
+		if env.Retry.Retries != nil {
+			opts.Retries = *env.Retry.Retries
+		}
+		if env.Retry.InitialDelay != "" {
+			if opts.InitialDelay, err = time.ParseDuration(env.Retry.InitialDelay); err != nil {
+				return opts, errors.Wrapf(err, "invalid retry initialDelay of environment %q", envName)
+			}
+		}
+		if env.Retry.MaxDelay != "" {
+			if opts.MaxDelay, err = time.ParseDuration(env.Retry.MaxDelay); err != nil {
+				return opts, errors.Wrapf(err, "invalid retry maxDelay of environment %q", envName)
+			}
+		}
+	}
+
+	flags := cmd.Flags()
+	if flags.Changed(flagRetries) {
+		if opts.Retries, err = flags.GetInt(flagRetries); err != nil {
+			return opts, err
+		}
+	}
+	if flags.Changed(flagRetryDelay) {
+		if opts.InitialDelay, err = flags.GetDuration(flagRetryDelay); err != nil {
+			return opts, err
+		}
+	}
 
-	marshalled, err := json.Marshal(&destination)
+	if opts.Retries < 0 {
+		return opts, errors.Errorf("the number of retries can't be negative")
+	}
+
+	return opts, nil
+}
+
+// bindEventOutputFlag adds the flag selecting the output format of commands
+// that report an event per object.
+func bindEventOutputFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringP(flagOutput, shortOutput, "", "Output format. Valid options: json")
+}
+
+// eventWriter returns the event writer selected with the output flag, or nil
+// if events are only logged.
+func eventWriter(cmd *cobra.Command) (*kubecfg.EventWriter, error) {
+	output, err := cmd.Flags().GetString(flagOutput)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return fmt.Sprintf(`%s=%s`, metadata.EnvExtCodeKey, string(marshalled)), nil
+	switch output {
+	case "":
+		return nil, nil
+	case "json":
+		return kubecfg.NewEventWriter(cmd.OutOrStdout()), nil
+	default:
+		return nil, errors.Errorf("invalid output format %q; valid options: json", output)
+	}
 }
 
 func appRoot() (string, error) {
@@ -26,6 +26,7 @@ import (
 
 const (
 	flagFormat    = "format"
+	flagRedact    = "redact"
 	showShortDesc = "Show expanded manifests for a specific environment."
 )
 
@@ -34,6 +35,7 @@ func init() {
 	addEnvCmdFlags(showCmd)
 	bindJsonnetFlags(showCmd)
 	showCmd.PersistentFlags().StringP(flagFormat, "o", "yaml", "Output format.  Supported values are: json, yaml")
+	showCmd.PersistentFlags().Bool(flagRedact, false, "Replace the values of Secrets with their hashes")
 }
 
 var showCmd = &cobra.Command{
@@ -53,6 +55,12 @@ This is the YAML version of what gets deployed to your cluster with
 When a component IS specified via the ` + "`-c`" + ` flag, this command only expands the
 manifest for that particular component.
 
+With ` + "`--redact`" + `, the values of the ` + "`data`" + ` and ` + "`stringData`" + ` fields of
+Secrets are replaced with a keyed hash, e.g. ` + "`<redacted hmac:1f2a...>`" + `. The key
+is random for every run of ` + "`ks`" + `, so the hashes can't be used to guess the
+secrets. Within one run, equal values have equal hashes. ` + "`ks diff`" + ` always
+redacts Secrets, and still shows which values changed.
+
 ### Related Commands
 
 * ` + "`ks validate` " + `— ` + valShortDesc + `
@@ -70,6 +78,9 @@ ks show prod -c redis -o json
 
 # Show multiple components from the 'dev' environment, in YAML
 ks show dev -c redis -c nginx-server
+
+# Show the 'dev' environment without revealing the values of Secrets
+ks show dev --redact
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) != 1 {
@@ -92,6 +103,11 @@ ks show dev -c redis -c nginx-server
 			return err
 		}
 
+		c.Redact, err = flags.GetBool(flagRedact)
+		if err != nil {
+			return err
+		}
+
 		cwd, err := os.Getwd()
 		if err != nil {
 			return err
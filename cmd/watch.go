@@ -0,0 +1,180 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/ksonnet/ksonnet/client"
+	"github.com/ksonnet/ksonnet/metadata"
+	"github.com/ksonnet/ksonnet/pkg/kubecfg"
+)
+
+const (
+	flagReconcile = "reconcile"
+
+	watchShortDesc = "Report when the objects of an environment drift from its components"
+)
+
+var (
+	watchClientConfig *client.Config
+)
+
+func init() {
+	RootCmd.AddCommand(watchCmd)
+
+	addEnvCmdFlags(watchCmd)
+	watchClientConfig = client.NewDefaultClientConfig()
+	watchClientConfig.BindClientGoFlags(watchCmd)
+	bindJsonnetFlags(watchCmd)
+	bindRetryFlags(watchCmd)
+	watchCmd.PersistentFlags().Bool(flagReconcile, false, "Apply objects again when they drift from their components")
+	watchCmd.PersistentFlags().String(flagGcTag, "", "The garbage collection tag to apply reconciled objects with; use the tag of `ks apply --"+flagGcTag+"`")
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch <env-name> [-c <component-name>] [--reconcile [--gc-tag <tag>]]",
+	Short: watchShortDesc,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("'watch' requires an environment name; use `env list` to see available environments\n\n%s", cmd.UsageString())
+		}
+		env := args[0]
+
+		flags := cmd.Flags()
+		var err error
+
+		c := kubecfg.WatchCmd{
+			ClientConfig: watchClientConfig,
+			Env:          env,
+			Debounce:     kubecfg.DefaultWatchDebounce,
+		}
+
+		c.Reconcile, err = flags.GetBool(flagReconcile)
+		if err != nil {
+			return err
+		}
+
+		c.GcTag, err = flags.GetString(flagGcTag)
+		if err != nil {
+			return err
+		}
+
+		componentNames, err := flags.GetStringArray(flagComponent)
+		if err != nil {
+			return err
+		}
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		c.App, err = appName(appFs, cwd)
+		if err != nil {
+			return err
+		}
+
+		c.Retry, err = retryOptions(cmd, appFs, cwd, env)
+		if err != nil {
+			return err
+		}
+
+		manager, err := metadata.Find(cwd)
+		if err != nil {
+			return err
+		}
+
+		c.Diff.DiffStrategy = kubecfg.DiffStrategyNormalized
+		c.Diff.Normalizer, err = diffNormalizer(appFs, manager, env)
+		if err != nil {
+			return err
+		}
+
+		for _, dir := range []string{"components", "environments", "vendor"} {
+			c.Paths = append(c.Paths, filepath.Join(manager.Root(), dir))
+		}
+
+		c.Render = func() ([]*unstructured.Unstructured, error) {
+			te := newCmdObjExpander(cmdObjExpanderConfig{
+				cmd:        cmd,
+				env:        env,
+				components: componentNames,
+				cwd:        cwd,
+			})
+			return te.Expand()
+		}
+
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			close(stop)
+		}()
+
+		return c.Run(cmd.OutOrStdout(), stop)
+	},
+	Long: `
+The ` + "`watch`" + ` command keeps watching an environment until it is interrupted.
+It reports every object whose live version in the cluster drifts from the
+version rendered from the components, and again when the object is back in
+sync.
+
+The environment is rendered again whenever a file in the ` + "`components/`" + `,
+` + "`environments/`" + ` or ` + "`vendor/`" + ` directory of the app changes. Errors
+while rendering are reported, and the last rendered objects are watched until
+they are fixed.
+
+Objects are compared like ` + "`ks diff --three-way`" + ` does. Fields that were
+changed in the cluster, but not in the components, are reported as
+` + "`drifted-in-cluster`" + `, and fields that were changed in both as
+` + "`conflicting`" + `. Objects without a last applied configuration are compared
+with the fields set in the components only. Deleted objects are reported as well.
+
+With ` + "`--reconcile`" + `, objects that drifted are applied again, like
+` + "`ks apply`" + ` would. Objects are not garbage collected. If the environment is
+applied with ` + "`ks apply --gc-tag`" + `, pass the same ` + "`--gc-tag`" + ` to ` + "`ks watch`" + `,
+or reconciling removes the tag from the objects and ` + "`ks apply`" + ` no longer
+garbage collects them.
+
+### Related Commands
+
+* ` + "`ks diff` " + `— ` + diffShortDesc + `
+* ` + "`ks apply` " + `— ` + applyShortDesc + `
+
+### Syntax
+`,
+	Example: `
+# Report drift of the objects of the 'dev' environment until interrupted.
+ks watch dev
+
+# Watch only the 'guestbook-ui' component of the 'dev' environment, and apply
+# it again whenever it drifts.
+ks watch dev -c guestbook-ui --reconcile
+
+# Reconcile the objects of the 'dev' environment, which is applied with
+# 'ks apply dev --gc-tag dev'.
+ks watch dev --reconcile --gc-tag dev
+`,
+}
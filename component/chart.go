@@ -0,0 +1,553 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package component
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+	jsonnet "github.com/google/go-jsonnet"
+	"github.com/ksonnet/ksonnet/metadata/app"
+	"github.com/ksonnet/ksonnet/pkg/params"
+	"github.com/ksonnet/ksonnet/pkg/util/k8s"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	amyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	// chartExt is the extension of chart components.
+	chartExt = ".chart.yaml"
+
+	chartTemplatesDir = "templates"
+	chartNotesFile    = "NOTES.txt"
+)
+
+// IsChart returns true if path is the file of a chart component.
+func IsChart(path string) bool {
+	return strings.HasSuffix(path, chartExt)
+}
+
+// Chart is a component which renders a Helm chart. The component file
+// points at a chart directory, usually under vendor:
+//
+//	chart: vendor/charts/redis
+//	releaseName: cache    # defaults to the name of the component
+//	values:               # overrides the values of the chart
+//	  cluster:
+//	    enabled: false
+//
+// The chart's templates are rendered locally with its values.yaml, the
+// values of the component file and the component's params, in that order
+// of precedence. Charts with dependencies aren't supported. Rendered charts
+// aren't cached, since the files of the chart aren't tracked.
+type Chart struct {
+	app        app.App
+	nsName     string
+	source     string
+	paramsPath string
+}
+
+var _ Component = (*Chart)(nil)
+
+// NewChart creates an instance of Chart.
+func NewChart(a app.App, nsName, source, paramsPath string) *Chart {
+	return &Chart{
+		app:        a,
+		nsName:     nsName,
+		source:     source,
+		paramsPath: paramsPath,
+	}
+}
+
+// Name is the name of this component.
+func (c *Chart) Name(wantsNameSpaced bool) string {
+	name := strings.TrimSuffix(filepath.Base(c.source), chartExt)
+	if !wantsNameSpaced {
+		return name
+	}
+
+	if c.nsName == "/" {
+		return name
+	}
+
+	return path.Join(c.nsName, name)
+}
+
+// chartSpec is the content of a chart component file.
+type chartSpec struct {
+	Chart       string                 `json:"chart"`
+	ReleaseName string                 `json:"releaseName"`
+	Values      map[string]interface{} `json:"values"`
+}
+
+func (c *Chart) readSpec() (*chartSpec, error) {
+	b, err := afero.ReadFile(c.app.Fs(), c.source)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec chartSpec
+	if err = yaml.Unmarshal(b, &spec); err != nil {
+		return nil, errors.Wrapf(err, "decode %s", c.source)
+	}
+	if spec.Chart == "" {
+		return nil, errors.Errorf("%s doesn't specify a chart", c.source)
+	}
+	if spec.ReleaseName == "" {
+		spec.ReleaseName = c.Name(false)
+	}
+
+	return &spec, nil
+}
+
+// Objects renders the chart to a slice of apimachinery unstructured objects.
+func (c *Chart) Objects(paramsStr, envName string, opts EvalOptions) ([]*unstructured.Unstructured, error) {
+	spec, err := c.readSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	if paramsStr == "" {
+		if paramsStr, err = c.readNamespaceParams(); err != nil {
+			return nil, err
+		}
+	}
+
+	componentParams, err := c.componentParams(paramsStr)
+	if err != nil {
+		return nil, errors.Wrap(err, "read params")
+	}
+
+	chartDir := spec.Chart
+	if !filepath.IsAbs(chartDir) {
+		chartDir = filepath.Join(c.app.Root(), chartDir)
+	}
+
+	ch, err := loadChart(c.app.Fs(), chartDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load chart %s", spec.Chart)
+	}
+
+	env, err := c.app.Environment(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	release := chartRelease{
+		Name:      spec.ReleaseName,
+		Service:   "ksonnet",
+		IsInstall: true,
+		Revision:  1,
+	}
+	if env.Destination != nil {
+		release.Namespace = env.Destination.Namespace
+	}
+
+	values := mergeValues(ch.values, spec.Values)
+	values = mergeValues(values, componentParams)
+
+	manifests, err := ch.render(values, release, newChartCapabilities(env.KubernetesVersion))
+	if err != nil {
+		return nil, errors.Wrapf(err, "render chart %s", spec.Chart)
+	}
+
+	var objects []runtime.Object
+	for _, m := range manifests {
+		decoded, err := decodeManifest(m.content)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode %s", m.name)
+		}
+		objects = append(objects, decoded...)
+	}
+
+	return k8s.FlattenToV1(objects)
+}
+
+// componentParams returns the params of the component in paramsStr.
+func (c *Chart) componentParams(paramsStr string) (map[string]interface{}, error) {
+	vm := jsonnet.MakeVM()
+	vm.ExtCode("__ksonnet/params", paramsStr)
+
+	snippet := fmt.Sprintf(`
+local params = std.extVar("__ksonnet/params");
+local components = if std.objectHasEx(params, %[1]q, true) then params[%[1]q] else {};
+if std.objectHasEx(components, %[2]q, true) then components[%[2]q] else {}`,
+		paramsComponentRoot, c.Name(false))
+	out, err := vm.EvaluateSnippet(c.paramsPath, snippet)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	if err = json.Unmarshal([]byte(out), &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// decodeManifest decodes the objects in a rendered YAML manifest.
+func decodeManifest(content string) ([]runtime.Object, error) {
+	decoder := amyaml.NewYAMLReader(bufio.NewReader(strings.NewReader(content)))
+
+	var objects []runtime.Object
+	for {
+		b, err := decoder.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		data, err := amyaml.ToJSON(b)
+		if err != nil {
+			return nil, err
+		}
+		if s := strings.TrimSpace(string(data)); s == "" || s == "null" {
+			continue
+		}
+
+		obj, _, err := unstructured.UnstructuredJSONScheme.Decode(data, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+
+	return objects, nil
+}
+
+// SetParam sets a parameter for a component. Nested values are set with a
+// path, e.g. `image.tag`.
+func (c *Chart) SetParam(path []string, value interface{}, options ParamOptions) error {
+	paramsData, err := c.readNamespaceParams()
+	if err != nil {
+		return err
+	}
+
+	updatedParams, err := params.Set(path, paramsData, c.Name(false), value, paramsComponentRoot)
+	if err != nil {
+		return err
+	}
+
+	return c.writeParams(updatedParams)
+}
+
+// DeleteParam deletes a param.
+func (c *Chart) DeleteParam(path []string, options ParamOptions) error {
+	paramsData, err := c.readNamespaceParams()
+	if err != nil {
+		return err
+	}
+
+	updatedParams, err := params.Delete(path, paramsData, c.Name(false), paramsComponentRoot)
+	if err != nil {
+		return err
+	}
+
+	return c.writeParams(updatedParams)
+}
+
+// Params returns params for a component.
+func (c *Chart) Params(envName string) ([]NamespaceParameter, error) {
+	paramsData, err := c.readParams(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	props, err := params.ToMap(c.Name(false), paramsData, paramsComponentRoot)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find components")
+	}
+
+	var nsParams []NamespaceParameter
+	for k, v := range props {
+		vStr, err := paramValue(v)
+		if err != nil {
+			return nil, err
+		}
+
+		nsParams = append(nsParams, NamespaceParameter{
+			Component: c.Name(false),
+			Key:       k,
+			Index:     "0",
+			Value:     vStr,
+		})
+	}
+
+	sort.Slice(nsParams, func(i, j int) bool {
+		return nsParams[i].Key < nsParams[j].Key
+	})
+
+	return nsParams, nil
+}
+
+// Summarize creates a summary for the component.
+func (c *Chart) Summarize() ([]Summary, error) {
+	return []Summary{
+		{
+			ComponentName: c.Name(false),
+			IndexStr:      "0",
+			Type:          "chart",
+		},
+	}, nil
+}
+
+func (c *Chart) readParams(envName string) (string, error) {
+	if envName == "" {
+		return c.readNamespaceParams()
+	}
+
+	ns, err := GetNamespace(c.app, c.nsName)
+	if err != nil {
+		return "", err
+	}
+
+	paramsStr, err := ns.ResolvedParams()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := c.app.EnvironmentParams(envName)
+	if err != nil {
+		return "", err
+	}
+
+	envParams := upgradeParams(envName, data)
+
+	vm := jsonnet.MakeVM()
+	vm.ExtCode("__ksonnet/params", paramsStr)
+	return vm.EvaluateSnippet("snippet", string(envParams))
+}
+
+func (c *Chart) readNamespaceParams() (string, error) {
+	b, err := afero.ReadFile(c.app.Fs(), c.paramsPath)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func (c *Chart) writeParams(src string) error {
+	return afero.WriteFile(c.app.Fs(), c.paramsPath, []byte(src), 0644)
+}
+
+// chart is a chart loaded from a directory.
+type chart struct {
+	metadata  chartMetadata
+	values    map[string]interface{}
+	templates map[string]string
+	files     chartFiles
+}
+
+// chartMetadata is the content of Chart.yaml. Templates refer to it as
+// .Chart.
+type chartMetadata struct {
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	AppVersion  string   `json:"appVersion"`
+	Description string   `json:"description"`
+	Home        string   `json:"home"`
+	Icon        string   `json:"icon"`
+	Keywords    []string `json:"keywords"`
+	Sources     []string `json:"sources"`
+	KubeVersion string   `json:"kubeVersion"`
+	APIVersion  string   `json:"apiVersion"`
+}
+
+// chartRelease describes the release templates are rendered for. Templates
+// refer to it as .Release.
+type chartRelease struct {
+	Name      string
+	Namespace string
+	Service   string
+	IsInstall bool
+	IsUpgrade bool
+	Revision  int
+}
+
+// chartManifest is a rendered template.
+type chartManifest struct {
+	name    string
+	content string
+}
+
+// loadChart loads the chart in dir.
+func loadChart(fs afero.Fs, dir string) (*chart, error) {
+	b, err := afero.ReadFile(fs, filepath.Join(dir, "Chart.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	ch := &chart{
+		values:    map[string]interface{}{},
+		templates: map[string]string{},
+		files:     chartFiles{},
+	}
+	if err = yaml.Unmarshal(b, &ch.metadata); err != nil {
+		return nil, errors.Wrap(err, "decode Chart.yaml")
+	}
+	if ch.metadata.Name == "" {
+		return nil, errors.New("Chart.yaml doesn't specify a name")
+	}
+
+	b, err = afero.ReadFile(fs, filepath.Join(dir, "values.yaml"))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err = yaml.Unmarshal(b, &ch.values); err != nil {
+			return nil, errors.Wrap(err, "decode values.yaml")
+		}
+		if ch.values == nil {
+			ch.values = map[string]interface{}{}
+		}
+	}
+
+	err = afero.Walk(fs, dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case strings.HasPrefix(rel, "charts/"), rel == "requirements.yaml":
+			return errors.Errorf("dependencies of charts aren't supported, found %s", rel)
+		case rel == "Chart.yaml", rel == "values.yaml":
+			return nil
+		}
+
+		b, err := afero.ReadFile(fs, path)
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(rel, chartTemplatesDir+"/") {
+			ch.templates[ch.metadata.Name+"/"+rel] = string(b)
+			return nil
+		}
+
+		ch.files[rel] = b
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// render renders the templates of the chart. Partials, whose names start
+// with an underscore, and NOTES.txt are only parsed. Manifests are sorted by
+// template name.
+func (ch *chart) render(values map[string]interface{}, release chartRelease, caps *chartCapabilities) ([]chartManifest, error) {
+	t := template.New("chart").Option("missingkey=zero")
+	t.Funcs(chartFuncs(t))
+
+	names := make([]string, 0, len(ch.templates))
+	for name := range ch.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := t.New(name).Parse(ch.templates[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	var manifests []chartManifest
+	for _, name := range names {
+		base := path.Base(name)
+		if strings.HasPrefix(base, "_") || base == chartNotesFile {
+			continue
+		}
+
+		data := map[string]interface{}{
+			"Values":       values,
+			"Release":      release,
+			"Chart":        ch.metadata,
+			"Capabilities": caps,
+			"Files":        ch.files,
+			"Template": map[string]interface{}{
+				"Name":     name,
+				"BasePath": path.Join(ch.metadata.Name, chartTemplatesDir),
+			},
+		}
+
+		var buf bytes.Buffer
+		if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+			return nil, err
+		}
+
+		content := strings.Replace(buf.String(), "<no value>", "", -1)
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+		manifests = append(manifests, chartManifest{name: name, content: content})
+	}
+
+	return manifests, nil
+}
+
+// mergeValues returns a copy of base with overrides merged into it. Maps are
+// merged recursively, and a null override removes a value.
+func mergeValues(base, overrides map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(base))
+	for k, v := range base {
+		out[k] = v
+	}
+
+	for k, v := range overrides {
+		if v == nil {
+			delete(out, k)
+			continue
+		}
+
+		if vm, ok := v.(map[string]interface{}); ok {
+			if bm, ok := out[k].(map[string]interface{}); ok {
+				out[k] = mergeValues(bm, vm)
+				continue
+			}
+		}
+
+		out[k] = v
+	}
+
+	return out
+}
@@ -0,0 +1,238 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package component
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/BurntSushi/toml"
+	"github.com/Masterminds/semver"
+	"github.com/Masterminds/sprig"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// chartFuncs returns the functions available to chart templates. They are the
+// functions of Helm: the sprig library without env and expandenv, which would
+// leak the environment of ks into the manifests, and the functions Helm adds
+// to it. include and tpl execute templates of t.
+func chartFuncs(t *template.Template) template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+
+	extra := template.FuncMap{
+		"include": func(name string, data interface{}) (string, error) {
+			var buf bytes.Buffer
+			err := t.ExecuteTemplate(&buf, name, data)
+			return buf.String(), err
+		},
+		"tpl": func(text string, data interface{}) (string, error) {
+			clone, err := t.Clone()
+			if err != nil {
+				return "", err
+			}
+			tt, err := clone.New("tpl").Parse(text)
+			if err != nil {
+				return "", err
+			}
+			var buf bytes.Buffer
+			err = tt.Execute(&buf, data)
+			return strings.Replace(buf.String(), "<no value>", "", -1), err
+		},
+		"required": func(msg string, v interface{}) (interface{}, error) {
+			if v == nil {
+				return nil, errors.New(msg)
+			}
+			if s, ok := v.(string); ok && s == "" {
+				return nil, errors.New(msg)
+			}
+			return v, nil
+		},
+
+		"toToml":   toTOML,
+		"toYaml":   toYAML,
+		"fromYaml": fromYAML,
+		"toJson":   toJSON,
+		"fromJson": fromJSON,
+	}
+	for name, f := range extra {
+		funcs[name] = f
+	}
+
+	return funcs
+}
+
+// toTOML encodes v. Like in Helm, errors are returned as the result.
+func toTOML(v interface{}) string {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return err.Error()
+	}
+	return buf.String()
+}
+
+func toYAML(v interface{}) string {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(string(b), "\n")
+}
+
+// fromYAML decodes s. Like in Helm, errors are returned in the Error key.
+func fromYAML(s string) map[string]interface{} {
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(s), &m); err != nil {
+		m["Error"] = err.Error()
+	}
+	return m
+}
+
+func toJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// fromJSON decodes s. Like in Helm, errors are returned in the Error key.
+func fromJSON(s string) map[string]interface{} {
+	m := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		m["Error"] = err.Error()
+	}
+	return m
+}
+
+// chartCapabilities describes the cluster templates are rendered for.
+// Templates refer to it as .Capabilities.
+type chartCapabilities struct {
+	KubeVersion chartKubeVersion
+	APIVersions chartAPIVersions
+}
+
+// chartKubeVersion is the version of Kubernetes.
+type chartKubeVersion struct {
+	Major      string
+	Minor      string
+	GitVersion string
+}
+
+// String returns the version, e.g. v1.8.0.
+func (v chartKubeVersion) String() string {
+	return v.GitVersion
+}
+
+// chartAPIVersions are the API versions supported by the cluster.
+type chartAPIVersions []string
+
+// Has returns true if version is supported.
+func (a chartAPIVersions) Has(version string) bool {
+	for _, v := range a {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// newChartCapabilities creates the capabilities of a cluster running
+// kubeVersion. The API versions are the ones ksonnet knows of.
+func newChartCapabilities(kubeVersion string) *chartCapabilities {
+	caps := &chartCapabilities{
+		KubeVersion: chartKubeVersion{GitVersion: kubeVersion},
+	}
+	if v, err := semver.NewVersion(kubeVersion); err == nil {
+		caps.KubeVersion.Major = strconv.FormatInt(v.Major(), 10)
+		caps.KubeVersion.Minor = strconv.FormatInt(v.Minor(), 10)
+	}
+
+	seen := map[string]bool{}
+	for gvk := range scheme.Scheme.AllKnownTypes() {
+		gv := gvk.GroupVersion().String()
+		if gvk.Version == "__internal" || seen[gv] {
+			continue
+		}
+		seen[gv] = true
+		caps.APIVersions = append(caps.APIVersions, gv)
+	}
+	sort.Strings(caps.APIVersions)
+
+	return caps
+}
+
+// chartFiles are the files of a chart other than its templates, by path.
+// Templates refer to them as .Files.
+type chartFiles map[string][]byte
+
+// Get returns the content of a file, or an empty string if it doesn't exist.
+func (f chartFiles) Get(name string) string {
+	return string(f[name])
+}
+
+// GetBytes returns the content of a file.
+func (f chartFiles) GetBytes(name string) []byte {
+	return f[name]
+}
+
+// Glob returns the files matching pattern.
+func (f chartFiles) Glob(pattern string) chartFiles {
+	out := chartFiles{}
+	for name, content := range f {
+		if ok, _ := path.Match(pattern, name); ok {
+			out[name] = content
+		}
+	}
+	return out
+}
+
+// AsConfig returns the files as the data of a ConfigMap, keyed by their base
+// names.
+func (f chartFiles) AsConfig() string {
+	m := map[string]string{}
+	for name, content := range f {
+		m[path.Base(name)] = string(content)
+	}
+	return toYAML(m)
+}
+
+// AsSecrets returns the files as the data of a Secret, keyed by their base
+// names.
+func (f chartFiles) AsSecrets() string {
+	m := map[string]string{}
+	for name, content := range f {
+		m[path.Base(name)] = base64.StdEncoding.EncodeToString(content)
+	}
+	return toYAML(m)
+}
+
+// Lines returns the lines of a file.
+func (f chartFiles) Lines(name string) []string {
+	if _, ok := f[name]; !ok {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(f[name]), "\n"), "\n")
+}
@@ -0,0 +1,293 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package component
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"text/template"
+
+	"github.com/ksonnet/ksonnet/metadata/app"
+	"github.com/ksonnet/ksonnet/metadata/app/mocks"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func stageChart(t *testing.T) (*mocks.App, afero.Fs) {
+	a, fs := appMock("/")
+	a.On("Environment", "default").Return(&app.EnvironmentSpec{
+		KubernetesVersion: "v1.8.0",
+		Destination:       &app.EnvironmentDestinationSpec{Namespace: "prod"},
+	}, nil)
+
+	files := []string{
+		"Chart.yaml",
+		"values.yaml",
+		"files/redis.conf",
+		"templates/_helpers.tpl",
+		"templates/cluster.yaml",
+		"templates/configmap.yaml",
+		"templates/deployment.yaml",
+		"templates/NOTES.txt",
+	}
+	for _, file := range files {
+		stageFile(t, fs, "chart/redis/"+file, "/vendor/charts/redis/"+file)
+	}
+	stageFile(t, fs, "chart/redis.chart.yaml", "/components/redis.chart.yaml")
+	stageFile(t, fs, "chart/params.libsonnet", "/components/params.libsonnet")
+
+	return a, fs
+}
+
+func TestChart_Name(t *testing.T) {
+	a, _ := appMock("/")
+
+	root := NewChart(a, "/", "/components/redis.chart.yaml", "/components/params.libsonnet")
+	require.Equal(t, "redis", root.Name(true))
+
+	nested := NewChart(a, "nested", "/components/nested/redis.chart.yaml", "/components/nested/params.libsonnet")
+	require.Equal(t, "nested/redis", nested.Name(true))
+	require.Equal(t, "redis", nested.Name(false))
+}
+
+func TestChart_Objects(t *testing.T) {
+	a, _ := stageChart(t)
+	c := NewChart(a, "/", "/components/redis.chart.yaml", "/components/params.libsonnet")
+
+	objects, err := c.Objects("", "default", EvalOptions{})
+	require.NoError(t, err)
+
+	expected := []string{
+		`{
+  "apiVersion": "v1",
+  "kind": "ConfigMap",
+  "metadata": {"name": "cache-redis"},
+  "data": {"redis.conf": "maxmemory 2mb\nmaxmemory-policy allkeys-lru"}
+}`,
+		`{
+  "apiVersion": "apps/v1beta2",
+  "kind": "Deployment",
+  "metadata": {
+    "name": "cache-redis",
+    "namespace": "prod",
+    "labels": {"chart": "redis-1.0.0", "heritage": "ksonnet"}
+  },
+  "spec": {
+    "replicas": 2,
+    "template": {
+      "spec": {
+        "containers": [
+          {"name": "redis", "image": "redis:4.0", "resources": {"limits": {"cpu": "100m"}}}
+        ]
+      }
+    }
+  }
+}`,
+	}
+
+	require.Len(t, objects, len(expected))
+	for i := range expected {
+		data, err := json.Marshal(objects[i].Object)
+		require.NoError(t, err)
+		require.JSONEq(t, expected[i], string(data))
+	}
+	require.Equal(t, int64(2), objects[1].Object["spec"].(map[string]interface{})["replicas"])
+}
+
+// TestChart_Objects_upstream renders the nginx example chart of Helm
+// v2.11.0, unchanged.
+func TestChart_Objects_upstream(t *testing.T) {
+	a, fs := appMock("/")
+	a.On("Environment", "default").Return(&app.EnvironmentSpec{
+		KubernetesVersion: "v1.8.0",
+		Destination:       &app.EnvironmentDestinationSpec{Namespace: "prod"},
+	}, nil)
+
+	files := []string{
+		".helmignore",
+		"Chart.yaml",
+		"README.md",
+		"values.yaml",
+		"templates/_helpers.tpl",
+		"templates/configmap.yaml",
+		"templates/deployment.yaml",
+		"templates/post-install-job.yaml",
+		"templates/pre-install-secret.yaml",
+		"templates/service-test.yaml",
+		"templates/service.yaml",
+	}
+	for _, file := range files {
+		stageFile(t, fs, "chart/nginx/"+file, "/vendor/charts/nginx/"+file)
+	}
+	stageFile(t, fs, "chart/nginx.chart.yaml", "/components/nginx.chart.yaml")
+	stageFile(t, fs, "chart/nginx-params.libsonnet", "/components/params.libsonnet")
+
+	c := NewChart(a, "/", "/components/nginx.chart.yaml", "/components/params.libsonnet")
+	objects, err := c.Objects("", "default", EvalOptions{})
+	require.NoError(t, err)
+
+	var got []string
+	for _, o := range objects {
+		got = append(got, o.GetKind()+" "+o.GetName())
+	}
+	require.Equal(t, []string{
+		"ConfigMap web-nginx",
+		"Deployment web-nginx",
+		"Job web-nginx",
+		"Secret web-nginx",
+		"Pod web-nginx-service-test",
+		"Service web-nginx",
+	}, got)
+
+	deployment := objects[1].Object
+	require.Equal(t, int64(2), deployment["spec"].(map[string]interface{})["replicas"])
+
+	secret := objects[3].Object
+	require.Equal(t, map[string]interface{}{"password": "c2VjcmV0", "username": "dXNlcjE="}, secret["data"])
+
+	port := objects[5].Object["spec"].(map[string]interface{})["ports"].([]interface{})[0]
+	require.Equal(t, int64(30080), port.(map[string]interface{})["nodePort"])
+}
+
+func TestChart_Objects_dependencies(t *testing.T) {
+	a, fs := stageChart(t)
+	require.NoError(t, afero.WriteFile(fs, "/vendor/charts/redis/charts/common/Chart.yaml", []byte("name: common"), 0644))
+
+	c := NewChart(a, "/", "/components/redis.chart.yaml", "/components/params.libsonnet")
+	_, err := c.Objects("", "default", EvalOptions{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "dependencies of charts aren't supported")
+}
+
+func TestChart_Params(t *testing.T) {
+	a, _ := stageChart(t)
+	c := NewChart(a, "/", "/components/redis.chart.yaml", "/components/params.libsonnet")
+
+	params, err := c.Params("")
+	require.NoError(t, err)
+
+	expected := []NamespaceParameter{
+		{Component: "redis", Index: "0", Key: "image", Value: `{"tag":"4.0"}`},
+		{Component: "redis", Index: "0", Key: "resources", Value: `{"limits":{"cpu":"100m"}}`},
+	}
+	require.Equal(t, expected, params)
+}
+
+func TestChart_SetParam(t *testing.T) {
+	a, _ := stageChart(t)
+	c := NewChart(a, "/", "/components/redis.chart.yaml", "/components/params.libsonnet")
+
+	require.NoError(t, c.SetParam([]string{"image", "tag"}, "5.0", ParamOptions{}))
+	require.NoError(t, c.DeleteParam([]string{"resources"}, ParamOptions{}))
+
+	params, err := c.Params("")
+	require.NoError(t, err)
+	require.Equal(t, []NamespaceParameter{
+		{Component: "redis", Index: "0", Key: "image", Value: `{"tag":"5.0"}`},
+	}, params)
+}
+
+func TestFilesystemNamespace_Components_chart(t *testing.T) {
+	a, _ := stageChart(t)
+
+	ns := NewNamespace(a, "/")
+	components, err := ns.Components()
+	require.NoError(t, err)
+	require.Len(t, components, 1)
+	require.IsType(t, &Chart{}, components[0])
+	require.Equal(t, "redis", components[0].Name(true))
+}
+
+func Test_semverCompare(t *testing.T) {
+	cases := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{constraint: ">=1.8-0", version: "v1.8.0", expected: true},
+		{constraint: ">=1.8-0", version: "v1.9.3-gke.0", expected: true},
+		{constraint: ">=1.8-0", version: "v1.7.5"},
+		{constraint: ">= 1.4.0, < 1.8", version: "1.7.0", expected: true},
+		{constraint: ">= 1.4.0, < 1.8", version: "1.8.0"},
+		{constraint: "1.7", version: "v1.7.0", expected: true},
+		{constraint: "^1.9-0", version: "v1.9.3-gke.0", expected: true},
+		{constraint: "^1.9-0", version: "v1.11.0", expected: true},
+		{constraint: "^1.9-0", version: "v1.8.4"},
+		{constraint: "^1.9-0", version: "v2.0.0"},
+		{constraint: "~1.9.2", version: "v1.9.5", expected: true},
+		{constraint: "~1.9.2", version: "v1.10.0"},
+		{constraint: "<1.8 || >=1.10", version: "v1.7.0", expected: true},
+		{constraint: "<1.8 || >=1.10", version: "v1.9.0"},
+		{constraint: "<1.8 || >=1.10", version: "v1.10.1", expected: true},
+	}
+
+	semverCompare := chartFuncs(template.New("test"))["semverCompare"].(func(string, string) (bool, error))
+
+	for _, tc := range cases {
+		t.Run(tc.constraint+" "+tc.version, func(t *testing.T) {
+			got, err := semverCompare(tc.constraint, tc.version)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, got)
+		})
+	}
+
+	_, err := semverCompare("=~1.9", "v1.9.0")
+	require.Error(t, err)
+
+	_, err = semverCompare(">=1.8", "latest")
+	require.Error(t, err)
+}
+
+func TestChartFuncs(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected string
+	}{
+		{text: `{{ randAlphaNum 10 | len }}`, expected: "10"},
+		{text: `{{ regexReplaceAll "a(x*)b" "-ab-axxb-" "${1}W" }}`, expected: "-W-xxW-"},
+		{text: `{{ $d := dict "a" 1 }}{{ $_ := merge $d (dict "a" 2 "b" 3) }}{{ toJson $d }}`, expected: `{"a":1,"b":3}`},
+		{text: `{{ sha1sum "abc" }}`, expected: "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{text: `{{ now | date "2006" | len }}`, expected: "4"},
+		{text: `{{ uuidv4 | len }}`, expected: "36"},
+		{text: `{{ (splitn "." 2 "a.b.c")._1 }}`, expected: "b.c"},
+		{text: `{{ splitList "," "a,b,c" | first }}-{{ splitList "," "a,b,c" | last }}`, expected: "a-c"},
+		{text: `{{ $ca := genCA "ca" 365 }}{{ hasPrefix "-----BEGIN CERTIFICATE-----" $ca.Cert }}`, expected: "true"},
+		{text: `{{ semverCompare "^1.9-0" "v1.10.2-gke.0" }}`, expected: "true"},
+		{text: `{{ toToml (dict "a" "b") }}`, expected: "a = \"b\"\n"},
+		{text: `{{ include "sub" 1 }}`, expected: "sub 1"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.text, func(t *testing.T) {
+			tmpl := template.New("test")
+			tmpl.Funcs(chartFuncs(tmpl))
+			_, err := tmpl.New("sub").Parse(`sub {{ . }}`)
+			require.NoError(t, err)
+			_, err = tmpl.New("main").Parse(tc.text)
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			require.NoError(t, tmpl.ExecuteTemplate(&buf, "main", nil))
+			require.Equal(t, tc.expected, buf.String())
+		})
+	}
+
+	// Templates can't read the environment of ks.
+	funcs := chartFuncs(template.New("test"))
+	require.NotContains(t, funcs, "env")
+	require.NotContains(t, funcs, "expandenv")
+}
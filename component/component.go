@@ -19,7 +19,9 @@ import (
 	"path/filepath"
 	"strings"
 
+	jsonnet "github.com/google/go-jsonnet"
 	"github.com/ksonnet/ksonnet/metadata/app"
+	"github.com/ksonnet/ksonnet/utils"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
@@ -52,7 +54,7 @@ type Component interface {
 	// Name is the component name.
 	Name(wantsNamedSpaced bool) string
 	// Objects converts the component to a set of objects.
-	Objects(paramsStr, envName string) ([]*unstructured.Unstructured, error)
+	Objects(paramsStr, envName string, opts EvalOptions) ([]*unstructured.Unstructured, error)
 	// SetParams sets a component paramaters.
 	SetParam(path []string, value interface{}, options ParamOptions) error
 	// DeleteParam deletes a component parameter.
@@ -64,11 +66,54 @@ type Component interface {
 	Summarize() ([]Summary, error)
 }
 
+// EvalOptions configures how components are evaluated.
+type EvalOptions struct {
+	// JPaths are additional Jsonnet library search paths. They are searched
+	// in order, after the paths of the app.
+	JPaths []string
+	// ExtVars are external variables, by name.
+	ExtVars map[string]string
+	// ExtCodes are external variables containing Jsonnet code, by name.
+	ExtCodes map[string]string
+	// TLAVars are top level arguments, by name.
+	TLAVars map[string]string
+	// Resolver resolves images for the resolveImage native function. If it
+	// is nil, images are returned as is.
+	Resolver utils.Resolver
+	// Importer resolves the imports of Jsonnet components. Sharing one
+	// between components means every file is only read and parsed once. If
+	// it is nil, each component creates its own, see NewImporter.
+	Importer jsonnet.Importer
+}
+
+// ConfigureVM sets the external variables, top level arguments and native
+// functions of vm.
+func (o EvalOptions) ConfigureVM(vm *jsonnet.VM) {
+	for k, v := range o.ExtVars {
+		vm.ExtVar(k, v)
+	}
+	for k, v := range o.ExtCodes {
+		vm.ExtCode(k, v)
+	}
+	for k, v := range o.TLAVars {
+		vm.TLAVar(k, v)
+	}
+
+	resolver := o.Resolver
+	if resolver == nil {
+		resolver = utils.NewIdentityResolver()
+	}
+	utils.RegisterNativeFuncs(vm, resolver)
+}
+
 const (
 	// componentsDir is the name of the directory which houses components.
 	componentsRoot = "components"
 	// paramsFile is the params file for a component namespace.
 	paramsFile = "params.libsonnet"
+	// vendorDir is the name of the directory which houses installed
+	// packages.
+	vendorDir = "vendor"
 )
 
 // LocateComponent locates a component given a nsName and a name.
@@ -100,6 +145,9 @@ func Path(a app.App, name string) (string, error) {
 		}
 
 		base := strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name()))
+		if IsChart(fi.Name()) {
+			base = strings.TrimSuffix(fi.Name(), chartExt)
+		}
 		if _, ok := files[base]; ok {
 			return "", errors.Errorf("Found multiple component files with component name %q", name)
 		}
@@ -27,6 +27,7 @@ import (
 	jsonnet "github.com/google/go-jsonnet"
 	"github.com/ksonnet/ksonnet/metadata/app"
 	"github.com/ksonnet/ksonnet/pkg/params"
+	jsonnetutil "github.com/ksonnet/ksonnet/pkg/util/jsonnet"
 	"github.com/ksonnet/ksonnet/pkg/util/k8s"
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
@@ -69,41 +70,38 @@ func (j *Jsonnet) Name(wantsNameSpaced bool) string {
 	return path.Join(j.nsName, name)
 }
 
-func (j *Jsonnet) vmImporter(envName string) (*jsonnet.MemoryImporter, error) {
-	libPath, err := j.app.LibPath(envName)
+// Source is the path of the file the component is defined in.
+func (j *Jsonnet) Source() string {
+	return j.source
+}
+
+// NewImporter creates the importer for the components of an environment.
+// Imports are resolved against the directory of the importing file, the
+// app's lib directory, the vendored packages, the ksonnet library of the
+// environment and finally jpaths.
+func NewImporter(a app.App, envName string, jpaths []string) (*jsonnetutil.Importer, error) {
+	libPath, err := a.LibPath(envName)
 	if err != nil {
 		return nil, err
 	}
 
-	readString := func(path string) (string, error) {
-		filename := filepath.Join(libPath, path)
-		var b []byte
-
-		b, err = afero.ReadFile(j.app.Fs(), filename)
-		if err != nil {
-			return "", err
-		}
-
-		return string(b), nil
+	searchPaths := []string{
+		filepath.Join(a.Root(), app.LibDirName),
+		filepath.Join(a.Root(), vendorDir),
+		libPath,
 	}
+	searchPaths = append(searchPaths, jpaths...)
 
-	dataK, err := readString("k.libsonnet")
-	if err != nil {
-		return nil, err
-	}
-	dataK8s, err := readString("k8s.libsonnet")
-	if err != nil {
-		return nil, err
-	}
+	return jsonnetutil.NewImporter(a.Fs(), searchPaths...), nil
+}
 
-	importer := &jsonnet.MemoryImporter{
-		Data: map[string]string{
-			"k.libsonnet":   dataK,
-			"k8s.libsonnet": dataK8s,
-		},
+// vmImporter returns the importer of opts, or creates one for the
+// component.
+func (j *Jsonnet) vmImporter(envName string, opts EvalOptions) (jsonnet.Importer, error) {
+	if opts.Importer != nil {
+		return opts.Importer, nil
 	}
-
-	return importer, nil
+	return NewImporter(j.app, envName, opts.JPaths)
 }
 
 func jsonWalk(obj interface{}) ([]interface{}, error) {
@@ -137,14 +135,15 @@ func jsonWalk(obj interface{}) ([]interface{}, error) {
 }
 
 // Objects converts jsonnet to a slice of apimachinery unstructured objects.
-func (j *Jsonnet) Objects(paramsStr, envName string) ([]*unstructured.Unstructured, error) {
-	importer, err := j.vmImporter(envName)
+func (j *Jsonnet) Objects(paramsStr, envName string, opts EvalOptions) ([]*unstructured.Unstructured, error) {
+	importer, err := j.vmImporter(envName, opts)
 	if err != nil {
 		return nil, err
 	}
 
 	vm := jsonnet.MakeVM()
 	vm.Importer(importer)
+	opts.ConfigureVM(vm)
 	vm.ExtCode("__ksonnet/params", paramsStr)
 
 	snippet, err := afero.ReadFile(j.app.Fs(), j.source)
@@ -237,7 +236,7 @@ func (j *Jsonnet) Params(envName string) ([]NamespaceParameter, error) {
 
 	var params []NamespaceParameter
 	for k, v := range props {
-		vStr, err := j.paramValue(v)
+		vStr, err := paramValue(v)
 		if err != nil {
 			return nil, err
 		}
@@ -258,7 +257,8 @@ func (j *Jsonnet) Params(envName string) ([]NamespaceParameter, error) {
 	return params, nil
 }
 
-func (j *Jsonnet) paramValue(v interface{}) (string, error) {
+// paramValue formats a param value for display.
+func paramValue(v interface{}) (string, error) {
 	switch v.(type) {
 	default:
 		s := fmt.Sprintf("%v", v)
@@ -18,6 +18,7 @@ package component
 import (
 	"testing"
 
+	"github.com/ksonnet/ksonnet/utils"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/require"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -92,7 +93,7 @@ func TestJsonnet_Objects(t *testing.T) {
 
 	paramsStr := testdata(t, "guestbook/params.libsonnet")
 
-	list, err := c.Objects(string(paramsStr), "default")
+	list, err := c.Objects(string(paramsStr), "default", EvalOptions{})
 	require.NoError(t, err)
 
 	expected := []*unstructured.Unstructured{
@@ -276,3 +277,92 @@ func TestJsonnet_DeleteParam(t *testing.T) {
 
 	require.Equal(t, string(expected), string(b))
 }
+
+func TestJsonnet_Objects_imports(t *testing.T) {
+	app, fs := appMock("/app")
+
+	files := map[string]string{
+		"/app/components/cm.jsonnet": `
+local mixin = import "incubator/mixin/mixin.libsonnet";
+local shared = import "shared.libsonnet";
+local extra = import "extra.libsonnet";
+mixin.configMap(shared.name, import "sibling.libsonnet", extra)`,
+		"/app/components/sibling.libsonnet":             `{ a: "sibling" }`,
+		"/app/lib/shared.libsonnet":                     `{ name: "lib" }`,
+		"/app/vendor/shared.libsonnet":                  `{ name: "vendor" }`,
+		"/app/vendor/incubator/mixin/mixin.libsonnet":   `import "helpers.libsonnet"`,
+		"/app/vendor/incubator/mixin/helpers.libsonnet": `{ configMap(name, data, extra):: { apiVersion: "v1", kind: "ConfigMap", metadata: { name: name }, data: data + extra } }`,
+		"/jpath/extra.libsonnet":                        `{ b: "jpath" }`,
+	}
+	for path, content := range files {
+		require.NoError(t, afero.WriteFile(fs, path, []byte(content), 0644))
+	}
+
+	c := NewJsonnet(app, "", "/app/components/cm.jsonnet", "/app/components/params.libsonnet")
+
+	list, err := c.Objects("{}", "default", EvalOptions{JPaths: []string{"/jpath"}})
+	require.NoError(t, err)
+
+	expected := []*unstructured.Unstructured{
+		{
+			Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name": "lib",
+				},
+				"data": map[string]interface{}{
+					"a": "sibling",
+					"b": "jpath",
+				},
+			},
+		},
+	}
+	require.Equal(t, expected, list)
+
+	_, err = c.Objects("{}", "default", EvalOptions{})
+	require.Error(t, err)
+}
+
+type prefixResolver struct{}
+
+func (prefixResolver) Resolve(image *utils.ImageName) error {
+	image.Registry = "registry.example.com"
+	return nil
+}
+
+func TestJsonnet_Objects_evalOptions(t *testing.T) {
+	app, fs := appMock("/app")
+
+	src := `
+function(name) {
+  apiVersion: "v1",
+  kind: "ConfigMap",
+  metadata: { name: name },
+  data: {
+    env: std.extVar("env"),
+    replicas: std.toString(std.extVar("replicas") + 1),
+    image: std.native("resolveImage")("nginx:1.13"),
+  },
+}`
+	require.NoError(t, afero.WriteFile(fs, "/app/components/cm.jsonnet", []byte(src), 0644))
+
+	c := NewJsonnet(app, "", "/app/components/cm.jsonnet", "/app/components/params.libsonnet")
+
+	opts := EvalOptions{
+		ExtVars:  map[string]string{"env": "prod"},
+		ExtCodes: map[string]string{"replicas": "2"},
+		TLAVars:  map[string]string{"name": "cfg"},
+		Resolver: prefixResolver{},
+	}
+	list, err := c.Objects("{}", "default", opts)
+	require.NoError(t, err)
+
+	require.Len(t, list, 1)
+	require.Equal(t, "cfg", list[0].GetName())
+	require.Equal(t, map[string]interface{}{
+		"env":      "prod",
+		"replicas": "3",
+		"image":    "registry.example.com/nginx:1.13",
+	}, list[0].Object["data"])
+}
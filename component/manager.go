@@ -133,7 +133,7 @@ func (dm *defaultManager) checkComponent(ksApp app.App, name string) (string, st
 	base := filepath.Join(append([]string{ksApp.Root(), componentsRoot}, parts...)...)
 	base = filepath.Clean(base)
 
-	exts := []string{".yaml", ".jsonnet", ".json"}
+	exts := []string{chartExt, ".yaml", ".jsonnet", ".json"}
 	for _, ext := range exts {
 		exists, err := afero.Exists(ksApp.Fs(), base+ext)
 		if err != nil {
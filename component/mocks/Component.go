@@ -53,13 +53,13 @@ func (_m *Component) Name(wantsNamedSpaced bool) string {
 	return r0
 }
 
-// Objects provides a mock function with given fields: paramsStr, envName
-func (_m *Component) Objects(paramsStr string, envName string) ([]*unstructured.Unstructured, error) {
-	ret := _m.Called(paramsStr, envName)
+// Objects provides a mock function with given fields: paramsStr, envName, opts
+func (_m *Component) Objects(paramsStr string, envName string, opts component.EvalOptions) ([]*unstructured.Unstructured, error) {
+	ret := _m.Called(paramsStr, envName, opts)
 
 	var r0 []*unstructured.Unstructured
-	if rf, ok := ret.Get(0).(func(string, string) []*unstructured.Unstructured); ok {
-		r0 = rf(paramsStr, envName)
+	if rf, ok := ret.Get(0).(func(string, string, component.EvalOptions) []*unstructured.Unstructured); ok {
+		r0 = rf(paramsStr, envName, opts)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*unstructured.Unstructured)
@@ -67,8 +67,8 @@ func (_m *Component) Objects(paramsStr string, envName string) ([]*unstructured.
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(paramsStr, envName)
+	if rf, ok := ret.Get(1).(func(string, string, component.EvalOptions) error); ok {
+		r1 = rf(paramsStr, envName, opts)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -266,6 +266,11 @@ func (n *FilesystemNamespace) Components() ([]Component, error) {
 		ext := filepath.Ext(fi.Name())
 		path := filepath.Join(nsDir, fi.Name())
 
+		if IsChart(path) {
+			components = append(components, NewChart(n.app, n.Name(), path, n.ParamsPath()))
+			continue
+		}
+
 		switch ext {
 		// TODO: these should be constants
 		case ".yaml", ".json":
@@ -34,7 +34,8 @@ local params = std.extVar("params");
 local applyGlobal = function(key, value) std.mergePatch(value, params.global);
 
 {
-	components: std.mapWithKey(applyGlobal, params.components)
+	components: std.mapWithKey(applyGlobal, params.components),
+	[if std.objectHasEx(params, "dependencies", true) then "dependencies"]: params.dependencies,
 }
 `
 
@@ -0,0 +1,8 @@
+{
+  global: {},
+  components: {
+    nginx: {
+      replicaCount: 2,
+    },
+  },
+}
@@ -0,0 +1,9 @@
+{
+  global: {},
+  components: {
+    redis: {
+      image: { tag: "4.0" },
+      resources: { limits: { cpu: "100m" } },
+    },
+  },
+}
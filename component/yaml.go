@@ -117,6 +117,11 @@ func (y *YAML) Name(wantsNameSpaced bool) string {
 	return path.Join(y.nsName, name)
 }
 
+// Source is the path of the file the component is defined in.
+func (y *YAML) Source() string {
+	return y.source
+}
+
 // Params returns params for a component.
 func (y *YAML) Params(envName string) ([]NamespaceParameter, error) {
 	libPath, err := y.app.LibPath("default")
@@ -268,7 +273,7 @@ func (y *YAML) paramValues(componentName, index string, valueMap map[string]Valu
 // based component are keyed like, `name-id`, where `name` is the file name sans the extension,
 // and the id is the position within the file (starting at 0). Params are named this way
 // because a YAML file can contain more than one object.
-func (y *YAML) Objects(paramsStr, envName string) ([]*unstructured.Unstructured, error) {
+func (y *YAML) Objects(paramsStr, envName string, opts EvalOptions) ([]*unstructured.Unstructured, error) {
 	if paramsStr == "" {
 		dir := filepath.Dir(y.source)
 		paramsFile := filepath.Join(dir, "params.libsonnet")
@@ -113,7 +113,7 @@ func TestYAML_Objects_no_params(t *testing.T) {
 
 	y := NewYAML(app, "", "/certificate-crd.yaml", "/params.libsonnet")
 
-	list, err := y.Objects("", "")
+	list, err := y.Objects("", "", EvalOptions{})
 	require.NoError(t, err)
 
 	expected := []*unstructured.Unstructured{
@@ -153,7 +153,7 @@ func TestYAML_Objects_no_params_with_json(t *testing.T) {
 
 	y := NewYAML(app, "", "/certificate-crd.json", "/params.libsonnet")
 
-	list, err := y.Objects("", "")
+	list, err := y.Objects("", "", EvalOptions{})
 	require.NoError(t, err)
 
 	expected := []*unstructured.Unstructured{
@@ -194,7 +194,7 @@ func TestYAML_Objects_params_exist_with_no_entry(t *testing.T) {
 
 	y := NewYAML(app, "", "/certificate-crd.yaml", "/params.libsonnet")
 
-	list, err := y.Objects("", "")
+	list, err := y.Objects("", "", EvalOptions{})
 	require.NoError(t, err)
 
 	expected := []*unstructured.Unstructured{
@@ -235,7 +235,7 @@ func TestYAML_Objects_params_exist_with_entry(t *testing.T) {
 
 	y := NewYAML(app, "", "/certificate-crd.yaml", "/params.libsonnet")
 
-	list, err := y.Objects("", "")
+	list, err := y.Objects("", "", EvalOptions{})
 	require.NoError(t, err)
 
 	expected := []*unstructured.Unstructured{
@@ -65,6 +65,15 @@ type Spec struct {
 	Environments EnvironmentSpecs `json:"environments,omitempty"`
 	Libraries    LibraryRefSpecs  `json:"libraries,omitempty"`
 	License      string           `json:"license,omitempty"`
+	Diff         *DiffSpec        `json:"diff,omitempty"`
+}
+
+// DiffSpec configures how `ks diff` compares objects.
+type DiffSpec struct {
+	// Ignore maps a kind, or "*" for every kind, to the paths of fields that
+	// are ignored when objects of that kind are compared, e.g.
+	// `spec.replicas`.
+	Ignore map[string][]string `json:"ignore,omitempty"`
 }
 
 // Read will return the specification for a ksonnet application. It will navigate up directories
@@ -154,6 +163,22 @@ type EnvironmentSpec struct {
 	// Targets contain the relative component paths that this environment
 	// wishes to deploy on it's destination.
 	Targets []string `json:"targets,omitempty"`
+	// Retry configures how requests to the cluster are retried when they
+	// fail with a transient error.
+	Retry *RetrySpec `json:"retry,omitempty" yaml:"retry,omitempty"`
+}
+
+// RetrySpec contains the specification for retrying requests that failed
+// with conflicts, throttling or server errors.
+type RetrySpec struct {
+	// Retries is how often a request is retried before giving up.
+	Retries *int `json:"retries,omitempty"`
+	// InitialDelay is the delay before the first retry, as a duration
+	// string. The delay doubles with every retry.
+	InitialDelay string `json:"initialDelay,omitempty"`
+	// MaxDelay is the longest delay between two retries, as a duration
+	// string.
+	MaxDelay string `json:"maxDelay,omitempty"`
 }
 
 // EnvironmentDestinationSpec contains the specification for the cluster
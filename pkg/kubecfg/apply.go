@@ -18,7 +18,9 @@ package kubecfg
 import (
 	"encoding/json"
 	"fmt"
-	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/ksonnet/ksonnet/client"
 	"github.com/ksonnet/ksonnet/utils"
@@ -31,9 +33,12 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	kdiff "k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/scheme"
 )
 
 const (
@@ -51,116 +56,593 @@ const (
 	GcStrategyAuto = "auto"
 	// GcStrategyIgnore means this object should be ignored by garbage collection
 	GcStrategyIgnore = "ignore"
+
+	// AnnotationLastApplied stores the configuration an object was last
+	// applied with. It is used to compute three-way patches, so fields that
+	// are removed from a component are also removed from the cluster.
+	AnnotationLastApplied = "kubecfg.ksonnet.io/last-applied-configuration"
+
+	// LabelApp identifies the ksonnet application an object was applied from.
+	LabelApp = "kubecfg.ksonnet.io/app"
+	// LabelEnvironment identifies the environment an object was applied to.
+	LabelEnvironment = "kubecfg.ksonnet.io/environment"
+	// LabelGcTag mirrors AnnotationGcTag as a label, so objects that are
+	// candidates for garbage collection can be found with a server-side
+	// label selector.
+	LabelGcTag = "kubecfg.ksonnet.io/garbage-collect-tag"
+
+	// DefaultParallelism is the number of objects applied at the same time
+	// if no parallelism was given.
+	DefaultParallelism = 10
 )
 
 // ApplyCmd represents the apply subcommand
 type ApplyCmd struct {
 	ClientConfig *client.Config
+	App          string
 	Env          string
 	Create       bool
 	GcTag        string
 	SkipGc       bool
-	DryRun       bool
+	// GcUnlabeled also garbage collects objects that only carry the
+	// AnnotationGcTag annotation, like objects applied by earlier versions
+	// of ks. Finding them lists every object without LabelGcTag.
+	GcUnlabeled bool
+	DryRun      bool
+	Wait        bool
+	WaitTimeout time.Duration
+
+	// HistoryStorage selects where the applied objects are recorded. See
+	// HistoryStorageSecret, HistoryStorageConfigMap and HistoryStorageNone.
+	HistoryStorage string
+	// HistoryMax is the number of revisions kept in the release history.
+	// If it is zero, every revision is kept.
+	HistoryMax int
+	// Components are the components the objects were rendered from. It is
+	// empty if every component of the environment was rendered.
+	Components []string
+	// Description is recorded in the release history.
+	Description string
+
+	// Parallelism is the number of objects of a dependency tier that are
+	// applied at the same time.
+	Parallelism int
+	// Retry configures how failed requests are retried.
+	Retry RetryOptions
+	// Force deletes and recreates objects whose immutable fields changed.
+	// Objects can opt into this with the AnnotationForceReplace annotation.
+	Force bool
+	// Events receives an event for every applied or garbage collected
+	// object. It may be nil.
+	Events *EventWriter
+
+	// pruneEnvironment garbage collects every object labeled with the app and
+	// environment that is not part of the applied objects.
+	pruneEnvironment bool
 }
 
 // Run applies the components to the designated environment cluster.
 func (c ApplyCmd) Run(apiObjects []*unstructured.Unstructured, wd string) error {
+	return c.RunLayers([][]*unstructured.Unstructured{apiObjects}, wd)
+}
+
+// RunLayers applies layers of objects to the designated environment cluster,
+// in order. With Wait, the objects of a layer are ready before the next
+// layer is applied.
+func (c ApplyCmd) RunLayers(layers [][]*unstructured.Unstructured, wd string) error {
 	clientPool, discovery, namespace, err := c.ClientConfig.RestClient(&c.Env)
 	if err != nil {
 		return err
 	}
 
-	dryRunText := ""
-	if c.DryRun {
-		dryRunText = " (dry-run)"
+	var apiObjects []*unstructured.Unstructured
+	layerTiers := make([][][]*unstructured.Unstructured, 0, len(layers))
+	for _, layer := range layers {
+		apiObjects = append(apiObjects, layer...)
+
+		tiers, err := utils.DependencyTiers(layer)
+		if err != nil {
+			return err
+		}
+		layerTiers = append(layerTiers, tiers)
 	}
 
-	sort.Sort(utils.DependencyOrder(apiObjects))
+	version, err := utils.FetchVersion(discovery)
+	if err != nil {
+		return err
+	}
 
 	seenUids := sets.NewString()
+	var waiting []*waitObject
+
+	gcNamespaces := sets.NewString(namespace)
 
+	// Kinds defined by the CRDs being applied. Their instances can only be
+	// applied once the CRDs are established.
+	crdKinds := sets.NewString()
 	for _, obj := range apiObjects {
-		if c.GcTag != "" {
-			utils.SetMetaDataAnnotation(obj, AnnotationGcTag, c.GcTag)
+		if isCRD(obj) {
+			gk := crdKind(obj)
+			crdKinds.Insert(gk.String())
 		}
+	}
+	var pendingCrds []*waitObject
+
+	// The objects that were updated, so they can be reported if a later
+	// object fails.
+	var updated []string
+	// The objects that were deleted and recreated.
+	var replaced []string
+
+	for i, tiers := range layerTiers {
+		// Later layers depend on the objects of earlier ones being ready.
+		if i > 0 && c.Wait && !c.DryRun {
+			if err := waitForReady(waiting, c.WaitTimeout); err != nil {
+				return fmt.Errorf("%s%s", err, succeededText("updated", updated))
+			}
+			waiting = nil
+		}
+
+		for _, tier := range tiers {
+			// CRDs sort ahead of everything but namespaces, so the kinds they
+			// define are established before the first object that may use them.
+			if len(pendingCrds) > 0 {
+				if err := waitForCrds(discovery, pendingCrds, c.WaitTimeout); err != nil {
+					return fmt.Errorf("%s%s", err, succeededText("updated", updated))
+				}
+				pendingCrds = nil
+			}
+
+			results := make([]applyResult, len(tier))
+			forEachParallel(len(tier), c.Parallelism, func(i int) {
+				results[i] = c.applyOne(clientPool, discovery, &version, namespace, tier[i], crdKinds)
+			})
 
-		desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(discovery, obj), utils.FqName(obj))
-		log.Info("Updating ", desc, dryRunText)
+			var failed []string
+			for _, r := range results {
+				if r.obj.GetNamespace() != "" {
+					gcNamespaces.Insert(r.obj.GetNamespace())
+				}
 
-		rc, err := utils.ClientForResource(clientPool, discovery, obj, namespace)
+				if r.err != nil {
+					failed = append(failed, fmt.Sprintf("%s: %s", r.desc, r.err))
+					continue
+				}
+				if r.live == nil {
+					continue
+				}
+				updated = append(updated, r.desc)
+				if r.replaced {
+					replaced = append(replaced, r.desc)
+				}
+
+				// Some objects appear under multiple kinds
+				// (eg: Deployment is both extensions/v1beta1
+				// and apps/v1beta1).  UID is the only stable
+				// identifier that links these two views of
+				// the same object.
+				seenUids.Insert(string(r.live.GetUID()))
+
+				if isCRD(r.obj) && !c.DryRun {
+					pendingCrds = append(pendingCrds, &waitObject{desc: r.desc, client: r.client, obj: r.obj, ready: crdReady})
+				}
+
+				if c.Wait && !c.DryRun {
+					if ready := readinessFor(r.obj); ready != nil {
+						waiting = append(waiting, &waitObject{desc: r.desc, client: r.client, obj: r.obj, ready: ready})
+					}
+				}
+			}
+
+			// Later tiers may depend on the failed objects, so stop here.
+			if len(failed) > 0 {
+				return fmt.Errorf("Error updating %d object(s):\n  %s%s",
+					len(failed), strings.Join(failed, "\n  "), succeededText("updated", updated))
+			}
+		}
+	}
+
+	if len(replaced) > 0 {
+		log.Warnf("Replaced %d object(s) because immutable fields changed:\n  %s",
+			len(replaced), strings.Join(replaced, "\n  "))
+	}
+
+	if len(pendingCrds) > 0 {
+		// Nothing in this apply depends on the new kinds, but garbage
+		// collection needs to see them.
+		invalidateDiscovery(discovery)
+	}
+
+	if c.GcTag != "" && !c.SkipGc {
+		eligible := func(o metav1.Object) bool {
+			return eligibleForGc(o, c.GcTag)
+		}
+
+		err = c.garbageCollect(clientPool, discovery, gcTagSelectors(c.GcTag, c.GcUnlabeled), gcNamespaces.List(), seenUids, eligible)
 		if err != nil {
-			return err
+			return fmt.Errorf("%s%s", err, succeededText("updated", updated))
 		}
+	}
 
-		asPatch, err := json.Marshal(obj)
+	if c.pruneEnvironment {
+		selector := fmt.Sprintf("%s=%s,%s=%s",
+			LabelApp, utils.LabelValue(c.App), LabelEnvironment, utils.LabelValue(c.Env))
+		eligible := func(o metav1.Object) bool {
+			return eligibleForEnvGc(o, c.App, c.Env)
+		}
+
+		err = c.garbageCollect(clientPool, discovery, []string{selector}, gcNamespaces.List(), seenUids, eligible)
 		if err != nil {
-			return err
+			return fmt.Errorf("%s%s", err, succeededText("updated", updated))
 		}
-		var newobj metav1.Object
-		if !c.DryRun {
-			newobj, err = rc.Patch(obj.GetName(), types.MergePatchType, asPatch)
-			log.Debugf("Patch(%s) returned (%v, %v)", obj.GetName(), newobj, err)
-		} else {
-			newobj, err = rc.Get(obj.GetName(), metav1.GetOptions{})
+	}
+
+	if !c.DryRun && c.HistoryStorage != HistoryStorageNone {
+		c.recordRelease(clientPool, namespace, wd, apiObjects)
+	}
+
+	if c.Wait && !c.DryRun {
+		return waitForReady(waiting, c.WaitTimeout)
+	}
+
+	return nil
+}
+
+// applyResult is the outcome of applying a single object.
+type applyResult struct {
+	obj    *unstructured.Unstructured
+	desc   string
+	client dynamic.ResourceInterface
+	// live is the object as it exists in the cluster after the apply. It is
+	// nil if the object was skipped.
+	live metav1.Object
+	// replaced is true if the object was deleted and recreated.
+	replaced bool
+	err      error
+}
+
+// applyOne applies a single object. It is safe to call concurrently.
+func (c ApplyCmd) applyOne(clientPool dynamic.ClientPool, discovery discovery.DiscoveryInterface, version *utils.ServerVersion, namespace string,
+	obj *unstructured.Unstructured, crdKinds sets.String) applyResult {
+	dryRunText := ""
+	if c.DryRun {
+		dryRunText = " (dry-run)"
+	}
+
+	c.setOwnership(obj)
+
+	desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(discovery, obj), utils.FqName(obj))
+	log.Info("Updating ", desc, dryRunText)
+
+	result := applyResult{obj: obj, desc: desc}
+
+	start := time.Now()
+	action := ActionApply
+	defer func() {
+		var uid types.UID
+		if result.live != nil {
+			uid = result.live.GetUID()
 		}
-		if c.Create && errors.IsNotFound(err) {
-			log.Info(" Creating non-existent ", desc, dryRunText)
-			if !c.DryRun {
-				newobj, err = rc.Create(obj)
-				log.Debugf("Create(%s) returned (%v, %v)", obj.GetName(), newobj, err)
+		c.Events.emit(action, obj, uid, start, c.DryRun, result.err)
+	}()
+
+	rc, err := utils.ClientForResource(clientPool, discovery, obj, namespace)
+	if err != nil {
+		gk := obj.GroupVersionKind().GroupKind()
+		if c.DryRun && crdKinds.Has(gk.String()) {
+			// The CRD wasn't created, so the server doesn't know this kind.
+			log.Info(" Creating custom resource ", desc, dryRunText)
+			action = ActionCreate
+			return result
+		}
+		result.err = err
+		return result
+	}
+	result.client = rc
+
+	var newobj metav1.Object
+	if !c.DryRun {
+		err = c.Retry.retry("Updating "+desc, func() error {
+			var err error
+			newobj, action, err = c.applyObject(rc, obj, desc)
+			return err
+		})
+		if isImmutableFieldError(err) {
+			if !c.shouldReplace(obj) {
+				err = fmt.Errorf("%s (use --force or the %s annotation to replace the object)", err, AnnotationForceReplace)
 			} else {
-				newobj = obj
-				err = nil
+				log.Warnf("Replacing %s: %s", desc, err)
+				action = ActionReplace
+				newobj, err = c.replaceObject(rc, obj, desc, version)
+				result.replaced = err == nil
 			}
 		}
-		if err != nil {
-			// TODO: retry
-			return fmt.Errorf("Error updating %s: %s", desc, err)
-		}
+	} else {
+		newobj, action, err = c.previewObject(rc, obj, desc)
+	}
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	if live, ok := newobj.(*unstructured.Unstructured); ok {
+		log.Debug("Updated object: ", kdiff.ObjectDiff(redactSecret(obj), redactSecret(live)))
+	}
 
-		log.Debug("Updated object: ", kdiff.ObjectDiff(obj, newobj))
+	result.live = newobj
+	return result
+}
 
-		// Some objects appear under multiple kinds
-		// (eg: Deployment is both extensions/v1beta1
-		// and apps/v1beta1).  UID is the only stable
-		// identifier that links these two views of
-		// the same object.
-		seenUids.Insert(string(newobj.GetUID()))
+// succeededText lists the objects that succeeded before an error, so the
+// user knows what state the cluster is in.
+func succeededText(action string, descs []string) string {
+	if len(descs) == 0 {
+		return fmt.Sprintf("\nNo objects were %s.", action)
 	}
+	return fmt.Sprintf("\nThe following %d object(s) were %s:\n  %s", len(descs), action, strings.Join(descs, "\n  "))
+}
 
-	if c.GcTag != "" && !c.SkipGc {
-		version, err := utils.FetchVersion(discovery)
+// forEachParallel calls fn for 0..n-1, running at most parallelism calls at
+// the same time. It returns once all calls are done.
+func forEachParallel(n, parallelism int, fn func(i int)) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	if parallelism > n {
+		parallelism = n
+	}
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+}
+
+// garbageCollect deletes the objects matching selector that are eligible
+// for garbage collection and were not part of the applied objects.
+func (c ApplyCmd) garbageCollect(clientPool dynamic.ClientPool, discovery discovery.DiscoveryInterface, selectors []string,
+	namespaces []string, seenUids sets.String, eligible func(metav1.Object) bool) error {
+	dryRunText := ""
+	if c.DryRun {
+		dryRunText = " (dry-run)"
+	}
+
+	version, err := utils.FetchVersion(discovery)
+	if err != nil {
+		return err
+	}
+
+	candidates, err := findGcCandidates(clientPool, discovery, selectors, namespaces, seenUids, eligible, c.Retry)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range candidates {
+		log.Info("Garbage collecting ", gcDesc(discovery, o), dryRunText)
+		start := time.Now()
+		if !c.DryRun {
+			err = gcDelete(clientPool, discovery, &version, o, c.Retry)
+		}
+		c.Events.emit(ActionGc, o, o.GetUID(), start, c.DryRun, err)
 		if err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		err = walkObjects(clientPool, discovery, metav1.ListOptions{}, func(o runtime.Object) error {
-			meta, err := meta.Accessor(o)
-			if err != nil {
-				return err
+// gcTagSelectors returns the label selectors of the objects which may carry
+// gcTag. Objects applied by earlier versions of ks only have the
+// AnnotationGcTag annotation. With unlabeled, objects without LabelGcTag
+// are listed as well to find them, which lists most objects of the cluster.
+func gcTagSelectors(gcTag string, unlabeled bool) []string {
+	selectors := []string{fmt.Sprintf("%s=%s", LabelGcTag, utils.LabelValue(gcTag))}
+	if unlabeled {
+		selectors = append(selectors, "!"+LabelGcTag)
+	}
+	return selectors
+}
+
+// findGcCandidates lists the objects matching any of selectors that are
+// eligible for garbage collection and are not in seenUids. Objects that
+// appear under multiple kinds or match several selectors are only returned
+// once.
+func findGcCandidates(clientPool dynamic.ClientPool, discovery discovery.DiscoveryInterface, selectors []string,
+	namespaces []string, seenUids sets.String, eligible func(metav1.Object) bool, retry RetryOptions) ([]*unstructured.Unstructured, error) {
+	var candidates []*unstructured.Unstructured
+	gcUids := sets.NewString()
+	for _, selector := range selectors {
+		listOpts := metav1.ListOptions{LabelSelector: selector}
+		err := walkObjects(clientPool, discovery, listOpts, namespaces, retry, func(o runtime.Object) error {
+			obj, ok := o.(*unstructured.Unstructured)
+			if !ok {
+				return fmt.Errorf("Unexpected object type %T", o)
 			}
-			gvk := o.GetObjectKind().GroupVersionKind()
-			desc := fmt.Sprintf("%s %s (%s)", utils.ResourceNameFor(discovery, o), utils.FqName(meta), gvk.GroupVersion())
-			log.Debugf("Considering %v for gc", desc)
-			if eligibleForGc(meta, c.GcTag) && !seenUids.Has(string(meta.GetUID())) {
-				log.Info("Garbage collecting ", desc, dryRunText)
-				if !c.DryRun {
-					err := gcDelete(clientPool, discovery, &version, o)
-					if err != nil {
-						return err
-					}
-				}
+			log.Debugf("Considering %v for gc", gcDesc(discovery, obj))
+
+			uid := string(obj.GetUID())
+			if eligible(obj) && !seenUids.Has(uid) && !gcUids.Has(uid) {
+				gcUids.Insert(uid)
+				candidates = append(candidates, obj)
 			}
 			return nil
 		})
 		if err != nil {
-			return err
+			return nil, err
+		}
+	}
+	return candidates, nil
+}
+
+// gcDesc describes an object that is considered for garbage collection.
+func gcDesc(discovery discovery.DiscoveryInterface, obj *unstructured.Unstructured) string {
+	gvk := obj.GroupVersionKind()
+	return fmt.Sprintf("%s %s (%s)", utils.ResourceNameFor(discovery, obj), utils.FqName(obj), gvk.GroupVersion())
+}
+
+// recordRelease stores the applied objects in the release history of the
+// environment. Failing to do so doesn't fail the apply.
+func (c ApplyCmd) recordRelease(clientPool dynamic.ClientPool, namespace, wd string, objs []*unstructured.Unstructured) {
+	store, err := newReleaseStore(clientPool, c.HistoryStorage, namespace, c.App, c.Env)
+	if err == nil {
+		release := newRelease(c.App, c.Env, wd, c.Components, c.Description, objs)
+		if err = store.Create(release); err == nil {
+			log.Infof("Recorded revision %d of environment %q", release.Revision, c.Env)
+			if len(release.RedactedSecrets) > 0 {
+				log.Warnf("Release history is stored in ConfigMaps, so the values of %d Secret(s) weren't recorded and revision %d can't be rolled back to",
+					len(release.RedactedSecrets), release.Revision)
+			}
+			if err = store.Prune(c.HistoryMax); err != nil {
+				log.Warnf("Unable to prune release history: %s", err)
+			}
+			return
+		}
+	}
+
+	log.Warnf("Unable to record release history: %s", err)
+}
+
+// setOwnership labels obj with the application, environment and gc tag it
+// is applied with.
+func (c ApplyCmd) setOwnership(obj *unstructured.Unstructured) {
+	if c.App != "" {
+		utils.SetMetaDataLabel(obj, LabelApp, utils.LabelValue(c.App))
+	}
+	if c.Env != "" {
+		utils.SetMetaDataLabel(obj, LabelEnvironment, utils.LabelValue(c.Env))
+	}
+	if c.GcTag != "" {
+		utils.SetMetaDataAnnotation(obj, AnnotationGcTag, c.GcTag)
+		utils.SetMetaDataLabel(obj, LabelGcTag, utils.LabelValue(c.GcTag))
+	}
+}
+
+// applyObject creates obj, or patches the live object to match obj. The
+// patch is computed from the last applied configuration, the live object and
+// obj, so fields which were removed from obj are removed from the cluster.
+// It returns the object as it exists in the cluster and the action that was
+// taken, which is ActionApply if the apply failed before an action was
+// chosen.
+func (c ApplyCmd) applyObject(rc dynamic.ResourceInterface, obj *unstructured.Unstructured, desc string) (*unstructured.Unstructured, string, error) {
+	if err := setLastApplied(obj); err != nil {
+		return nil, ActionApply, err
+	}
+
+	live, err := rc.Get(obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if c.Create && errors.IsNotFound(err) {
+			log.Info(" Creating non-existent ", desc)
+			newobj, err := rc.Create(obj)
+			log.Debugf("Create(%s) returned (%v, %v)", obj.GetName(), redactSecret(newobj), err)
+			return newobj, ActionCreate, err
 		}
+		return nil, ActionApply, err
+	}
+
+	patch, patchType, err := threeWayPatch(live, obj)
+	if err != nil {
+		return nil, ActionPatch, err
+	}
+
+	if string(patch) == "{}" {
+		log.Debugf("%s is unchanged", desc)
+		return live, ActionUnchanged, nil
+	}
+
+	log.Debugf("Patching %s with %s patch %s", desc, patchType, redactPatch(obj, patch))
+	newobj, err := rc.Patch(obj.GetName(), patchType, patch)
+	log.Debugf("Patch(%s) returned (%v, %v)", obj.GetName(), redactSecret(newobj), err)
+	return newobj, ActionPatch, err
+}
+
+// previewObject reports what applyObject would do with obj, without
+// changing the cluster.
+func (c ApplyCmd) previewObject(rc dynamic.ResourceInterface, obj *unstructured.Unstructured, desc string) (metav1.Object, string, error) {
+	live, err := rc.Get(obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if c.Create && errors.IsNotFound(err) {
+			log.Info(" Creating non-existent ", desc, " (dry-run)")
+			return obj, ActionCreate, nil
+		}
+		return nil, ActionApply, err
+	}
+
+	desired := obj.DeepCopy()
+	if err := setLastApplied(desired); err != nil {
+		return nil, ActionPatch, err
+	}
+
+	patch, _, err := threeWayPatch(live, desired)
+	if err != nil {
+		return nil, ActionPatch, err
+	}
+	if string(patch) == "{}" {
+		return live, ActionUnchanged, nil
+	}
+
+	return live, ActionPatch, nil
+}
+
+// setLastApplied records the configuration of obj in the
+// AnnotationLastApplied annotation.
+func setLastApplied(obj *unstructured.Unstructured) error {
+	annotations := obj.GetAnnotations()
+	delete(annotations, AnnotationLastApplied)
+	obj.SetAnnotations(annotations)
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
 	}
 
+	utils.SetMetaDataAnnotation(obj, AnnotationLastApplied, string(data))
 	return nil
 }
 
+// lastApplied returns the configuration live was last applied with.
+func lastApplied(live metav1.Object) []byte {
+	return []byte(live.GetAnnotations()[AnnotationLastApplied])
+}
+
+// threeWayPatch computes the patch which moves live to desired. Kinds which
+// are known to the client use a strategic merge patch, everything else
+// (e.g. custom resources) uses a JSON merge patch.
+func threeWayPatch(live, desired *unstructured.Unstructured) ([]byte, types.PatchType, error) {
+	current, err := json.Marshal(live)
+	if err != nil {
+		return nil, "", err
+	}
+
+	modified, err := json.Marshal(desired)
+	if err != nil {
+		return nil, "", err
+	}
+
+	original := lastApplied(live)
+
+	dataStruct, err := scheme.Scheme.New(desired.GroupVersionKind())
+	if err != nil {
+		patch, err := jsonmergepatch.CreateThreeWayJSONMergePatch(original, modified, current)
+		return patch, types.MergePatchType, err
+	}
+
+	patch, err := strategicpatch.CreateThreeWayMergePatch(original, modified, current, dataStruct, true)
+	return patch, types.StrategicMergePatchType, err
+}
+
 func stringListContains(list []string, value string) bool {
 	for _, item := range list {
 		if item == value {
@@ -170,7 +652,7 @@ func stringListContains(list []string, value string) bool {
 	return false
 }
 
-func gcDelete(clientpool dynamic.ClientPool, disco discovery.DiscoveryInterface, version *utils.ServerVersion, o runtime.Object) error {
+func gcDelete(clientpool dynamic.ClientPool, disco discovery.DiscoveryInterface, version *utils.ServerVersion, o runtime.Object, retry RetryOptions) error {
 	obj, err := meta.Accessor(o)
 	if err != nil {
 		return fmt.Errorf("Unexpected object type: %s", err)
@@ -179,30 +661,23 @@ func gcDelete(clientpool dynamic.ClientPool, disco discovery.DiscoveryInterface,
 	uid := obj.GetUID()
 	desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(disco, o), utils.FqName(obj))
 
-	deleteOpts := metav1.DeleteOptions{
-		Preconditions: &metav1.Preconditions{UID: &uid},
-	}
-	if version.Compare(1, 6) < 0 {
-		// 1.5.x option
-		boolFalse := false
-		deleteOpts.OrphanDependents = &boolFalse
-	} else {
-		// 1.6.x option (NB: Background is broken)
-		fg := metav1.DeletePropagationForeground
-		deleteOpts.PropagationPolicy = &fg
-	}
+	deleteOpts := cascadingDeleteOptions(version)
+	deleteOpts.Preconditions = &metav1.Preconditions{UID: &uid}
 
 	c, err := utils.ClientForResource(clientpool, disco, o, metav1.NamespaceNone)
 	if err != nil {
 		return err
 	}
 
-	err = c.Delete(obj.GetName(), &deleteOpts)
-	if err != nil && (errors.IsNotFound(err) || errors.IsConflict(err)) {
-		// We lost a race with something else changing the object
-		log.Debugf("Ignoring error while deleting %s: %s", desc, err)
-		err = nil
-	}
+	err = retry.retry("Deleting "+desc, func() error {
+		err := c.Delete(obj.GetName(), &deleteOpts)
+		if err != nil && (errors.IsNotFound(err) || errors.IsConflict(err)) {
+			// We lost a race with something else changing the object
+			log.Debugf("Ignoring error while deleting %s: %s", desc, err)
+			err = nil
+		}
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("Error deleting %s: %s", desc, err)
 	}
@@ -210,7 +685,11 @@ func gcDelete(clientpool dynamic.ClientPool, disco discovery.DiscoveryInterface,
 	return nil
 }
 
-func walkObjects(pool dynamic.ClientPool, disco discovery.DiscoveryInterface, listopts metav1.ListOptions, callback func(runtime.Object) error) error {
+// walkObjects lists the objects matching listopts and calls callback for
+// each of them. Namespaced resources are only listed in namespaces, and
+// resources the user isn't allowed to list are skipped.
+func walkObjects(pool dynamic.ClientPool, disco discovery.DiscoveryInterface, listopts metav1.ListOptions, namespaces []string,
+	retry RetryOptions, callback func(runtime.Object) error) error {
 	rsrclists, err := disco.ServerResources()
 	if err != nil {
 		return err
@@ -232,34 +711,70 @@ func walkObjects(pool dynamic.ClientPool, disco discovery.DiscoveryInterface, li
 				return err
 			}
 
-			var ns string
+			listNamespaces := []string{metav1.NamespaceNone}
 			if rsrc.Namespaced {
-				ns = metav1.NamespaceAll
-			} else {
-				ns = metav1.NamespaceNone
+				listNamespaces = namespaces
 			}
 
-			rc := client.Resource(&rsrc, ns)
-			log.Debugf("Listing %s", gvk)
-			obj, err := rc.List(listopts)
-			if err != nil {
-				return err
-			}
-			if err = meta.EachListItem(obj, callback); err != nil {
-				return err
+			for _, ns := range listNamespaces {
+				rc := client.Resource(&rsrc, ns)
+				log.Debugf("Listing %s in namespace %q", gvk, ns)
+				var obj runtime.Object
+				err := retry.retry(fmt.Sprintf("Listing %s in namespace %q", gvk, ns), func() error {
+					var err error
+					obj, err = rc.List(listopts)
+					return err
+				})
+				if err != nil {
+					if errors.IsForbidden(err) || errors.IsMethodNotSupported(err) || errors.IsNotFound(err) {
+						log.Debugf("Unable to list %s in namespace %q, skipping: %s", gvk, ns, err)
+						continue
+					}
+					return err
+				}
+				if err = meta.EachListItem(obj, callback); err != nil {
+					return err
+				}
 			}
 		}
 	}
 	return nil
 }
 
-func eligibleForGc(obj metav1.Object, gcTag string) bool {
+// eligibleForEnvGc reports whether obj belongs to the environment and may be
+// garbage collected.
+func eligibleForEnvGc(obj metav1.Object, app, env string) bool {
+	if isControlled(obj) {
+		return false
+	}
+
+	a := obj.GetAnnotations()
+	strategy, ok := a[AnnotationGcStrategy]
+	if !ok {
+		strategy = GcStrategyAuto
+	}
+
+	l := obj.GetLabels()
+	return l[LabelApp] == utils.LabelValue(app) &&
+		l[LabelEnvironment] == utils.LabelValue(env) &&
+		l[LabelOwner] == "" &&
+		strategy == GcStrategyAuto
+}
+
+func isControlled(obj metav1.Object) bool {
 	for _, ref := range obj.GetOwnerReferences() {
 		if ref.Controller != nil && *ref.Controller {
-			// Has a controller ref
-			return false
+			return true
 		}
 	}
+	return false
+}
+
+func eligibleForGc(obj metav1.Object, gcTag string) bool {
+	if isControlled(obj) {
+		// Has a controller ref
+		return false
+	}
 
 	a := obj.GetAnnotations()
 
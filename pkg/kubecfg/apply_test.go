@@ -16,14 +16,57 @@
 package kubecfg
 
 import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	ktesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/flowcontrol"
 
 	"github.com/ksonnet/ksonnet/utils"
 )
 
+// fakeClientPool returns clients for the objects of a fakeResourceClient,
+// whatever their kind and namespace.
+type fakeClientPool struct {
+	client *fakeResourceClient
+}
+
+func (p fakeClientPool) ClientForGroupVersionResource(resource schema.GroupVersionResource) (dynamic.Interface, error) {
+	return fakeDynamicClient(p), nil
+}
+
+func (p fakeClientPool) ClientForGroupVersionKind(kind schema.GroupVersionKind) (dynamic.Interface, error) {
+	return fakeDynamicClient(p), nil
+}
+
+type fakeDynamicClient struct {
+	client *fakeResourceClient
+}
+
+func (c fakeDynamicClient) GetRateLimiter() flowcontrol.RateLimiter {
+	return nil
+}
+
+func (c fakeDynamicClient) Resource(resource *metav1.APIResource, namespace string) dynamic.ResourceInterface {
+	return c.client
+}
+
+func (c fakeDynamicClient) ParameterCodec(parameterCodec runtime.ParameterCodec) dynamic.Interface {
+	return c
+}
+
 func TestStringListContains(t *testing.T) {
 	foobar := []string{"foo", "bar"}
 	if stringListContains([]string{}, "") {
@@ -94,3 +137,318 @@ func TestEligibleForGc(t *testing.T) {
 		t.Errorf("%v should not be eligible (controller ownerref)", o)
 	}
 }
+
+func TestSetLastApplied(t *testing.T) {
+	o := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name": "config",
+			},
+		},
+	}
+
+	if err := setLastApplied(o); err != nil {
+		t.Fatal(err)
+	}
+
+	first := o.GetAnnotations()[AnnotationLastApplied]
+	if first == "" {
+		t.Fatal("last applied configuration was not recorded")
+	}
+
+	// Applying twice must not nest the previous configuration.
+	if err := setLastApplied(o); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := o.GetAnnotations()[AnnotationLastApplied]; got != first {
+		t.Errorf("last applied configuration changed: %s != %s", got, first)
+	}
+}
+
+func TestThreeWayPatch(t *testing.T) {
+	newObj := func(apiVersion, kind string, data map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": apiVersion,
+				"kind":       kind,
+				"metadata": map[string]interface{}{
+					"name": "name",
+				},
+				"data": data,
+			},
+		}
+	}
+
+	cases := []struct {
+		apiVersion string
+		kind       string
+		patchType  types.PatchType
+	}{
+		{apiVersion: "v1", kind: "ConfigMap", patchType: types.StrategicMergePatchType},
+		{apiVersion: "example.com/v1", kind: "Custom", patchType: types.MergePatchType},
+	}
+
+	for _, tc := range cases {
+		live := newObj(tc.apiVersion, tc.kind, map[string]interface{}{"a": "1", "b": "2"})
+		if err := setLastApplied(live); err != nil {
+			t.Fatal(err)
+		}
+
+		desired := newObj(tc.apiVersion, tc.kind, map[string]interface{}{"a": "1"})
+		if err := setLastApplied(desired); err != nil {
+			t.Fatal(err)
+		}
+
+		patch, patchType, err := threeWayPatch(live, desired)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if patchType != tc.patchType {
+			t.Errorf("%s: patch type = %s; expected %s", tc.kind, patchType, tc.patchType)
+		}
+
+		var got map[string]interface{}
+		if err := json.Unmarshal(patch, &got); err != nil {
+			t.Fatal(err)
+		}
+
+		data, ok := got["data"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("%s: patch %s doesn't contain data", tc.kind, patch)
+		}
+		if v, ok := data["b"]; !ok || v != nil {
+			t.Errorf("%s: patch %s doesn't delete removed key", tc.kind, patch)
+		}
+	}
+}
+
+func TestThreeWayPatch_unchanged(t *testing.T) {
+	newDeployment := func() *unstructured.Unstructured {
+		return &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "apps/v1beta1",
+				"kind":       "Deployment",
+				"metadata": map[string]interface{}{
+					"name": "name",
+				},
+				"spec": map[string]interface{}{
+					"template": map[string]interface{}{
+						"spec": map[string]interface{}{
+							"containers": []interface{}{
+								map[string]interface{}{
+									"name":  "app",
+									"image": "app:1",
+									"args":  []interface{}{"--a", "--b"},
+								},
+							},
+							"tolerations": []interface{}{
+								map[string]interface{}{"key": "dedicated", "operator": "Exists"},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	desired := newDeployment()
+	if err := setLastApplied(desired); err != nil {
+		t.Fatal(err)
+	}
+
+	// The live object has the fields the server defaulted.
+	live := desired.DeepCopy()
+	container := live.Object["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"].([]interface{})[0].(map[string]interface{})
+	container["terminationMessagePath"] = "/dev/termination-log"
+	container["imagePullPolicy"] = "IfNotPresent"
+
+	patch, _, err := threeWayPatch(live, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Lists without a merge key, like args and tolerations, aren't patched
+	// again if they are unchanged.
+	if string(patch) != "{}" {
+		t.Errorf("patch of an unchanged object = %s; expected {}", patch)
+	}
+}
+
+func TestFindGcCandidates(t *testing.T) {
+	newObj := func(name string, labels, annotations map[string]string) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+			},
+		}
+		o.SetName(name)
+		o.SetUID(types.UID(name))
+		o.SetLabels(labels)
+		o.SetAnnotations(annotations)
+		return o
+	}
+
+	client := newFakeResourceClient()
+	for _, o := range []*unstructured.Unstructured{
+		newObj("labeled",
+			map[string]string{LabelGcTag: "my-tag"},
+			map[string]string{AnnotationGcTag: "my-tag"}),
+		newObj("applied",
+			map[string]string{LabelGcTag: "my-tag"},
+			map[string]string{AnnotationGcTag: "my-tag"}),
+		// Applied by an earlier version of ks, which didn't add the label.
+		newObj("annotated", nil, map[string]string{AnnotationGcTag: "my-tag"}),
+		newObj("other-tag", nil, map[string]string{AnnotationGcTag: "other-tag"}),
+		newObj("untagged", nil, nil),
+	} {
+		client.objects[o.GetName()] = o
+	}
+
+	disco := &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{
+					{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: []string{"list"}},
+				},
+			},
+		},
+	}}
+
+	eligible := func(o metav1.Object) bool {
+		return eligibleForGc(o, "my-tag")
+	}
+
+	for _, tc := range []struct {
+		unlabeled bool
+		expected  []string
+	}{
+		{unlabeled: false, expected: []string{"labeled"}},
+		// Objects applied by an earlier version of ks are only found on
+		// request.
+		{unlabeled: true, expected: []string{"annotated", "labeled"}},
+	} {
+		candidates, err := findGcCandidates(fakeClientPool{client}, disco, gcTagSelectors("my-tag", tc.unlabeled),
+			[]string{"default"}, sets.NewString("applied"), eligible, RetryOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := []string{}
+		for _, o := range candidates {
+			names = append(names, o.GetName())
+		}
+		sort.Strings(names)
+
+		if !reflect.DeepEqual(names, tc.expected) {
+			t.Errorf("gc candidates with unlabeled=%v = %v; expected %v", tc.unlabeled, names, tc.expected)
+		}
+	}
+}
+
+func TestSetOwnership(t *testing.T) {
+	o := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+		},
+	}
+
+	c := ApplyCmd{App: "guestbook", Env: "us-west/dev", GcTag: "my-gctag"}
+	c.setOwnership(o)
+
+	labels := o.GetLabels()
+	if labels[LabelApp] != "guestbook" {
+		t.Errorf("unexpected app label %q", labels[LabelApp])
+	}
+	if labels[LabelEnvironment] != utils.LabelValue("us-west/dev") {
+		t.Errorf("unexpected environment label %q", labels[LabelEnvironment])
+	}
+	if labels[LabelGcTag] != "my-gctag" {
+		t.Errorf("unexpected gc tag label %q", labels[LabelGcTag])
+	}
+	if !eligibleForGc(o, "my-gctag") {
+		t.Errorf("%v should be eligible for gc", o)
+	}
+}
+
+func TestForEachParallel(t *testing.T) {
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	done := make([]bool, 20)
+
+	forEachParallel(len(done), 3, func(i int) {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		lock.Lock()
+		running--
+		done[i] = true
+		lock.Unlock()
+	})
+
+	if maxRunning > 3 {
+		t.Errorf("At most 3 calls should run at the same time, got %d", maxRunning)
+	}
+	for i, d := range done {
+		if !d {
+			t.Errorf("%d was not called", i)
+		}
+	}
+
+	// No calls must not block.
+	forEachParallel(0, 3, func(int) { t.Error("unexpected call") })
+}
+
+func TestPreviewObject(t *testing.T) {
+	client := newFakeResourceClient()
+
+	newObj := func(data string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]interface{}{"name": "config"},
+				"data":       map[string]interface{}{"key": data},
+			},
+		}
+	}
+
+	c := ApplyCmd{Create: true, DryRun: true}
+
+	_, action, err := c.previewObject(client, newObj("a"), "config")
+	if err != nil || action != ActionCreate {
+		t.Errorf("Expected %s, got %s (%v)", ActionCreate, action, err)
+	}
+
+	live := newObj("a")
+	if err := setLastApplied(live); err != nil {
+		t.Fatal(err)
+	}
+	client.objects["config"] = live
+
+	_, action, err = c.previewObject(client, newObj("a"), "config")
+	if err != nil || action != ActionUnchanged {
+		t.Errorf("Expected %s, got %s (%v)", ActionUnchanged, action, err)
+	}
+
+	obj := newObj("b")
+	_, action, err = c.previewObject(client, obj, "config")
+	if err != nil || action != ActionPatch {
+		t.Errorf("Expected %s, got %s (%v)", ActionPatch, action, err)
+	}
+	if _, ok := obj.GetAnnotations()[AnnotationLastApplied]; ok {
+		t.Error("previewObject must not modify the object")
+	}
+}
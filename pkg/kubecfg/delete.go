@@ -17,12 +17,14 @@ package kubecfg
 
 import (
 	"fmt"
-	"sort"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
 
 	"github.com/ksonnet/ksonnet/client"
 	"github.com/ksonnet/ksonnet/utils"
@@ -33,6 +35,10 @@ type DeleteCmd struct {
 	ClientConfig *client.Config
 	Env          string
 	GracePeriod  int64
+	// Retry configures how failed requests are retried.
+	Retry RetryOptions
+	// Events receives an event for every deleted object. It may be nil.
+	Events *EventWriter
 }
 
 func (c DeleteCmd) Run(apiObjects []*unstructured.Unstructured) error {
@@ -46,38 +52,73 @@ func (c DeleteCmd) Run(apiObjects []*unstructured.Unstructured) error {
 		return err
 	}
 
-	sort.Sort(sort.Reverse(utils.DependencyOrder(apiObjects)))
-
-	deleteOpts := metav1.DeleteOptions{}
-	if version.Compare(1, 6) < 0 {
-		// 1.5.x option
-		boolFalse := false
-		deleteOpts.OrphanDependents = &boolFalse
-	} else {
-		// 1.6.x option (NB: Background is broken)
-		fg := metav1.DeletePropagationForeground
-		deleteOpts.PropagationPolicy = &fg
+	if err := utils.ReverseDependencySort(apiObjects); err != nil {
+		return err
 	}
+
+	deleteOpts := cascadingDeleteOptions(&version)
 	if c.GracePeriod >= 0 {
 		deleteOpts.GracePeriodSeconds = &c.GracePeriod
 	}
 
+	// The objects that were deleted, so they can be reported if a later
+	// object fails.
+	var deleted []string
+
 	for _, obj := range apiObjects {
 		desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(discovery, obj), utils.FqName(obj))
 		log.Info("Deleting ", desc)
 
 		client, err := utils.ClientForResource(clientPool, discovery, obj, namespace)
 		if err != nil {
-			return err
+			c.Events.emit(ActionDelete, obj, "", time.Now(), false, err)
+			return fmt.Errorf("%s%s", err, succeededText("deleted", deleted))
 		}
 
-		err = client.Delete(obj.GetName(), &deleteOpts)
-		if err != nil && !errors.IsNotFound(err) {
-			return fmt.Errorf("Error deleting %s: %s", desc, err)
+		start := time.Now()
+		uid, err := deleteObject(client, obj, &deleteOpts, c.Retry, desc)
+		c.Events.emit(ActionDelete, obj, uid, start, false, err)
+		if err != nil {
+			return fmt.Errorf("Error deleting %s: %s%s", desc, err, succeededText("deleted", deleted))
 		}
+		deleted = append(deleted, desc)
 
-		log.Debugf("Deleted object: ", obj)
+		log.Debug("Deleted object: ", redactSecret(obj))
 	}
 
 	return nil
 }
+
+// deleteObject deletes the live object of obj, and returns its UID. An
+// object that doesn't exist isn't an error, and has no UID.
+func deleteObject(client dynamic.ResourceInterface, obj *unstructured.Unstructured, deleteOpts *metav1.DeleteOptions, retry RetryOptions, desc string) (types.UID, error) {
+	var uid types.UID
+	err := retry.retry("Deleting "+desc, func() error {
+		live, err := client.Get(obj.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		uid = live.GetUID()
+		return client.Delete(obj.GetName(), deleteOpts)
+	})
+	if errors.IsNotFound(err) {
+		err = nil
+	}
+	return uid, err
+}
+
+// cascadingDeleteOptions returns delete options that also delete the
+// dependents of an object, in the way the server version supports.
+func cascadingDeleteOptions(version *utils.ServerVersion) metav1.DeleteOptions {
+	deleteOpts := metav1.DeleteOptions{}
+	if version.Compare(1, 6) < 0 {
+		// 1.5.x option
+		boolFalse := false
+		deleteOpts.OrphanDependents = &boolFalse
+	} else {
+		// 1.6.x option (NB: Background is broken)
+		fg := metav1.DeletePropagationForeground
+		deleteOpts.PropagationPolicy = &fg
+	}
+	return deleteOpts
+}
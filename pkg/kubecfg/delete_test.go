@@ -0,0 +1,54 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestDeleteObject(t *testing.T) {
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name": "config",
+			},
+		},
+	}
+
+	live := obj.DeepCopy()
+	live.SetUID("1234")
+
+	client := newFakeResourceClient()
+	client.objects["config"] = live
+
+	// The UID of the live object is returned, so delete events can be
+	// correlated with apply events.
+	uid, err := deleteObject(client, obj, &metav1.DeleteOptions{}, RetryOptions{}, "configmaps config")
+	require.NoError(t, err)
+	require.Equal(t, types.UID("1234"), uid)
+	require.Empty(t, client.objects)
+
+	uid, err = deleteObject(client, obj, &metav1.DeleteOptions{}, RetryOptions{}, "configmaps config")
+	require.NoError(t, err)
+	require.Equal(t, types.UID(""), uid)
+}
@@ -24,10 +24,10 @@ import (
 	isatty "github.com/mattn/go-isatty"
 	log "github.com/sirupsen/logrus"
 	"github.com/yudai/gojsondiff"
-	"github.com/yudai/gojsondiff/formatter"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 
@@ -36,6 +36,26 @@ import (
 
 var ErrDiffFound = fmt.Errorf("Differences found.")
 
+const (
+	// DiffStrategyAll compares every field of the objects.
+	DiffStrategyAll = "all"
+	// DiffStrategySubset only compares the fields that are set in the local
+	// objects.
+	DiffStrategySubset = "subset"
+	// DiffStrategyNormalized compares the objects after removing the fields
+	// managed by the server, fields with their default value and ignored
+	// fields. See Normalizer.
+	DiffStrategyNormalized = "normalized"
+)
+
+const (
+	// DiffOutputUnified prints a unified diff of the objects as YAML.
+	DiffOutputUnified = "unified"
+	// DiffOutputJSON prints the status and changed fields of each object as
+	// JSON.
+	DiffOutputJSON = "json"
+)
+
 // DiffCmd is an interface containing a set of functions that allow diffing
 // between two sets of data containing Kubernetes resouces.
 type DiffCmd interface {
@@ -45,6 +65,17 @@ type DiffCmd interface {
 // Diff is a base representation of the `diff` functionality.
 type Diff struct {
 	DiffStrategy string
+	// Normalizer is used by DiffStrategyNormalized. It may be nil.
+	Normalizer *Normalizer
+	// Output is the output format. The default is a colored diff of the
+	// objects as JSON.
+	Output string
+	// Summary only prints the number of objects by status.
+	Summary bool
+	// ThreeWay also compares objects with the configuration they were last
+	// applied with, to tell changes in the cluster from changes in the
+	// configuration. It requires the objects of b to be live objects.
+	ThreeWay bool
 }
 
 // Client holds the necessary information to connect with a remote Kubernetes
@@ -74,6 +105,16 @@ type LocalEnv struct {
 type DiffRemoteCmd struct {
 	Diff
 	Client *Client
+	// GcTag lists the live objects with this garbage collection tag that are
+	// not part of the local objects as removed, since `ks apply --gc-tag`
+	// would delete them.
+	GcTag string
+	// GcUnlabeled also lists live objects that only carry the gc-tag
+	// annotation, see ApplyCmd.GcUnlabeled.
+	GcUnlabeled bool
+	// Retry configures how listing the objects to garbage collect is
+	// retried.
+	Retry RetryOptions
 }
 
 func (c *DiffRemoteCmd) Run(out io.Writer) error {
@@ -82,12 +123,42 @@ func (c *DiffRemoteCmd) Run(out io.Writer) error {
 		remote = "live"
 	)
 
-	_, liveObjs, err := getLiveObjs(c.Client)
+	live, liveObjs, err := getLiveObjs(c.Client)
 	if err != nil {
 		return err
 	}
 
-	return diffAll(c.Client.APIObjects, liveObjs, local, remote, c.DiffStrategy, &c.Client.Discovery, true, out)
+	var pruned []*unstructured.Unstructured
+	if c.GcTag != "" {
+		pruned, err = c.findPruned(live)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.diffAll(c.Client.APIObjects, liveObjs, pruned, local, remote, &c.Client.Discovery, true, out)
+}
+
+// findPruned returns the live objects that `ks apply --gc-tag` would garbage
+// collect.
+func (c *DiffRemoteCmd) findPruned(live []*unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	seenUids := sets.NewString()
+	for _, obj := range live {
+		seenUids.Insert(string(obj.GetUID()))
+	}
+
+	namespaces := sets.NewString(c.Client.Namespace)
+	for _, obj := range c.Client.APIObjects {
+		if obj.GetNamespace() != "" {
+			namespaces.Insert(obj.GetNamespace())
+		}
+	}
+
+	eligible := func(o metav1.Object) bool {
+		return eligibleForGc(o, c.GcTag)
+	}
+
+	return findGcCandidates(c.Client.ClientPool, c.Client.Discovery, gcTagSelectors(c.GcTag, c.GcUnlabeled), namespaces.List(), seenUids, eligible, c.Retry)
 }
 
 // ---------------------------------------------------------------------------
@@ -106,7 +177,7 @@ func (c *DiffLocalCmd) Run(out io.Writer) error {
 		m[hash(nil, b, true)] = b
 	}
 
-	return diffAll(c.Env1.APIObjects, m, c.Env1.Name, c.Env2.Name, c.DiffStrategy, nil, true, out)
+	return c.diffAll(c.Env1.APIObjects, m, nil, c.Env1.Name, c.Env2.Name, nil, true, out)
 }
 
 // ---------------------------------------------------------------------------
@@ -130,66 +201,101 @@ func (c *DiffRemotesCmd) Run(out io.Writer) error {
 		return err
 	}
 
-	return diffAll(liveObjsA, liveObjsB, c.ClientA.Name, c.ClientB.Name, c.DiffStrategy, &c.ClientA.Discovery, false, out)
+	return c.diffAll(liveObjsA, liveObjsB, nil, c.ClientA.Name, c.ClientB.Name, &c.ClientA.Discovery, false, out)
 }
 
 // ---------------------------------------------------------------------------
 
-func diffAll(a []*unstructured.Unstructured, b map[string]*unstructured.Unstructured, aName, bName, strategy string,
-	discovery *discovery.DiscoveryInterface, fqName bool, out io.Writer) error {
+// diffAll compares the objects a with their versions in b. removed are
+// objects that only exist in the location of b.
+func (d *Diff) diffAll(a []*unstructured.Unstructured, b map[string]*unstructured.Unstructured, removed []*unstructured.Unstructured,
+	aName, bName string, discovery *discovery.DiscoveryInterface, fqName bool, out io.Writer) error {
 
 	sort.Sort(utils.AlphabeticalOrder(a))
+	sort.Sort(utils.AlphabeticalOrder(removed))
 
-	diffFound := false
+	var diffs []*objectDiff
 	for _, o := range a {
 		desc := hash(discovery, o, fqName)
-		var bObj map[string]interface{}
-		if b[desc] != nil {
-			bObj = b[desc].Object
-		}
+		diffs = append(diffs, d.diffObject(desc, o, b[desc]))
+	}
+	for _, o := range removed {
+		diffs = append(diffs, d.diffObject(hash(discovery, o, fqName), nil, o))
+	}
 
-		var err error
-		log.Debugf("Diffing %s\nA: %s\nB: %s\n", desc, o.Object, bObj)
-		diffFound, err = diff(desc, aName, bName, strategy, o.Object, bObj, out)
-		if err != nil {
-			return err
-		}
+	if err := d.write(out, aName, bName, diffs); err != nil {
+		return err
 	}
 
-	if diffFound {
-		return ErrDiffFound
+	for _, od := range diffs {
+		if od.status != DiffStatusUnchanged {
+			return ErrDiffFound
+		}
 	}
 	return nil
 }
 
-func diff(desc, aName, bName, strategy string, aObj, bObj map[string]interface{}, out io.Writer) (diffFound bool, err error) {
-	fmt.Fprintln(out, "---")
-	fmt.Fprintf(out, "- %s %s\n+ %s %s\n", bName, desc, aName, desc)
-	if bObj == nil {
-		fmt.Fprintf(out, "%s doesn't exist on %s\n", desc, bName)
-		return true, nil
-	}
+// diffObject compares the version a of an object with the version b. Either
+// of them may be nil if the object doesn't exist.
+func (d *Diff) diffObject(desc string, a, b *unstructured.Unstructured) *objectDiff {
+	// Secret values are never printed, only whether they changed.
+	a, b = redactSecret(a), redactSecret(b)
 
-	if strategy == "subset" {
-		bObj = removeMapFields(aObj, bObj)
+	od := &objectDiff{
+		desc:   desc,
+		subset: d.DiffStrategy == DiffStrategySubset,
 	}
-	diff := gojsondiff.New().CompareObjects(bObj, aObj)
 
-	if diff.Modified() {
-		fcfg := formatter.AsciiFormatterConfig{
-			Coloring: istty(out),
+	obj := a
+	if obj == nil {
+		obj = b
+	}
+	od.obj = obj
+	od.t = d.Normalizer.typeFor(obj)
+
+	for _, v := range []struct {
+		obj  *unstructured.Unstructured
+		dest *map[string]interface{}
+	}{{a, &od.a}, {b, &od.b}} {
+		if v.obj == nil {
+			continue
 		}
-		formatter := formatter.NewAsciiFormatter(bObj, fcfg)
-		text, err := formatter.Format(diff)
-		if err != nil {
-			return true, err
+		if d.DiffStrategy == DiffStrategyNormalized {
+			*v.dest = d.Normalizer.Normalize(v.obj).Object
+		} else {
+			*v.dest = v.obj.Object
 		}
-		fmt.Fprintf(out, "%s", text)
-		return true, nil
 	}
 
-	fmt.Fprintf(out, "%s unchanged\n", desc)
-	return false, nil
+	log.Debugf("Diffing %s\nA: %s\nB: %s\n", desc, od.a, od.b)
+
+	switch {
+	case od.b == nil:
+		od.status = DiffStatusAdded
+		return od
+	case od.a == nil:
+		od.status = DiffStatusRemoved
+		return od
+	}
+
+	// Match list elements by their merge key, if the schema has one.
+	keyedA, keyedB := keyListPair(od.a, od.b, od.t)
+	od.keyedA = keyedA.(map[string]interface{})
+	od.keyedB = keyedB.(map[string]interface{})
+	if od.subset {
+		od.keyedB = removeMapFields(od.keyedA, od.keyedB)
+	}
+
+	od.delta = gojsondiff.New().CompareObjects(od.keyedB, od.keyedA)
+	od.status = DiffStatusUnchanged
+	if od.delta.Modified() {
+		od.status = DiffStatusChanged
+	}
+
+	if d.ThreeWay {
+		od.threeWay, od.hasLastApplied = d.diffThreeWay(desc, a, b, od.t)
+	}
+	return od
 }
 
 // hash serves as an identifier for the Kubernetes resource.
@@ -235,12 +341,15 @@ func getLiveObjs(client *Client) ([]*unstructured.Unstructured, map[string]*unst
 func removeFields(config, live interface{}) interface{} {
 	switch c := config.(type) {
 	case map[string]interface{}:
-		return removeMapFields(c, live.(map[string]interface{}))
+		if l, ok := live.(map[string]interface{}); ok {
+			return removeMapFields(c, l)
+		}
 	case []interface{}:
-		return removeListFields(c, live.([]interface{}))
-	default:
-		return live
+		if l, ok := live.([]interface{}); ok {
+			return removeListFields(c, l)
+		}
 	}
+	return live
 }
 
 func removeMapFields(config, live map[string]interface{}) map[string]interface{} {
@@ -0,0 +1,413 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/yudai/gojsondiff"
+	"github.com/yudai/gojsondiff/formatter"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/ksonnet/ksonnet/pkg/util/k8s"
+)
+
+const (
+	// DiffStatusAdded is the status of objects that only exist in the first
+	// location.
+	DiffStatusAdded = "added"
+	// DiffStatusRemoved is the status of objects that only exist in the
+	// second location.
+	DiffStatusRemoved = "removed"
+	// DiffStatusChanged is the status of objects that differ.
+	DiffStatusChanged = "changed"
+	// DiffStatusUnchanged is the status of objects that are the same.
+	DiffStatusUnchanged = "unchanged"
+)
+
+// objectDiff is the difference between two versions of an object.
+type objectDiff struct {
+	desc   string
+	status string
+	// obj identifies the object.
+	obj *unstructured.Unstructured
+	t   *k8s.SchemaType
+	// a and b are the versions of the object that are compared, after
+	// normalization. One of them is nil if the object doesn't exist in its
+	// location.
+	a, b   map[string]interface{}
+	subset bool
+
+	// keyedA and keyedB are the versions that gojsondiff compares, with
+	// lists matched by key. They are only set if both versions exist.
+	keyedA, keyedB map[string]interface{}
+	delta          gojsondiff.Diff
+
+	// threeWay are the changes compared to the last applied configuration,
+	// if hasLastApplied is set.
+	threeWay       []threeWayChange
+	hasLastApplied bool
+}
+
+// write prints diffs in the output format of d.
+func (d *Diff) write(out io.Writer, aName, bName string, diffs []*objectDiff) error {
+	switch d.Output {
+	case "":
+		if d.Summary {
+			return writeDiffSummary(out, diffs, d.ThreeWay)
+		}
+		return writeDiffText(out, aName, bName, diffs, d.ThreeWay)
+	case DiffOutputUnified:
+		if d.ThreeWay {
+			return fmt.Errorf("three-way diffs can't be printed as %s diffs", DiffOutputUnified)
+		}
+		if d.Summary {
+			return writeDiffSummary(out, diffs, false)
+		}
+		return writeDiffUnified(out, aName, bName, diffs)
+	case DiffOutputJSON:
+		return writeDiffJSON(out, aName, bName, diffs, d.Summary)
+	default:
+		return fmt.Errorf("invalid diff output format %q", d.Output)
+	}
+}
+
+// diffSummary counts objects by status and, for three-way diffs, fields by
+// label.
+type diffSummary struct {
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Changed   int `json:"changed"`
+	Unchanged int `json:"unchanged"`
+
+	ChangedInConfig  int `json:"changedInConfig,omitempty"`
+	DriftedInCluster int `json:"driftedInCluster,omitempty"`
+	Conflicting      int `json:"conflicting,omitempty"`
+}
+
+func summarizeDiffs(diffs []*objectDiff) diffSummary {
+	var s diffSummary
+	for _, od := range diffs {
+		switch od.status {
+		case DiffStatusAdded:
+			s.Added++
+		case DiffStatusRemoved:
+			s.Removed++
+		case DiffStatusChanged:
+			s.Changed++
+		case DiffStatusUnchanged:
+			s.Unchanged++
+		}
+
+		for _, c := range od.threeWay {
+			switch c.Label {
+			case FieldChangedInConfig:
+				s.ChangedInConfig++
+			case FieldDriftedInCluster:
+				s.DriftedInCluster++
+			case FieldConflicting:
+				s.Conflicting++
+			}
+		}
+	}
+	return s
+}
+
+func writeDiffSummary(out io.Writer, diffs []*objectDiff, threeWay bool) error {
+	s := summarizeDiffs(diffs)
+	fmt.Fprintf(out, "%d added, %d removed, %d changed, %d unchanged", s.Added, s.Removed, s.Changed, s.Unchanged)
+	if threeWay {
+		fmt.Fprintf(out, "; fields: %d changed in config, %d drifted in cluster, %d conflicting",
+			s.ChangedInConfig, s.DriftedInCluster, s.Conflicting)
+	}
+	_, err := fmt.Fprintln(out)
+	return err
+}
+
+// writeDiffText prints the colored gojsondiff of each object. For three-way
+// diffs, the labeled changes are printed instead, if the object has a last
+// applied configuration.
+func writeDiffText(out io.Writer, aName, bName string, diffs []*objectDiff, threeWay bool) error {
+	for _, od := range diffs {
+		fmt.Fprintln(out, "---")
+		fmt.Fprintf(out, "- %s %s\n+ %s %s\n", bName, od.desc, aName, od.desc)
+
+		switch od.status {
+		case DiffStatusAdded:
+			fmt.Fprintf(out, "%s doesn't exist on %s\n", od.desc, bName)
+		case DiffStatusRemoved:
+			fmt.Fprintf(out, "%s doesn't exist on %s\n", od.desc, aName)
+		case DiffStatusUnchanged:
+			fmt.Fprintf(out, "%s unchanged\n", od.desc)
+		case DiffStatusChanged:
+			if threeWay {
+				if od.hasLastApplied {
+					if len(od.threeWay) == 0 {
+						fmt.Fprintf(out, "%s only differs in fields that are not set by the configuration\n", od.desc)
+					}
+					writeThreeWayText(out, od.threeWay)
+					continue
+				}
+				fmt.Fprintf(out, "%s has no last applied configuration\n", od.desc)
+			}
+
+			fcfg := formatter.AsciiFormatterConfig{
+				Coloring: istty(out),
+			}
+			text, err := formatter.NewAsciiFormatter(od.keyedB, fcfg).Format(od.delta)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%s", text)
+		}
+	}
+	return nil
+}
+
+// writeDiffUnified prints a unified diff of the YAML of each object that
+// isn't unchanged.
+func writeDiffUnified(out io.Writer, aName, bName string, diffs []*objectDiff) error {
+	for _, od := range diffs {
+		if od.status == DiffStatusUnchanged {
+			continue
+		}
+
+		b := od.b
+		if od.a != nil && b != nil {
+			// Align keyed lists so reordered elements don't show up.
+			b = alignLists(od.a, b, od.t).(map[string]interface{})
+			if od.subset {
+				b = removeMapFields(od.a, b)
+			}
+		}
+
+		from, err := yamlLines(b)
+		if err != nil {
+			return err
+		}
+		to, err := yamlLines(od.a)
+		if err != nil {
+			return err
+		}
+
+		err = difflib.WriteUnifiedDiff(out, difflib.UnifiedDiff{
+			A:        from,
+			B:        to,
+			FromFile: fmt.Sprintf("%s %s", bName, od.desc),
+			ToFile:   fmt.Sprintf("%s %s", aName, od.desc),
+			Context:  3,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// yamlLines returns the lines of the YAML representation of obj. A nil
+// object has no lines.
+func yamlLines(obj map[string]interface{}) ([]string, error) {
+	if obj == nil {
+		return nil, nil
+	}
+
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return nil, errors.Wrap(err, "convert object to YAML")
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, nil
+}
+
+// diffReport is the JSON output of a diff.
+type diffReport struct {
+	From    string             `json:"from"`
+	To      string             `json:"to"`
+	Objects []objectDiffReport `json:"objects,omitempty"`
+	Summary diffSummary        `json:"summary"`
+}
+
+type objectDiffReport struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Namespace  string        `json:"namespace,omitempty"`
+	Name       string        `json:"name"`
+	Status     string        `json:"status"`
+	Changes    []fieldChange `json:"changes,omitempty"`
+	// ThreeWay are the changes compared to the last applied configuration.
+	ThreeWay []threeWayChange `json:"threeWay,omitempty"`
+}
+
+// fieldChange is a change of a single field. Old is the value in the second
+// location, New the value in the first.
+type fieldChange struct {
+	Path string      `json:"path"`
+	Type string      `json:"type"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// writeDiffJSON prints the status and field changes of every object as a
+// JSON document.
+func writeDiffJSON(out io.Writer, aName, bName string, diffs []*objectDiff, summary bool) error {
+	report := diffReport{
+		From:    bName,
+		To:      aName,
+		Summary: summarizeDiffs(diffs),
+	}
+
+	if !summary {
+		report.Objects = make([]objectDiffReport, 0, len(diffs))
+		for _, od := range diffs {
+			r := objectDiffReport{
+				APIVersion: od.obj.GetAPIVersion(),
+				Kind:       od.obj.GetKind(),
+				Namespace:  od.obj.GetNamespace(),
+				Name:       od.obj.GetName(),
+				Status:     od.status,
+			}
+			if od.status == DiffStatusChanged {
+				r.Changes = fieldChanges("", od.b, od.a, od.t, od.subset)
+				r.ThreeWay = od.threeWay
+			}
+			report.Objects = append(report.Objects, r)
+		}
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(report)
+}
+
+// fieldChanges lists the fields that differ between old and new. Keyed
+// lists are matched by key. With subset, fields that are only set in old
+// are ignored.
+func fieldChanges(path string, old, new interface{}, t *k8s.SchemaType, subset bool) []fieldChange {
+	switch n := new.(type) {
+	case map[string]interface{}:
+		o, ok := old.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		if !subset {
+			for k := range o {
+				if _, ok := n[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+		}
+		sort.Strings(keys)
+
+		var changes []fieldChange
+		for _, k := range keys {
+			changes = append(changes, childChanges(fieldPath(path, k), o, n, k, t.Field(k), subset)...)
+		}
+		return changes
+	case []interface{}:
+		o, ok := old.([]interface{})
+		if !ok {
+			break
+		}
+
+		mergeKey := t.MergeKey()
+		keyedO, okO := keyList(o, mergeKey)
+		keyedN, okN := keyList(n, mergeKey)
+		if okO && okN {
+			keys := listKeys(n, mergeKey)
+			if !subset {
+				for _, k := range listKeys(o, mergeKey) {
+					if _, ok := keyedN[k]; !ok {
+						keys = append(keys, k)
+					}
+				}
+			}
+
+			var changes []fieldChange
+			for _, k := range keys {
+				p := fmt.Sprintf("%s[%s=%s]", path, mergeKey, k)
+				changes = append(changes, childChanges(p, keyedO, keyedN, k, t.Items(), subset)...)
+			}
+			return changes
+		}
+
+		count := len(n)
+		if !subset && len(o) > count {
+			count = len(o)
+		}
+
+		var changes []fieldChange
+		for i := 0; i < count; i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(o):
+				changes = append(changes, fieldChange{Path: p, Type: DiffStatusAdded, New: n[i]})
+			case i >= len(n):
+				changes = append(changes, fieldChange{Path: p, Type: DiffStatusRemoved, Old: o[i]})
+			default:
+				changes = append(changes, fieldChanges(p, o[i], n[i], t.Items(), subset)...)
+			}
+		}
+		return changes
+	}
+
+	if jsonEqual(old, new) {
+		return nil
+	}
+	return []fieldChange{{Path: path, Type: DiffStatusChanged, Old: old, New: new}}
+}
+
+// childChanges lists the changes of the field k of old and new.
+func childChanges(path string, old, new map[string]interface{}, k string, t *k8s.SchemaType, subset bool) []fieldChange {
+	ov, okO := old[k]
+	nv, okN := new[k]
+	switch {
+	case !okO:
+		return []fieldChange{{Path: path, Type: DiffStatusAdded, New: nv}}
+	case !okN:
+		return []fieldChange{{Path: path, Type: DiffStatusRemoved, Old: ov}}
+	default:
+		return fieldChanges(path, ov, nv, t, subset)
+	}
+}
+
+// fieldPath appends the field k to path, in the syntax of the ignore rules
+// in app.yaml.
+func fieldPath(path, k string) string {
+	if strings.ContainsAny(k, ".[]") {
+		return fmt.Sprintf("%s[%s]", path, k)
+	}
+	if path == "" {
+		return k
+	}
+	return path + "." + k
+}
@@ -0,0 +1,232 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/ksonnet/ksonnet/pkg/util/k8s"
+)
+
+func testDiffCmd(t *testing.T, output string, summary bool) *DiffLocalCmd {
+	schema, err := k8s.ParseSchema([]byte(normalizeSchema))
+	require.NoError(t, err)
+	n, err := NewNormalizer(schema, nil)
+	require.NoError(t, err)
+
+	deployment := func(name, containers string) *unstructured.Unstructured {
+		return mustUnstructured(t, `{
+  "apiVersion": "apps/v1beta1",
+  "kind": "Deployment",
+  "metadata": {"name": "`+name+`", "namespace": "default"},
+  "spec": {"template": {"spec": {"containers": `+containers+`}}}
+}`)
+	}
+
+	return &DiffLocalCmd{
+		Diff: Diff{
+			DiffStrategy: DiffStrategyNormalized,
+			Normalizer:   n,
+			Output:       output,
+			Summary:      summary,
+		},
+		Env1: &LocalEnv{
+			Name: "local",
+			APIObjects: []*unstructured.Unstructured{
+				deployment("web", `[{"name": "web", "image": "nginx:1.13"}, {"name": "proxy", "image": "envoy"}]`),
+				deployment("api", `[{"name": "api", "image": "api"}]`),
+				deployment("worker", `[{"name": "worker", "image": "worker"}]`),
+			},
+		},
+		Env2: &LocalEnv{
+			Name: "remote",
+			APIObjects: []*unstructured.Unstructured{
+				deployment("web", `[{"name": "proxy", "image": "envoy"}, {"name": "web", "image": "nginx:1.12"}]`),
+				deployment("api", `[{"name": "api", "image": "api"}]`),
+			},
+		},
+	}
+}
+
+func TestDiffOutputUnified(t *testing.T) {
+	c := testDiffCmd(t, DiffOutputUnified, false)
+
+	var buf bytes.Buffer
+	err := c.Run(&buf)
+	require.Equal(t, ErrDiffFound, err)
+
+	expected := `--- remote deployment default.web
++++ local deployment default.web
+@@ -7,7 +7,7 @@
+   template:
+     spec:
+       containers:
+-      - image: nginx:1.12
++      - image: nginx:1.13
+         name: web
+       - image: envoy
+         name: proxy
+--- remote deployment default.worker
++++ local deployment default.worker
+@@ -0,0 +1,11 @@
++apiVersion: apps/v1beta1
++kind: Deployment
++metadata:
++  name: worker
++  namespace: default
++spec:
++  template:
++    spec:
++      containers:
++      - image: worker
++        name: worker
+`
+	require.Equal(t, expected, buf.String())
+}
+
+func TestDiffOutputJSON(t *testing.T) {
+	c := testDiffCmd(t, DiffOutputJSON, false)
+
+	var buf bytes.Buffer
+	err := c.Run(&buf)
+	require.Equal(t, ErrDiffFound, err)
+
+	var report diffReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+
+	require.Equal(t, "remote", report.From)
+	require.Equal(t, "local", report.To)
+	require.Equal(t, diffSummary{Added: 1, Changed: 1, Unchanged: 1}, report.Summary)
+	require.Len(t, report.Objects, 3)
+
+	byName := make(map[string]objectDiffReport)
+	for _, o := range report.Objects {
+		byName[o.Name] = o
+	}
+
+	require.Equal(t, DiffStatusUnchanged, byName["api"].Status)
+	require.Equal(t, DiffStatusAdded, byName["worker"].Status)
+	require.Equal(t, objectDiffReport{
+		APIVersion: "apps/v1beta1",
+		Kind:       "Deployment",
+		Namespace:  "default",
+		Name:       "web",
+		Status:     DiffStatusChanged,
+		Changes: []fieldChange{
+			{
+				Path: "spec.template.spec.containers[name=web].image",
+				Type: DiffStatusChanged,
+				Old:  "nginx:1.12",
+				New:  "nginx:1.13",
+			},
+		},
+	}, byName["web"])
+}
+
+func TestDiffOutputSummary(t *testing.T) {
+	c := testDiffCmd(t, "", true)
+
+	var buf bytes.Buffer
+	err := c.Run(&buf)
+	require.Equal(t, ErrDiffFound, err)
+	require.Equal(t, "1 added, 0 removed, 1 changed, 1 unchanged\n", buf.String())
+
+	c = testDiffCmd(t, DiffOutputJSON, true)
+	buf.Reset()
+	err = c.Run(&buf)
+	require.Equal(t, ErrDiffFound, err)
+
+	var report diffReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+	require.Empty(t, report.Objects)
+	require.Equal(t, diffSummary{Added: 1, Changed: 1, Unchanged: 1}, report.Summary)
+}
+
+func TestFieldChanges(t *testing.T) {
+	old := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{"example.com/owner": "a"},
+			"labels":      map[string]interface{}{"app": "web"},
+		},
+		"args": []interface{}{"a", "b"},
+	}
+	new := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{"example.com/owner": "b"},
+		},
+		"args": []interface{}{"a", "c", "d"},
+	}
+
+	expected := []fieldChange{
+		{Path: "args[1]", Type: DiffStatusChanged, Old: "b", New: "c"},
+		{Path: "args[2]", Type: DiffStatusAdded, New: "d"},
+		{Path: "metadata.annotations[example.com/owner]", Type: DiffStatusChanged, Old: "a", New: "b"},
+		{Path: "metadata.labels", Type: DiffStatusRemoved, Old: map[string]interface{}{"app": "web"}},
+	}
+	require.Equal(t, expected, fieldChanges("", old, new, nil, false))
+
+	// Fields that are only set in old are ignored by subset diffs.
+	require.Equal(t, expected[:3], fieldChanges("", old, new, nil, true))
+}
+
+func TestDiffRemoved(t *testing.T) {
+	pruned := mustUnstructured(t, `{
+  "apiVersion": "v1",
+  "kind": "ConfigMap",
+  "metadata": {
+    "name": "old",
+    "namespace": "default",
+    "uid": "1234",
+    "annotations": {"kubecfg.ksonnet.io/garbage-collect-tag": "dev"}
+  },
+  "data": {"key": "value"}
+}`)
+
+	d := Diff{DiffStrategy: DiffStrategyNormalized, Output: DiffOutputJSON}
+
+	var buf bytes.Buffer
+	err := d.diffAll(nil, nil, []*unstructured.Unstructured{pruned}, "config", "live", nil, true, &buf)
+	require.Equal(t, ErrDiffFound, err)
+
+	var report diffReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+	require.Equal(t, []objectDiffReport{
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "old", Status: DiffStatusRemoved},
+	}, report.Objects)
+
+	d.Output = DiffOutputUnified
+	buf.Reset()
+	err = d.diffAll(nil, nil, []*unstructured.Unstructured{pruned}, "config", "live", nil, true, &buf)
+	require.Equal(t, ErrDiffFound, err)
+
+	expected := `--- live configmap default.old
++++ config configmap default.old
+@@ -1,7 +0,0 @@
+-apiVersion: v1
+-data:
+-  key: value
+-kind: ConfigMap
+-metadata:
+-  name: old
+-  namespace: default
+`
+	require.Equal(t, expected, buf.String())
+}
@@ -100,6 +100,13 @@ func TestRemoveFields(t *testing.T) {
 			expected: "b",
 		},
 
+		// Check we can handle values of different types.
+		{
+			config:   map[string]interface{}{"web": "nginx"},
+			live:     []interface{}{"nginx"},
+			expected: []interface{}{"nginx"},
+		},
+
 		// Check we can handle combinations.
 		{
 			config: map[string]interface{}{
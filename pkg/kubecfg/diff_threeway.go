@@ -0,0 +1,256 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/ksonnet/ksonnet/pkg/util/k8s"
+)
+
+const (
+	// FieldChangedInConfig labels fields that were changed in the local
+	// configuration since it was last applied.
+	FieldChangedInConfig = "changed-in-config"
+	// FieldDriftedInCluster labels fields that were changed in the cluster,
+	// e.g. with kubectl, since the configuration was last applied.
+	FieldDriftedInCluster = "drifted-in-cluster"
+	// FieldConflicting labels fields that were changed both in the local
+	// configuration and in the cluster.
+	FieldConflicting = "conflicting"
+)
+
+// threeWayChange is a field that differs between the last applied, live and
+// desired versions of an object. Unset values are nil.
+type threeWayChange struct {
+	Path        string      `json:"path"`
+	Label       string      `json:"label"`
+	LastApplied interface{} `json:"lastApplied,omitempty"`
+	Live        interface{} `json:"live,omitempty"`
+	Desired     interface{} `json:"desired,omitempty"`
+}
+
+// absentField is the value of fields that are not set, to tell them apart
+// from fields that are null.
+type absentField struct{}
+
+var absent = absentField{}
+
+// diffThreeWay compares the desired version of an object with its live
+// version and the configuration it was last applied with. It returns false
+// if the live object has no last applied configuration.
+func (d *Diff) diffThreeWay(desc string, desired, live *unstructured.Unstructured, t *k8s.SchemaType) ([]threeWayChange, bool) {
+	data := lastApplied(live)
+	if len(data) == 0 {
+		log.Debugf("%s has no last applied configuration", desc)
+		return nil, false
+	}
+
+	last := &unstructured.Unstructured{}
+	if err := last.UnmarshalJSON(data); err != nil {
+		log.Warnf("Ignoring invalid last applied configuration of %s: %s", desc, err)
+		return nil, false
+	}
+
+	// The ownership labels and annotations are added by `ks apply`, so they
+	// aren't in the desired object and would otherwise always be reported.
+	objs := []*unstructured.Unstructured{last, live, desired}
+	for i, obj := range objs {
+		objs[i] = withoutApplyMetadata(obj)
+		if d.DiffStrategy == DiffStrategyNormalized {
+			objs[i] = d.Normalizer.Normalize(objs[i])
+		}
+	}
+
+	return threeWayChanges("", objs[0].Object, objs[1].Object, objs[2].Object, t), true
+}
+
+// withoutApplyMetadata returns a copy of obj without the labels and
+// annotations that `ks apply` adds to objects. Labels and annotations that
+// are left empty are removed as well.
+func withoutApplyMetadata(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	o := obj.DeepCopy()
+	metadata, ok := o.Object["metadata"].(map[string]interface{})
+	if !ok {
+		return o
+	}
+
+	if labels, ok := metadata["labels"].(map[string]interface{}); ok {
+		for _, l := range managedLabels {
+			delete(labels, l)
+		}
+		if len(labels) == 0 {
+			delete(metadata, "labels")
+		}
+	}
+	if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+		delete(annotations, AnnotationLastApplied)
+		delete(annotations, AnnotationGcTag)
+		if len(annotations) == 0 {
+			delete(metadata, "annotations")
+		}
+	}
+	return o
+}
+
+// threeWayChanges lists the fields that differ between the last applied,
+// live and desired values. Only fields that are set in the last applied or
+// desired configuration are compared; fields that only exist in the
+// cluster, e.g. because they are set by the server, are left alone by
+// `ks apply` and are not reported.
+func threeWayChanges(path string, last, live, desired interface{}, t *k8s.SchemaType) []threeWayChange {
+	lastMap, ok1 := last.(map[string]interface{})
+	liveMap, ok2 := live.(map[string]interface{})
+	desiredMap, ok3 := desired.(map[string]interface{})
+	if ok1 && ok2 && ok3 {
+		keys := make([]string, 0, len(desiredMap))
+		for k := range desiredMap {
+			keys = append(keys, k)
+		}
+		for k := range lastMap {
+			if _, ok := desiredMap[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		var changes []threeWayChange
+		for _, k := range keys {
+			changes = append(changes, threeWayChanges(fieldPath(path, k),
+				fieldOrAbsent(lastMap, k), fieldOrAbsent(liveMap, k), fieldOrAbsent(desiredMap, k), t.Field(k))...)
+		}
+		return changes
+	}
+
+	lastList, ok1 := last.([]interface{})
+	liveList, ok2 := live.([]interface{})
+	desiredList, ok3 := desired.([]interface{})
+	if ok1 && ok2 && ok3 {
+		mergeKey := t.MergeKey()
+		keyedLast, ok1 := keyList(lastList, mergeKey)
+		keyedLive, ok2 := keyList(liveList, mergeKey)
+		keyedDesired, ok3 := keyList(desiredList, mergeKey)
+		if ok1 && ok2 && ok3 {
+			keys := listKeys(desiredList, mergeKey)
+			for _, k := range listKeys(lastList, mergeKey) {
+				if _, ok := keyedDesired[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+
+			var changes []threeWayChange
+			for _, k := range keys {
+				p := fmt.Sprintf("%s[%s=%s]", path, mergeKey, k)
+				changes = append(changes, threeWayChanges(p,
+					fieldOrAbsent(keyedLast, k), fieldOrAbsent(keyedLive, k), fieldOrAbsent(keyedDesired, k), t.Items())...)
+			}
+			return changes
+		}
+
+		if len(lastList) == len(liveList) && len(liveList) == len(desiredList) {
+			var changes []threeWayChange
+			for i := range desiredList {
+				p := fmt.Sprintf("%s[%d]", path, i)
+				changes = append(changes, threeWayChanges(p, lastList[i], liveList[i], desiredList[i], t.Items())...)
+			}
+			return changes
+		}
+	}
+
+	configChanged := !sameField(last, desired)
+	drifted := !sameField(last, live)
+
+	var label string
+	switch {
+	case configChanged && drifted:
+		if sameField(live, desired) {
+			// The cluster was changed like the configuration.
+			return nil
+		}
+		label = FieldConflicting
+	case configChanged:
+		label = FieldChangedInConfig
+	case drifted:
+		label = FieldDriftedInCluster
+	default:
+		return nil
+	}
+
+	return []threeWayChange{{
+		Path:        path,
+		Label:       label,
+		LastApplied: fieldValue(last),
+		Live:        fieldValue(live),
+		Desired:     fieldValue(desired),
+	}}
+}
+
+// fieldOrAbsent returns the field k of m, or absent.
+func fieldOrAbsent(m map[string]interface{}, k string) interface{} {
+	if v, ok := m[k]; ok {
+		return v
+	}
+	return absent
+}
+
+func fieldValue(v interface{}) interface{} {
+	if v == absent {
+		return nil
+	}
+	return v
+}
+
+func sameField(a, b interface{}) bool {
+	if a == absent || b == absent {
+		return a == b
+	}
+	return jsonEqual(a, b)
+}
+
+// writeThreeWayText prints the three-way changes of an object.
+func writeThreeWayText(out io.Writer, changes []threeWayChange) {
+	for _, c := range changes {
+		var text string
+		switch c.Label {
+		case FieldChangedInConfig:
+			text = fmt.Sprintf("%s -> %s", formatField(c.LastApplied), formatField(c.Desired))
+		case FieldDriftedInCluster:
+			text = fmt.Sprintf("%s -> %s", formatField(c.LastApplied), formatField(c.Live))
+		case FieldConflicting:
+			text = fmt.Sprintf("last applied %s, live %s, config %s",
+				formatField(c.LastApplied), formatField(c.Live), formatField(c.Desired))
+		}
+		fmt.Fprintf(out, "%-19s %s: %s\n", c.Label, c.Path, text)
+	}
+}
+
+// formatField formats the value of a field for the text output.
+func formatField(v interface{}) string {
+	if v == nil {
+		return "<none>"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
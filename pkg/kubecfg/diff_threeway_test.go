@@ -0,0 +1,141 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/ksonnet/ksonnet/pkg/util/k8s"
+)
+
+func TestThreeWayChanges(t *testing.T) {
+	schema, err := k8s.ParseSchema([]byte(normalizeSchema))
+	require.NoError(t, err)
+
+	deployment := func(replicas int, containers string) map[string]interface{} {
+		return mustUnstructured(t, `{
+  "apiVersion": "apps/v1beta1",
+  "kind": "Deployment",
+  "metadata": {"name": "web"},
+  "spec": {
+    "replicas": `+strconv.Itoa(replicas)+`,
+    "template": {"spec": {"containers": `+containers+`}}
+  }
+}`).Object
+	}
+
+	last := deployment(1, `[{"name": "web", "image": "nginx:1"}, {"name": "proxy", "image": "envoy:1"}, {"name": "log", "image": "fluentd:1"}]`)
+	// The cluster scaled the deployment, changed the proxy and the log
+	// image, and added a field the configuration doesn't set.
+	live := deployment(3, `[{"name": "proxy", "image": "envoy:2"}, {"name": "web", "image": "nginx:1", "stdin": true}, {"name": "log", "image": "fluentd:2"}]`)
+	// The configuration changed the web and the proxy image, and the log
+	// image like the cluster.
+	desired := deployment(1, `[{"name": "web", "image": "nginx:2"}, {"name": "proxy", "image": "envoy:3"}, {"name": "log", "image": "fluentd:2"}]`)
+
+	typ := schema.TypeFor((&unstructured.Unstructured{Object: desired}).GroupVersionKind())
+	got := threeWayChanges("", last, live, desired, typ)
+
+	expected := []threeWayChange{
+		{Path: "spec.replicas", Label: FieldDriftedInCluster, LastApplied: float64(1), Live: float64(3), Desired: float64(1)},
+		{Path: "spec.template.spec.containers[name=web].image", Label: FieldChangedInConfig, LastApplied: "nginx:1", Live: "nginx:1", Desired: "nginx:2"},
+		{Path: "spec.template.spec.containers[name=proxy].image", Label: FieldConflicting, LastApplied: "envoy:1", Live: "envoy:2", Desired: "envoy:3"},
+	}
+	require.Equal(t, expected, got)
+}
+
+func TestDiffThreeWay(t *testing.T) {
+	desired := mustUnstructured(t, `{
+  "apiVersion": "v1",
+  "kind": "ConfigMap",
+  "metadata": {"name": "cfg", "namespace": "default"},
+  "data": {"a": "1", "b": "2"}
+}`)
+
+	last := desired.DeepCopy()
+	last.Object["data"] = map[string]interface{}{"a": "1", "b": "1"}
+	data, err := json.Marshal(last.Object)
+	require.NoError(t, err)
+
+	live := desired.DeepCopy()
+	live.Object["data"] = map[string]interface{}{"a": "0", "b": "1"}
+	live.SetAnnotations(map[string]string{AnnotationLastApplied: string(data)})
+	live.SetUID("1234")
+
+	d := Diff{DiffStrategy: DiffStrategyNormalized, ThreeWay: true}
+	hash := hash(nil, desired, true)
+
+	var buf bytes.Buffer
+	err = d.diffAll([]*unstructured.Unstructured{desired}, map[string]*unstructured.Unstructured{hash: live}, nil,
+		"config", "live", nil, true, &buf)
+	require.Equal(t, ErrDiffFound, err)
+
+	expected := `---
+- live configmap default.cfg
++ config configmap default.cfg
+drifted-in-cluster  data.a: "1" -> "0"
+changed-in-config   data.b: "1" -> "2"
+`
+	require.Equal(t, expected, buf.String())
+
+	d.Summary = true
+	buf.Reset()
+	err = d.diffAll([]*unstructured.Unstructured{desired}, map[string]*unstructured.Unstructured{hash: live}, nil,
+		"config", "live", nil, true, &buf)
+	require.Equal(t, ErrDiffFound, err)
+	require.Equal(t, "0 added, 0 removed, 1 changed, 0 unchanged; fields: 1 changed in config, 1 drifted in cluster, 0 conflicting\n", buf.String())
+
+	// Objects that were never applied fall back to a two-way diff.
+	live.SetAnnotations(nil)
+	d.Summary = false
+	buf.Reset()
+	err = d.diffAll([]*unstructured.Unstructured{desired}, map[string]*unstructured.Unstructured{hash: live}, nil,
+		"config", "live", nil, true, &buf)
+	require.Equal(t, ErrDiffFound, err)
+	require.Contains(t, buf.String(), "has no last applied configuration\n")
+}
+
+func TestDiffThreeWay_applied(t *testing.T) {
+	newObj := func() *unstructured.Unstructured {
+		return mustUnstructured(t, `{
+  "apiVersion": "v1",
+  "kind": "ConfigMap",
+  "metadata": {"name": "cfg", "namespace": "default"},
+  "data": {"a": "1"}
+}`)
+	}
+
+	c := ApplyCmd{App: "guestbook", Env: "default", GcTag: "my-gctag", Create: true}
+	obj := newObj()
+	c.setOwnership(obj)
+	live, _, err := c.applyObject(newFakeResourceClient(), obj, "config")
+	require.NoError(t, err)
+
+	for _, strategy := range []string{DiffStrategyAll, DiffStrategySubset, DiffStrategyNormalized} {
+		t.Run(strategy, func(t *testing.T) {
+			d := Diff{DiffStrategy: strategy, ThreeWay: true}
+			desired := newObj()
+			got, ok := d.diffThreeWay("config", desired, live, nil)
+			require.True(t, ok)
+			require.Empty(t, got)
+		})
+	}
+}
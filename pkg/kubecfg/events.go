@@ -0,0 +1,95 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Actions reported in events.
+const (
+	// ActionApply is reported for objects that failed before it was known
+	// whether they would be created or patched.
+	ActionApply     = "apply"
+	ActionCreate    = "create"
+	ActionPatch     = "patch"
+	ActionUnchanged = "unchanged"
+	ActionReplace   = "replace"
+	ActionDelete    = "delete"
+	ActionGc        = "gc"
+)
+
+// Event describes what happened to a single object.
+type Event struct {
+	Action     string `json:"action"`
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	UID        string `json:"uid,omitempty"`
+	// Duration is how long the action took, in seconds.
+	Duration float64 `json:"duration"`
+	DryRun   bool    `json:"dryRun,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// EventWriter writes events as a stream of JSON objects, one per line. A nil
+// EventWriter discards events. It is safe for concurrent use.
+type EventWriter struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+// NewEventWriter creates an EventWriter that writes to w.
+func NewEventWriter(w io.Writer) *EventWriter {
+	return &EventWriter{enc: json.NewEncoder(w)}
+}
+
+// emit writes an event for obj. uid is the UID of the object in the
+// cluster, if it is known.
+func (w *EventWriter) emit(action string, obj *unstructured.Unstructured, uid types.UID, start time.Time, dryRun bool, err error) {
+	if w == nil {
+		return
+	}
+
+	e := Event{
+		Action:     action,
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		UID:        string(uid),
+		Duration:   time.Since(start).Seconds(),
+		DryRun:     dryRun,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w.enc.Encode(e); err != nil {
+		log.Warnf("Unable to write event: %s", err)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestEventWriter(t *testing.T) {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("apps/v1beta1")
+	obj.SetKind("Deployment")
+	obj.SetNamespace("default")
+	obj.SetName("web")
+
+	var buf bytes.Buffer
+	w := NewEventWriter(&buf)
+	w.emit(ActionPatch, obj, types.UID("1234"), time.Now(), false, nil)
+	w.emit(ActionGc, obj, "", time.Now(), true, fmt.Errorf("forbidden"))
+
+	dec := json.NewDecoder(&buf)
+
+	var e Event
+	require.NoError(t, dec.Decode(&e))
+	require.Equal(t, ActionPatch, e.Action)
+	require.Equal(t, "apps/v1beta1", e.APIVersion)
+	require.Equal(t, "Deployment", e.Kind)
+	require.Equal(t, "default", e.Namespace)
+	require.Equal(t, "web", e.Name)
+	require.Equal(t, "1234", e.UID)
+	require.Empty(t, e.Error)
+
+	e = Event{}
+	require.NoError(t, dec.Decode(&e))
+	require.Equal(t, ActionGc, e.Action)
+	require.True(t, e.DryRun)
+	require.Equal(t, "forbidden", e.Error)
+
+	require.False(t, dec.More())
+}
+
+func TestEventWriter_nil(t *testing.T) {
+	var w *EventWriter
+	w.emit(ActionDelete, &unstructured.Unstructured{}, "", time.Now(), false, nil)
+}
@@ -0,0 +1,518 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksonnet/ksonnet/client"
+	"github.com/ksonnet/ksonnet/pkg/util/table"
+	"github.com/ksonnet/ksonnet/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// HistoryStorageSecret stores release history in Secrets.
+	HistoryStorageSecret = "secret"
+	// HistoryStorageConfigMap stores release history in ConfigMaps.
+	HistoryStorageConfigMap = "configmap"
+	// HistoryStorageNone disables release history.
+	HistoryStorageNone = "none"
+
+	// DefaultHistoryMax is the number of revisions kept in the release
+	// history of an environment if no maximum was given.
+	DefaultHistoryMax = 10
+
+	// LabelOwner identifies objects that are managed by ksonnet itself,
+	// rather than applied from components.
+	LabelOwner = "kubecfg.ksonnet.io/owner"
+	// LabelReleaseRevision is the revision of a stored release.
+	LabelReleaseRevision = "kubecfg.ksonnet.io/release-revision"
+
+	ownerRelease   = "release"
+	releaseDataKey = "release"
+
+	// maxReleaseSize is the most data a Secret or ConfigMap can hold.
+	maxReleaseSize = 1024 * 1024
+	// releaseCreateAttempts is how often storing a release is attempted when
+	// other releases of the environment are stored at the same time.
+	releaseCreateAttempts = 5
+)
+
+// Release is a set of objects that was applied to an environment.
+type Release struct {
+	App         string                       `json:"app"`
+	Env         string                       `json:"env"`
+	Revision    int                          `json:"revision"`
+	Timestamp   time.Time                    `json:"timestamp"`
+	GitCommit   string                       `json:"gitCommit,omitempty"`
+	User        string                       `json:"user,omitempty"`
+	Description string                       `json:"description,omitempty"`
+	Components  []string                     `json:"components,omitempty"`
+	Objects     []*unstructured.Unstructured `json:"objects"`
+	// RedactedSecrets are the Secrets of Objects whose values weren't
+	// stored.
+	RedactedSecrets []string `json:"redactedSecrets,omitempty"`
+}
+
+// redactSecrets removes the values of the Secrets of r. Anyone who can read
+// ConfigMaps could read them otherwise, since the encoding of a release
+// doesn't protect them.
+func (r *Release) redactSecrets() {
+	for i, obj := range r.Objects {
+		if !isSecret(obj) {
+			continue
+		}
+
+		o := obj.DeepCopy()
+		delete(o.Object, "data")
+		delete(o.Object, "stringData")
+		r.Objects[i] = o
+		r.RedactedSecrets = append(r.RedactedSecrets, utils.FqName(o))
+	}
+}
+
+// releaseStore reads and writes the releases of an environment.
+type releaseStore struct {
+	client dynamic.ResourceInterface
+	kind   string
+	app    string
+	env    string
+}
+
+func newReleaseStore(pool dynamic.ClientPool, storage, namespace, app, env string) (*releaseStore, error) {
+	var resource metav1.APIResource
+	switch storage {
+	case HistoryStorageSecret, "":
+		resource = metav1.APIResource{Name: "secrets", Namespaced: true, Kind: "Secret"}
+	case HistoryStorageConfigMap:
+		resource = metav1.APIResource{Name: "configmaps", Namespaced: true, Kind: "ConfigMap"}
+	default:
+		return nil, errors.Errorf("unknown history storage %q", storage)
+	}
+
+	c, err := pool.ClientForGroupVersionKind(schema.GroupVersionKind{Version: "v1", Kind: resource.Kind})
+	if err != nil {
+		return nil, err
+	}
+
+	return &releaseStore{
+		client: c.Resource(&resource, namespace),
+		kind:   resource.Kind,
+		app:    app,
+		env:    env,
+	}, nil
+}
+
+func (s *releaseStore) selector() string {
+	return fmt.Sprintf("%s=%s,%s=%s,%s=%s",
+		LabelOwner, ownerRelease,
+		LabelApp, utils.LabelValue(s.app),
+		LabelEnvironment, utils.LabelValue(s.env))
+}
+
+// List returns the stored releases ordered by revision.
+func (s *releaseStore) List() ([]*Release, error) {
+	list, err := s.client.List(metav1.ListOptions{LabelSelector: s.selector()})
+	if err != nil {
+		return nil, errors.Wrap(err, "list releases")
+	}
+
+	var releases []*Release
+	err = meta.EachListItem(list, func(o runtime.Object) error {
+		u, ok := o.(*unstructured.Unstructured)
+		if !ok {
+			return errors.Errorf("unexpected object type %T", o)
+		}
+
+		r, err := decodeRelease(utils.NestedString(u.Object, "data", releaseDataKey))
+		if err != nil {
+			return errors.Wrapf(err, "decode release %s", u.GetName())
+		}
+
+		releases = append(releases, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Revision < releases[j].Revision
+	})
+
+	return releases, nil
+}
+
+// Create stores r as the next revision. Releases stored in ConfigMaps don't
+// contain the values of Secrets. If another release is stored with the same
+// revision at the same time, r is stored as the revision after it.
+func (s *releaseStore) Create(r *Release) error {
+	if s.kind == "ConfigMap" {
+		r.redactSecrets()
+	}
+
+	for attempt := 1; ; attempt++ {
+		releases, err := s.List()
+		if err != nil {
+			return err
+		}
+
+		r.Revision = 1
+		if len(releases) > 0 {
+			r.Revision = releases[len(releases)-1].Revision + 1
+		}
+
+		obj, err := s.releaseObject(r)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.client.Create(obj)
+		if kerrors.IsAlreadyExists(err) && attempt < releaseCreateAttempts {
+			log.Debugf("Release %d was stored concurrently, trying the next revision", r.Revision)
+			continue
+		}
+		return errors.Wrapf(err, "store release %d", r.Revision)
+	}
+}
+
+// releaseObject returns the Secret or ConfigMap that stores r. It fails if r
+// is larger than such an object can be.
+func (s *releaseStore) releaseObject(r *Release) (*unstructured.Unstructured, error) {
+	data, err := encodeRelease(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// The API server stores the decoded values of Secrets.
+	size := len(data)
+	if s.kind == "Secret" {
+		size = base64.StdEncoding.DecodedLen(len(data))
+	}
+	if size > maxReleaseSize {
+		return nil, errors.Errorf("release %d of environment %q is %d bytes, which is more than the %d bytes a %s can hold; disable the release history of the environment with --history-storage %s",
+			r.Revision, s.env, size, maxReleaseSize, s.kind, HistoryStorageNone)
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       s.kind,
+			"metadata": map[string]interface{}{
+				"name": releaseName(s.app, s.env, r.Revision),
+				"labels": map[string]interface{}{
+					LabelOwner:           ownerRelease,
+					LabelApp:             utils.LabelValue(s.app),
+					LabelEnvironment:     utils.LabelValue(s.env),
+					LabelReleaseRevision: strconv.Itoa(r.Revision),
+				},
+			},
+			"data": map[string]interface{}{
+				releaseDataKey: data,
+			},
+		},
+	}, nil
+}
+
+// Prune deletes the oldest releases, so at most max are kept. If max is
+// zero, every release is kept.
+func (s *releaseStore) Prune(max int) error {
+	if max <= 0 {
+		return nil
+	}
+
+	releases, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	for len(releases) > max {
+		name := releaseName(s.app, s.env, releases[0].Revision)
+		if err := s.client.Delete(name, &metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+			return errors.Wrapf(err, "delete release %d", releases[0].Revision)
+		}
+		releases = releases[1:]
+	}
+
+	return nil
+}
+
+var reInvalidNameChars = regexp.MustCompile(`[^a-z0-9-]`)
+
+func releaseName(app, env string, revision int) string {
+	segment := func(s string) string {
+		s = strings.ToLower(utils.LabelValue(s))
+		return strings.Trim(reInvalidNameChars.ReplaceAllString(s, "-"), "-")
+	}
+
+	return fmt.Sprintf("ks.%s.%s.v%d", segment(app), segment(env), revision)
+}
+
+// encodeRelease serializes r as base64 encoded, gzipped JSON. This is the
+// format Secrets expect, and it keeps ConfigMaps free of binary data.
+func encodeRelease(r *Release) (string, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(w).Encode(r); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decodeRelease(data string) (*Release, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var release Release
+	if err := json.Unmarshal(raw, &release); err != nil {
+		return nil, err
+	}
+
+	return &release, nil
+}
+
+// newRelease creates a release for objs. The objects are copied without the
+// last applied configuration, which would double their size.
+func newRelease(app, env, wd string, components []string, description string, objs []*unstructured.Unstructured) *Release {
+	r := &Release{
+		App:         app,
+		Env:         env,
+		Timestamp:   time.Now().UTC(),
+		GitCommit:   gitCommit(wd),
+		User:        currentUser(),
+		Description: description,
+		Components:  components,
+	}
+
+	for _, obj := range objs {
+		o := obj.DeepCopy()
+		annotations := o.GetAnnotations()
+		if _, ok := annotations[AnnotationLastApplied]; ok {
+			delete(annotations, AnnotationLastApplied)
+			o.SetAnnotations(annotations)
+		}
+		r.Objects = append(r.Objects, o)
+	}
+
+	return r
+}
+
+// gitCommit returns the commit checked out in dir. A "-dirty" suffix is added
+// if there are uncommitted changes. It returns an empty string if dir isn't
+// part of a git repository.
+func gitCommit(dir string) string {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		log.Debugf("Unable to determine git commit: %s", err)
+		return ""
+	}
+	commit := strings.TrimSpace(string(out))
+
+	cmd = exec.Command("git", "status", "--porcelain")
+	cmd.Dir = dir
+	if out, err = cmd.Output(); err == nil && len(bytes.TrimSpace(out)) > 0 {
+		commit += "-dirty"
+	}
+
+	return commit
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// HistoryCmd represents the history subcommand
+type HistoryCmd struct {
+	ClientConfig   *client.Config
+	App            string
+	Env            string
+	HistoryStorage string
+}
+
+// Run prints the releases of the environment.
+func (c HistoryCmd) Run(out io.Writer) error {
+	clientPool, _, namespace, err := c.ClientConfig.RestClient(&c.Env)
+	if err != nil {
+		return err
+	}
+
+	store, err := newReleaseStore(clientPool, c.HistoryStorage, namespace, c.App, c.Env)
+	if err != nil {
+		return err
+	}
+
+	releases, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	return printReleases(out, releases)
+}
+
+func printReleases(out io.Writer, releases []*Release) error {
+	t := table.New(out)
+	t.SetHeader([]string{"revision", "applied", "git commit", "user", "objects", "description"})
+
+	for _, r := range releases {
+		description := r.Description
+		if len(r.Components) > 0 {
+			description = strings.TrimSpace(fmt.Sprintf("%s (components: %s)", description, strings.Join(r.Components, ", ")))
+		}
+
+		t.Append([]string{
+			strconv.Itoa(r.Revision),
+			r.Timestamp.Format(time.RFC3339),
+			r.GitCommit,
+			r.User,
+			strconv.Itoa(len(r.Objects)),
+			description,
+		})
+	}
+
+	return t.Render()
+}
+
+// RollbackCmd represents the rollback subcommand
+type RollbackCmd struct {
+	ClientConfig   *client.Config
+	App            string
+	Env            string
+	HistoryStorage string
+	// HistoryMax is the number of revisions kept in the release history.
+	// If it is zero, every revision is kept.
+	HistoryMax int
+	DryRun     bool
+	Retry      RetryOptions
+	// Revision is the revision to roll back to. If it is zero, the revision
+	// before the latest one is used.
+	Revision int
+}
+
+// Run re-applies a stored release and garbage collects the objects of the
+// environment that aren't part of it.
+func (c RollbackCmd) Run(wd string) error {
+	clientPool, _, namespace, err := c.ClientConfig.RestClient(&c.Env)
+	if err != nil {
+		return err
+	}
+
+	store, err := newReleaseStore(clientPool, c.HistoryStorage, namespace, c.App, c.Env)
+	if err != nil {
+		return err
+	}
+
+	releases, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	release, err := findRelease(releases, c.Revision)
+	if err != nil {
+		return err
+	}
+
+	if len(release.RedactedSecrets) > 0 {
+		return errors.Errorf("revision %d doesn't contain the values of the Secrets %s, since it was stored in a ConfigMap; apply the components with `ks apply` instead",
+			release.Revision, strings.Join(release.RedactedSecrets, ", "))
+	}
+
+	log.Infof("Rolling back environment %q to revision %d", c.Env, release.Revision)
+
+	apply := ApplyCmd{
+		ClientConfig:   c.ClientConfig,
+		App:            c.App,
+		Env:            c.Env,
+		Create:         true,
+		DryRun:         c.DryRun,
+		HistoryStorage: c.HistoryStorage,
+		HistoryMax:     c.HistoryMax,
+		Components:     release.Components,
+		Description:    fmt.Sprintf("Rollback to %d", release.Revision),
+		Parallelism:    DefaultParallelism,
+		Retry:          c.Retry,
+	}
+
+	if len(release.Components) == 0 {
+		apply.pruneEnvironment = true
+	} else {
+		log.Warnf("Revision %d only applied components %s; skipping garbage collection",
+			release.Revision, strings.Join(release.Components, ", "))
+	}
+
+	return apply.Run(release.Objects, wd)
+}
+
+func findRelease(releases []*Release, revision int) (*Release, error) {
+	if revision == 0 {
+		if len(releases) < 2 {
+			return nil, errors.New("no previous revision to roll back to")
+		}
+		return releases[len(releases)-2], nil
+	}
+
+	for _, r := range releases {
+		if r.Revision == revision {
+			return r, nil
+		}
+	}
+
+	return nil, errors.Errorf("revision %d does not exist", revision)
+}
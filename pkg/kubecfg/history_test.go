@@ -0,0 +1,342 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// fakeResourceClient is an in-memory dynamic.ResourceInterface.
+type fakeResourceClient struct {
+	objects map[string]*unstructured.Unstructured
+}
+
+func newFakeResourceClient() *fakeResourceClient {
+	return &fakeResourceClient{objects: map[string]*unstructured.Unstructured{}}
+}
+
+func (c *fakeResourceClient) List(opts metav1.ListOptions) (runtime.Object, error) {
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &unstructured.UnstructuredList{}
+	for _, o := range c.objects {
+		if selector.Matches(labels.Set(o.GetLabels())) {
+			list.Items = append(list.Items, *o)
+		}
+	}
+	return list, nil
+}
+
+func (c *fakeResourceClient) Get(name string, opts metav1.GetOptions) (*unstructured.Unstructured, error) {
+	o, ok := c.objects[name]
+	if !ok {
+		return nil, errors.NewNotFound(schema.GroupResource{}, name)
+	}
+	return o, nil
+}
+
+func (c *fakeResourceClient) Delete(name string, opts *metav1.DeleteOptions) error {
+	delete(c.objects, name)
+	return nil
+}
+
+func (c *fakeResourceClient) DeleteCollection(deleteOptions *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	return nil
+}
+
+func (c *fakeResourceClient) Create(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if _, ok := c.objects[obj.GetName()]; ok {
+		return nil, errors.NewAlreadyExists(schema.GroupResource{}, obj.GetName())
+	}
+	c.objects[obj.GetName()] = obj
+	return obj, nil
+}
+
+func (c *fakeResourceClient) Update(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	c.objects[obj.GetName()] = obj
+	return obj, nil
+}
+
+func (c *fakeResourceClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return watch.NewEmptyWatch(), nil
+}
+
+func (c *fakeResourceClient) Patch(name string, pt types.PatchType, data []byte) (*unstructured.Unstructured, error) {
+	return c.Get(name, metav1.GetOptions{})
+}
+
+func TestReleaseStore(t *testing.T) {
+	client := newFakeResourceClient()
+	store := &releaseStore{client: client, kind: "Secret", app: "guestbook", env: "us-west/dev"}
+	other := &releaseStore{client: client, kind: "Secret", app: "guestbook", env: "prod"}
+
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name": "config",
+				"annotations": map[string]interface{}{
+					AnnotationLastApplied: "{}",
+				},
+			},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		r := newRelease(store.app, store.env, "/", nil, "", []*unstructured.Unstructured{obj})
+		require.NoError(t, store.Create(r))
+		require.Equal(t, i+1, r.Revision)
+	}
+	require.NoError(t, other.Create(newRelease(other.app, other.env, "/", nil, "", nil)))
+
+	releases, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, releases, 2)
+	require.Equal(t, 1, releases[0].Revision)
+	require.Equal(t, 2, releases[1].Revision)
+	require.Equal(t, "us-west/dev", releases[1].Env)
+	require.Len(t, releases[1].Objects, 1)
+
+	stored := releases[1].Objects[0]
+	require.Equal(t, "config", stored.GetName())
+	_, ok := stored.GetAnnotations()[AnnotationLastApplied]
+	require.False(t, ok, "last applied configuration should not be stored")
+
+	// The original object must not be modified.
+	require.Contains(t, obj.GetAnnotations(), AnnotationLastApplied)
+
+	secret, err := client.Get(releaseName(store.app, store.env, 2), metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "2", secret.GetLabels()[LabelReleaseRevision])
+}
+
+func TestReleaseStore_configMapRedactsSecrets(t *testing.T) {
+	secret := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"name":      "credentials",
+				"namespace": "default",
+			},
+			"data":       map[string]interface{}{"password": "c2VjcmV0"},
+			"stringData": map[string]interface{}{"token": "secret"},
+		},
+	}
+
+	for _, kind := range []string{"Secret", "ConfigMap"} {
+		client := newFakeResourceClient()
+		store := &releaseStore{client: client, kind: kind, app: "guestbook", env: "dev"}
+
+		require.NoError(t, store.Create(newRelease(store.app, store.env, "/", nil, "", []*unstructured.Unstructured{secret})))
+
+		releases, err := store.List()
+		require.NoError(t, err)
+		require.Len(t, releases, 1)
+
+		stored := releases[0].Objects[0]
+		if kind == "Secret" {
+			require.Empty(t, releases[0].RedactedSecrets)
+			require.Contains(t, stored.Object, "data")
+			require.Contains(t, stored.Object, "stringData")
+			continue
+		}
+
+		require.Equal(t, []string{"default.credentials"}, releases[0].RedactedSecrets)
+		require.NotContains(t, stored.Object, "data")
+		require.NotContains(t, stored.Object, "stringData")
+	}
+
+	// The applied object itself is left alone.
+	require.Contains(t, secret.Object, "data")
+}
+
+func TestReleaseStore_Prune(t *testing.T) {
+	client := newFakeResourceClient()
+	store := &releaseStore{client: client, kind: "Secret", app: "guestbook", env: "dev"}
+	other := &releaseStore{client: client, kind: "Secret", app: "guestbook", env: "prod"}
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, store.Create(newRelease(store.app, store.env, "/", nil, "", nil)))
+	}
+	require.NoError(t, other.Create(newRelease(other.app, other.env, "/", nil, "", nil)))
+
+	require.NoError(t, store.Prune(0))
+	releases, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, releases, 4)
+
+	require.NoError(t, store.Prune(2))
+	releases, err = store.List()
+	require.NoError(t, err)
+	require.Len(t, releases, 2)
+	require.Equal(t, 3, releases[0].Revision)
+	require.Equal(t, 4, releases[1].Revision)
+
+	// Revisions continue after the pruned ones.
+	require.NoError(t, store.Create(newRelease(store.app, store.env, "/", nil, "", nil)))
+	releases, err = store.List()
+	require.NoError(t, err)
+	require.Equal(t, 5, releases[len(releases)-1].Revision)
+
+	// Other environments are left alone.
+	releases, err = other.List()
+	require.NoError(t, err)
+	require.Len(t, releases, 1)
+}
+
+// racingClient stores a release of another apply right before the first
+// release is created.
+type racingClient struct {
+	*fakeResourceClient
+	other *releaseStore
+}
+
+func (c *racingClient) Create(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if other := c.other; other != nil {
+		c.other = nil
+		if err := other.Create(newRelease(other.app, other.env, "/", nil, "other", nil)); err != nil {
+			return nil, err
+		}
+	}
+	return c.fakeResourceClient.Create(obj)
+}
+
+func TestReleaseStore_concurrentCreate(t *testing.T) {
+	fake := newFakeResourceClient()
+	client := &racingClient{
+		fakeResourceClient: fake,
+		other:              &releaseStore{client: fake, kind: "Secret", app: "guestbook", env: "dev"},
+	}
+	store := &releaseStore{client: client, kind: "Secret", app: "guestbook", env: "dev"}
+
+	r := newRelease(store.app, store.env, "/", nil, "mine", nil)
+	require.NoError(t, store.Create(r))
+	require.Equal(t, 2, r.Revision)
+
+	releases, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, releases, 2)
+	require.Equal(t, "other", releases[0].Description)
+	require.Equal(t, "mine", releases[1].Description)
+}
+
+func TestReleaseStore_tooLarge(t *testing.T) {
+	// Random data doesn't compress.
+	data := make([]byte, maxReleaseSize)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "large"},
+			"data":       map[string]interface{}{"blob": base64.StdEncoding.EncodeToString(data)},
+		},
+	}
+
+	client := newFakeResourceClient()
+	store := &releaseStore{client: client, kind: "Secret", app: "guestbook", env: "dev"}
+	err = store.Create(newRelease(store.app, store.env, "/", nil, "", []*unstructured.Unstructured{obj}))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "more than the 1048576 bytes a Secret can hold")
+	require.Empty(t, client.objects)
+}
+
+func TestReleaseName(t *testing.T) {
+	require.Equal(t, "ks.guestbook.dev.v3", releaseName("guestbook", "dev", 3))
+
+	name := releaseName("Guest_Book", "us-west/dev", 1)
+	require.Regexp(t, `^ks\.[a-z0-9-]+\.[a-z0-9-]+\.v1$`, name)
+}
+
+func TestFindRelease(t *testing.T) {
+	releases := []*Release{{Revision: 1}, {Revision: 2}, {Revision: 3}}
+
+	r, err := findRelease(releases, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, r.Revision)
+
+	r, err = findRelease(releases, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, r.Revision)
+
+	_, err = findRelease(releases, 4)
+	require.Error(t, err)
+
+	_, err = findRelease(releases[:1], 0)
+	require.Error(t, err)
+}
+
+func TestPrintReleases(t *testing.T) {
+	releases := []*Release{
+		{Revision: 1, GitCommit: "abc", User: "alice"},
+		{Revision: 2, GitCommit: "def", User: "bob", Description: "Rollback to 1", Components: []string{"redis"}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, printReleases(&buf, releases))
+	require.Contains(t, buf.String(), "REVISION")
+	require.Contains(t, buf.String(), "Rollback to 1 (components: redis)")
+}
+
+func TestEligibleForEnvGc(t *testing.T) {
+	o := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+		},
+	}
+
+	if eligibleForEnvGc(o, "app", "dev") {
+		t.Errorf("%v should not be eligible (no labels)", o)
+	}
+
+	ApplyCmd{App: "app", Env: "dev"}.setOwnership(o)
+	if !eligibleForEnvGc(o, "app", "dev") {
+		t.Errorf("%v should be eligible", o)
+	}
+	if eligibleForEnvGc(o, "app", "prod") {
+		t.Errorf("%v should not be eligible (other environment)", o)
+	}
+
+	o.SetLabels(map[string]string{
+		LabelApp:         "app",
+		LabelEnvironment: "dev",
+		LabelOwner:       ownerRelease,
+	})
+	if eligibleForEnvGc(o, "app", "dev") {
+		t.Errorf("%v should not be eligible (release history)", o)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+
+	"github.com/ksonnet/ksonnet/pkg/util/k8s"
+)
+
+// keyLists returns a copy of v in which the lists that have a merge key in
+// the schema, e.g. the containers of a pod, are replaced by objects that map
+// the key of each element to the element. Diffing the copies matches list
+// elements by key instead of by position, so reordered elements are not
+// reported and a changed element is reported on its own. Lists whose
+// elements don't all have a unique key are copied as they are.
+func keyLists(v interface{}, t *k8s.SchemaType) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			result[key] = keyLists(value, t.Field(key))
+		}
+		return result
+	case []interface{}:
+		items := t.Items()
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = keyLists(item, items)
+		}
+
+		if keyed, ok := keyList(result, t.MergeKey()); ok {
+			return keyed
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+// keyListPair is keyLists for two versions a and b of a value, which are
+// compared with each other. A list is only keyed if the list in both a and b
+// can be keyed, so the keyed versions have the same shape. If a value is
+// missing from one version, it is keyed on its own.
+func keyListPair(a, b interface{}, t *k8s.SchemaType) (interface{}, interface{}) {
+	switch {
+	case a == nil:
+		return nil, keyLists(b, t)
+	case b == nil:
+		return keyLists(a, t), nil
+	}
+
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			return keyLists(a, t), keyLists(b, t)
+		}
+		resultA := make(map[string]interface{}, len(av))
+		resultB := make(map[string]interface{}, len(bv))
+		for key, value := range av {
+			other, inB := bv[key]
+			resultA[key], other = keyListPair(value, other, t.Field(key))
+			if inB {
+				resultB[key] = other
+			}
+		}
+		for key, value := range bv {
+			if _, ok := av[key]; !ok {
+				resultB[key] = keyLists(value, t.Field(key))
+			}
+		}
+		return resultA, resultB
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			return keyLists(a, t), keyLists(b, t)
+		}
+		items := t.Items()
+		mergeKey := t.MergeKey()
+
+		keyedA, okA := keyList(av, mergeKey)
+		keyedB, okB := keyList(bv, mergeKey)
+		if !okA || !okB {
+			// Elements are compared by position.
+			resultA := make([]interface{}, len(av))
+			resultB := make([]interface{}, len(bv))
+			for i := 0; i < len(av) || i < len(bv); i++ {
+				var itemA, itemB interface{}
+				if i < len(av) {
+					itemA = av[i]
+				}
+				if i < len(bv) {
+					itemB = bv[i]
+				}
+				itemA, itemB = keyListPair(itemA, itemB, items)
+				if i < len(av) {
+					resultA[i] = itemA
+				}
+				if i < len(bv) {
+					resultB[i] = itemB
+				}
+			}
+			return resultA, resultB
+		}
+
+		resultA := make(map[string]interface{}, len(keyedA))
+		resultB := make(map[string]interface{}, len(keyedB))
+		for k, item := range keyedA {
+			other, inB := keyedB[k]
+			resultA[k], other = keyListPair(item, other, items)
+			if inB {
+				resultB[k] = other
+			}
+		}
+		for k, item := range keyedB {
+			if _, ok := keyedA[k]; !ok {
+				resultB[k] = keyLists(item, items)
+			}
+		}
+		return resultA, resultB
+	default:
+		return a, b
+	}
+}
+
+// keyList maps the value of mergeKey in each element of list to the element.
+func keyList(list []interface{}, mergeKey string) (map[string]interface{}, bool) {
+	if mergeKey == "" {
+		return nil, false
+	}
+
+	result := make(map[string]interface{}, len(list))
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		key, ok := m[mergeKey]
+		if !ok || key == nil {
+			return nil, false
+		}
+
+		k := fmt.Sprint(key)
+		if _, ok := result[k]; ok {
+			return nil, false
+		}
+		result[k] = item
+	}
+	return result, true
+}
+
+// listKeys returns the keys of the elements of list, in order. list must
+// be keyed by mergeKey, see keyList.
+func listKeys(list []interface{}, mergeKey string) []string {
+	keys := make([]string, 0, len(list))
+	for _, item := range list {
+		keys = append(keys, fmt.Sprint(item.(map[string]interface{})[mergeKey]))
+	}
+	return keys
+}
+
+// alignLists returns a copy of b in which the elements of lists that have a
+// merge key are in the order of the same list in a. Elements that are only
+// in b come last, in their original order.
+func alignLists(a, b interface{}, t *k8s.SchemaType) interface{} {
+	switch bv := b.(type) {
+	case map[string]interface{}:
+		av, _ := a.(map[string]interface{})
+		result := make(map[string]interface{}, len(bv))
+		for key, value := range bv {
+			result[key] = alignLists(av[key], value, t.Field(key))
+		}
+		return result
+	case []interface{}:
+		av, _ := a.([]interface{})
+		items := t.Items()
+		mergeKey := t.MergeKey()
+
+		keyedA, okA := keyList(av, mergeKey)
+		keyedB, okB := keyList(bv, mergeKey)
+		if !okA || !okB {
+			result := make([]interface{}, len(bv))
+			for i, item := range bv {
+				var aItem interface{}
+				if i < len(av) {
+					aItem = av[i]
+				}
+				result[i] = alignLists(aItem, item, items)
+			}
+			return result
+		}
+
+		result := make([]interface{}, 0, len(bv))
+		for _, k := range listKeys(av, mergeKey) {
+			if item, ok := keyedB[k]; ok {
+				result = append(result, alignLists(keyedA[k], item, items))
+			}
+		}
+		for _, k := range listKeys(bv, mergeKey) {
+			if _, ok := keyedA[k]; !ok {
+				result = append(result, alignLists(nil, keyedB[k], items))
+			}
+		}
+		return result
+	default:
+		return b
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/ksonnet/ksonnet/pkg/util/k8s"
+)
+
+func TestKeyLists(t *testing.T) {
+	schema, err := k8s.ParseSchema([]byte(normalizeSchema))
+	require.NoError(t, err)
+
+	obj := mustUnstructured(t, `{
+  "apiVersion": "apps/v1beta1",
+  "kind": "Deployment",
+  "spec": {
+    "template": {
+      "spec": {
+        "containers": [
+          {"name": "web", "image": "nginx"},
+          {"name": "proxy", "image": "envoy"}
+        ]
+      }
+    }
+  }
+}`)
+
+	expected := map[string]interface{}{
+		"apiVersion": "apps/v1beta1",
+		"kind":       "Deployment",
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": map[string]interface{}{
+						"web":   map[string]interface{}{"name": "web", "image": "nginx"},
+						"proxy": map[string]interface{}{"name": "proxy", "image": "envoy"},
+					},
+				},
+			},
+		},
+	}
+
+	typ := schema.TypeFor(obj.GroupVersionKind())
+	require.Equal(t, expected, keyLists(obj.Object, typ))
+
+	// The original object is left untouched.
+	podSpec := obj.Object["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+	require.IsType(t, []interface{}{}, podSpec["containers"])
+
+	// Lists with duplicate keys are kept.
+	podSpec["containers"] = []interface{}{
+		map[string]interface{}{"name": "web", "image": "nginx"},
+		map[string]interface{}{"name": "web", "image": "envoy"},
+	}
+	keyed := keyLists(obj.Object, typ).(map[string]interface{})
+	keyedPodSpec := keyed["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+	require.Equal(t, podSpec["containers"], keyedPodSpec["containers"])
+}
+
+func TestDiffMatchesListsByKey(t *testing.T) {
+	schema, err := k8s.ParseSchema([]byte(normalizeSchema))
+	require.NoError(t, err)
+	n, err := NewNormalizer(schema, nil)
+	require.NoError(t, err)
+
+	deployment := func(containers string) *unstructured.Unstructured {
+		return mustUnstructured(t, `{
+  "apiVersion": "apps/v1beta1",
+  "kind": "Deployment",
+  "metadata": {"name": "web"},
+  "spec": {"template": {"spec": {"containers": `+containers+`}}}
+}`)
+	}
+
+	cases := []struct {
+		name     string
+		local    string
+		remote   string
+		modified bool
+		changed  []string
+	}{
+		{
+			name:   "reordered",
+			local:  `[{"name": "web", "image": "nginx"}, {"name": "proxy", "image": "envoy"}]`,
+			remote: `[{"name": "proxy", "image": "envoy"}, {"name": "web", "image": "nginx"}]`,
+		},
+		{
+			name:     "changed",
+			local:    `[{"name": "web", "image": "nginx:1.13"}, {"name": "proxy", "image": "envoy"}]`,
+			remote:   `[{"name": "proxy", "image": "envoy"}, {"name": "web", "image": "nginx:1.12"}]`,
+			modified: true,
+			changed:  []string{`-            "image": "nginx:1.12",`, `+            "image": "nginx:1.13",`},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := DiffLocalCmd{
+				Diff: Diff{DiffStrategy: DiffStrategyNormalized, Normalizer: n},
+				Env1: &LocalEnv{Name: "local", APIObjects: []*unstructured.Unstructured{deployment(tc.local)}},
+				Env2: &LocalEnv{Name: "remote", APIObjects: []*unstructured.Unstructured{deployment(tc.remote)}},
+			}
+
+			var buf bytes.Buffer
+			err := c.Run(&buf)
+			if !tc.modified {
+				require.NoError(t, err)
+				return
+			}
+
+			require.Equal(t, ErrDiffFound, err)
+
+			// Only the lines of the changed element are marked.
+			var changed []string
+			for _, line := range strings.Split(buf.String(), "\n") {
+				if strings.HasPrefix(line, "-  ") || strings.HasPrefix(line, "+  ") {
+					changed = append(changed, line)
+				}
+			}
+			require.Equal(t, tc.changed, changed)
+		})
+	}
+}
+
+func TestDiffSubsetUnkeyedLive(t *testing.T) {
+	schema, err := k8s.ParseSchema([]byte(normalizeSchema))
+	require.NoError(t, err)
+	n, err := NewNormalizer(schema, nil)
+	require.NoError(t, err)
+
+	deployment := func(containers string) *unstructured.Unstructured {
+		return mustUnstructured(t, `{
+  "apiVersion": "apps/v1beta1",
+  "kind": "Deployment",
+  "metadata": {"name": "web"},
+  "spec": {"template": {"spec": {"containers": `+containers+`}}}
+}`)
+	}
+
+	// The live containers share a name, so they can't be keyed and are
+	// compared by position.
+	c := DiffLocalCmd{
+		Diff: Diff{DiffStrategy: DiffStrategySubset, Normalizer: n},
+		Env1: &LocalEnv{Name: "local", APIObjects: []*unstructured.Unstructured{
+			deployment(`[{"name": "web", "image": "nginx"}]`),
+		}},
+		Env2: &LocalEnv{Name: "remote", APIObjects: []*unstructured.Unstructured{
+			deployment(`[{"name": "web", "image": "nginx", "imagePullPolicy": "Always"}, {"name": "web", "image": "envoy"}]`),
+		}},
+	}
+
+	var buf bytes.Buffer
+	err = c.Run(&buf)
+	require.Equal(t, ErrDiffFound, err)
+	require.Contains(t, buf.String(), `"image": "envoy"`)
+	require.NotContains(t, buf.String(), "imagePullPolicy")
+}
@@ -0,0 +1,288 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ksonnet/ksonnet/pkg/util/k8s"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// AllKinds is the kind of ignore rules that apply to every kind.
+const AllKinds = "*"
+
+var (
+	// serverMetadataFields are set by the API server.
+	serverMetadataFields = []string{
+		"creationTimestamp",
+		"deletionGracePeriodSeconds",
+		"deletionTimestamp",
+		"generation",
+		"initializers",
+		"resourceVersion",
+		"selfLink",
+		"uid",
+	}
+
+	// managedAnnotations are added by the API server, controllers or
+	// `ks apply` itself.
+	managedAnnotations = []string{
+		AnnotationLastApplied,
+		AnnotationGcTag,
+		"kubectl.kubernetes.io/last-applied-configuration",
+		"deployment.kubernetes.io/revision",
+	}
+
+	// managedLabels are added by `ks apply`.
+	managedLabels = []string{
+		LabelApp,
+		LabelEnvironment,
+		LabelGcTag,
+	}
+
+	// knownDefaults are defaults of the API server that the OpenAPI schema
+	// only mentions in descriptions. They are keyed by the name of the type,
+	// without its package, and then by field.
+	knownDefaults = map[string]map[string]interface{}{
+		"PodSpec": {
+			"dnsPolicy":                     "ClusterFirst",
+			"restartPolicy":                 "Always",
+			"schedulerName":                 "default-scheduler",
+			"terminationGracePeriodSeconds": 30,
+		},
+		"Container": {
+			"terminationMessagePath":   "/dev/termination-log",
+			"terminationMessagePolicy": "File",
+		},
+		"ContainerPort":         {"protocol": "TCP"},
+		"ServicePort":           {"protocol": "TCP"},
+		"ServiceSpec":           {"sessionAffinity": "None", "type": "ClusterIP"},
+		"ConfigMapVolumeSource": {"defaultMode": 420},
+		"SecretVolumeSource":    {"defaultMode": 420},
+		"Probe": {
+			"failureThreshold": 3,
+			"periodSeconds":    10,
+			"successThreshold": 1,
+			"timeoutSeconds":   1,
+		},
+		"HTTPGetAction":       {"scheme": "HTTP"},
+		"ObjectFieldSelector": {"apiVersion": "v1"},
+		"StatefulSetSpec":     {"podManagementPolicy": "OrderedReady"},
+	}
+)
+
+// Normalizer removes the fields of objects that make diffs noisy: metadata
+// and status managed by the server, fields that have their default value,
+// and fields that are ignored by the user.
+type Normalizer struct {
+	// schema is used to find defaulted fields. It may be nil.
+	schema *k8s.Schema
+	ignore map[string][][]string
+}
+
+// NewNormalizer creates a Normalizer. ignore maps a kind, or AllKinds, to
+// the paths of fields to ignore, e.g. `spec.replicas` or
+// `metadata.annotations[example.com/owner]`. Paths that cross a list apply
+// to every element of the list.
+func NewNormalizer(schema *k8s.Schema, ignore map[string][]string) (*Normalizer, error) {
+	n := &Normalizer{
+		schema: schema,
+		ignore: make(map[string][][]string),
+	}
+
+	for kind, paths := range ignore {
+		for _, p := range paths {
+			segments, err := parseFieldPath(p)
+			if err != nil {
+				return nil, err
+			}
+			n.ignore[kind] = append(n.ignore[kind], segments)
+		}
+	}
+
+	return n, nil
+}
+
+// parseFieldPath splits a dotted path into its segments. Segments in square
+// brackets may contain dots.
+func parseFieldPath(path string) ([]string, error) {
+	var segments []string
+	rest := path
+	for rest != "" {
+		var segment string
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid field path %q: missing ]", path)
+			}
+			segment, rest = rest[1:end], rest[end+1:]
+			rest = strings.TrimPrefix(rest, ".")
+		} else {
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			segment, rest = rest[:end], rest[end:]
+			rest = strings.TrimPrefix(rest, ".")
+		}
+
+		if segment == "" {
+			return nil, fmt.Errorf("invalid field path %q: empty segment", path)
+		}
+		segments = append(segments, segment)
+	}
+
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("invalid field path %q", path)
+	}
+	return segments, nil
+}
+
+// Normalize returns a normalized copy of obj. A nil Normalizer only removes
+// the metadata and status managed by the server.
+func (n *Normalizer) Normalize(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	o := obj.DeepCopy()
+
+	delete(o.Object, "status")
+
+	if metadata, ok := o.Object["metadata"].(map[string]interface{}); ok {
+		for _, field := range serverMetadataFields {
+			delete(metadata, field)
+		}
+		if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+			for _, a := range managedAnnotations {
+				delete(annotations, a)
+			}
+		}
+		if labels, ok := metadata["labels"].(map[string]interface{}); ok {
+			for _, l := range managedLabels {
+				delete(labels, l)
+			}
+		}
+	}
+
+	if n != nil {
+		for _, kind := range []string{AllKinds, o.GetKind()} {
+			for _, path := range n.ignore[kind] {
+				removePath(o.Object, path)
+			}
+		}
+
+		if t := n.typeFor(o); t != nil {
+			removeDefaults(o.Object, t)
+		}
+	}
+
+	removeEmpty(o.Object)
+	return o
+}
+
+// typeFor returns the schema type of obj, or nil if it is unknown.
+func (n *Normalizer) typeFor(obj *unstructured.Unstructured) *k8s.SchemaType {
+	if n == nil {
+		return nil
+	}
+	return n.schema.TypeFor(obj.GroupVersionKind())
+}
+
+// removePath deletes the field at path from v.
+func removePath(v interface{}, path []string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			delete(v, path[0])
+			return
+		}
+		if child, ok := v[path[0]]; ok {
+			removePath(child, path[1:])
+		}
+	case []interface{}:
+		for _, item := range v {
+			removePath(item, path)
+		}
+	}
+}
+
+// removeDefaults deletes the fields of v that have their default value.
+func removeDefaults(v interface{}, t *k8s.SchemaType) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		typeName := t.Name()
+		if i := strings.LastIndex(typeName, "."); i >= 0 {
+			typeName = typeName[i+1:]
+		}
+
+		for key, value := range v {
+			field := t.Field(key)
+
+			def, ok := field.Default()
+			if !ok {
+				def, ok = knownDefaults[typeName][key]
+			}
+			if ok && jsonEqual(value, def) {
+				delete(v, key)
+				continue
+			}
+
+			removeDefaults(value, field)
+		}
+	case []interface{}:
+		items := t.Items()
+		for _, item := range v {
+			removeDefaults(item, items)
+		}
+	}
+}
+
+// removeEmpty deletes null values, empty objects and empty lists from v.
+// The API server treats them like missing fields.
+func removeEmpty(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		for key, value := range v {
+			if removeEmpty(value) {
+				delete(v, key)
+			}
+		}
+		return len(v) == 0
+	case []interface{}:
+		for _, item := range v {
+			removeEmpty(item)
+		}
+		return len(v) == 0
+	}
+	return false
+}
+
+// jsonEqual reports whether a and b have the same JSON representation. This
+// treats numbers of different types as equal.
+func jsonEqual(a, b interface{}) bool {
+	aj, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bj, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aj, bj)
+}
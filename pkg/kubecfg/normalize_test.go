@@ -0,0 +1,188 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/ksonnet/ksonnet/pkg/util/k8s"
+)
+
+const normalizeSchema = `{
+  "definitions": {
+    "io.k8s.api.apps.v1beta1.Deployment": {
+      "properties": {
+        "spec": {"$ref": "#/definitions/io.k8s.api.apps.v1beta1.DeploymentSpec"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "apps", "version": "v1beta1", "kind": "Deployment"}]
+    },
+    "io.k8s.api.apps.v1beta1.DeploymentSpec": {
+      "properties": {
+        "replicas": {"type": "integer"},
+        "revisionHistoryLimit": {"type": "integer", "default": 2},
+        "template": {"$ref": "#/definitions/io.k8s.api.core.v1.PodTemplateSpec"}
+      }
+    },
+    "io.k8s.api.core.v1.PodTemplateSpec": {
+      "properties": {
+        "spec": {"$ref": "#/definitions/io.k8s.api.core.v1.PodSpec"}
+      }
+    },
+    "io.k8s.api.core.v1.PodSpec": {
+      "properties": {
+        "containers": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/io.k8s.api.core.v1.Container"},
+          "x-kubernetes-patch-merge-key": "name"
+        },
+        "dnsPolicy": {"type": "string"}
+      }
+    },
+    "io.k8s.api.core.v1.Container": {
+      "properties": {
+        "image": {"type": "string"},
+        "name": {"type": "string"},
+        "terminationMessagePath": {"type": "string"}
+      }
+    }
+  }
+}`
+
+func mustUnstructured(t *testing.T, s string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	require.NoError(t, json.Unmarshal([]byte(s), &obj.Object))
+	return obj
+}
+
+func TestNormalize(t *testing.T) {
+	schema, err := k8s.ParseSchema([]byte(normalizeSchema))
+	require.NoError(t, err)
+
+	live := mustUnstructured(t, `{
+  "apiVersion": "apps/v1beta1",
+  "kind": "Deployment",
+  "metadata": {
+    "name": "web",
+    "namespace": "default",
+    "uid": "1234",
+    "resourceVersion": "42",
+    "generation": 3,
+    "creationTimestamp": "2018-01-01T00:00:00Z",
+    "labels": {"app": "web", "kubecfg.ksonnet.io/environment": "dev"},
+    "annotations": {
+      "deployment.kubernetes.io/revision": "3",
+      "example.com/build-id": "abc"
+    }
+  },
+  "spec": {
+    "replicas": 3,
+    "revisionHistoryLimit": 2,
+    "template": {
+      "metadata": {"creationTimestamp": null},
+      "spec": {
+        "dnsPolicy": "ClusterFirst",
+        "containers": [{
+          "name": "web",
+          "image": "nginx",
+          "terminationMessagePath": "/dev/termination-log",
+          "resources": {}
+        }]
+      }
+    }
+  },
+  "status": {"replicas": 3}
+}`)
+
+	n, err := NewNormalizer(schema, map[string][]string{
+		"Deployment": {"spec.replicas"},
+		AllKinds:     {"metadata.annotations[example.com/build-id]"},
+	})
+	require.NoError(t, err)
+
+	expected := mustUnstructured(t, `{
+  "apiVersion": "apps/v1beta1",
+  "kind": "Deployment",
+  "metadata": {
+    "name": "web",
+    "namespace": "default",
+    "labels": {"app": "web"}
+  },
+  "spec": {
+    "template": {
+      "spec": {
+        "containers": [{"name": "web", "image": "nginx"}]
+      }
+    }
+  }
+}`)
+
+	got := n.Normalize(live)
+	require.Equal(t, expected.Object, got.Object)
+
+	// The original object is left untouched.
+	require.Contains(t, live.Object, "status")
+}
+
+func TestNormalizeNil(t *testing.T) {
+	var n *Normalizer
+	obj := mustUnstructured(t, `{
+  "apiVersion": "v1",
+  "kind": "ConfigMap",
+  "metadata": {"name": "cfg", "uid": "1234"},
+  "data": {"key": "value"},
+  "status": {}
+}`)
+
+	expected := mustUnstructured(t, `{
+  "apiVersion": "v1",
+  "kind": "ConfigMap",
+  "metadata": {"name": "cfg"},
+  "data": {"key": "value"}
+}`)
+
+	require.Equal(t, expected.Object, n.Normalize(obj).Object)
+}
+
+func TestParseFieldPath(t *testing.T) {
+	cases := []struct {
+		path     string
+		expected []string
+		isErr    bool
+	}{
+		{path: "spec.replicas", expected: []string{"spec", "replicas"}},
+		{path: "metadata.annotations[example.com/owner]", expected: []string{"metadata", "annotations", "example.com/owner"}},
+		{path: "[a.b].c", expected: []string{"a.b", "c"}},
+		{path: "", isErr: true},
+		{path: "spec..replicas", isErr: true},
+		{path: "metadata.annotations[example.com", isErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			got, err := parseFieldPath(tc.path)
+			if tc.isErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, got)
+		})
+	}
+}
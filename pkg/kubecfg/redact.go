@@ -0,0 +1,150 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// annotationKubectlLastApplied is the annotation kubectl records the last
+// applied configuration in.
+const annotationKubectlLastApplied = "kubectl.kubernetes.io/last-applied-configuration"
+
+// isSecret returns true if obj is a core Secret.
+func isSecret(obj *unstructured.Unstructured) bool {
+	gvk := obj.GroupVersionKind()
+	return gvk.Group == "" && gvk.Kind == "Secret"
+}
+
+// redactSecret returns a copy of obj with the values of a Secret replaced by
+// their hashes, see redactedValue. Other objects are returned as is. The
+// last applied configuration annotations are redacted as well.
+func redactSecret(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	if obj == nil || !isSecret(obj) {
+		return obj
+	}
+
+	redacted := obj.DeepCopy()
+	redactSecretFields(redacted.Object)
+
+	annotations := redacted.GetAnnotations()
+	for _, key := range []string{AnnotationLastApplied, annotationKubectlLastApplied} {
+		if v, ok := annotations[key]; ok {
+			annotations[key] = redactSecretJSON(v)
+		}
+	}
+	if annotations != nil {
+		redacted.SetAnnotations(annotations)
+	}
+	return redacted
+}
+
+// redactPatch redacts a patch of obj. Patches of objects other than Secrets
+// are returned as is.
+func redactPatch(obj *unstructured.Unstructured, patch []byte) string {
+	if !isSecret(obj) {
+		return string(patch)
+	}
+	return redactSecretJSON(string(patch))
+}
+
+// redactSecretJSON redacts a Secret, or a patch of one, encoded as JSON. If
+// it can't be decoded, it is redacted completely.
+func redactSecretJSON(data string) string {
+	m := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return redactedValue([]byte(data))
+	}
+
+	redactSecretFields(m)
+	if metadata, ok := m["metadata"].(map[string]interface{}); ok {
+		if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+			for _, key := range []string{AnnotationLastApplied, annotationKubectlLastApplied} {
+				if v, ok := annotations[key].(string); ok {
+					annotations[key] = redactSecretJSON(v)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(m); err != nil {
+		return redactedValue([]byte(data))
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// redactSecretFields replaces the values of the data and stringData fields
+// of a Secret in place. Values of data are decoded first, so the same value
+// has the same hash in either field. Null values, which remove keys in
+// patches, are kept.
+func redactSecretFields(m map[string]interface{}) {
+	if data, ok := m["data"].(map[string]interface{}); ok {
+		for k, v := range data {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			decoded, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				decoded = []byte(s)
+			}
+			data[k] = redactedValue(decoded)
+		}
+	}
+
+	if stringData, ok := m["stringData"].(map[string]interface{}); ok {
+		for k, v := range stringData {
+			if s, ok := v.(string); ok {
+				stringData[k] = redactedValue([]byte(s))
+			}
+		}
+	}
+}
+
+// redactionKey is the random key of the HMACs of redacted values. It is
+// generated for every run, so the HMACs can't be used to guess short or
+// common secrets offline, and don't reveal whether two runs saw the same
+// value.
+var redactionKey = newRedactionKey()
+
+func newRedactionKey() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("generate key to redact secrets: %v", err))
+	}
+	return key
+}
+
+// redactedValue replaces a secret value with a prefix of its HMAC-SHA256
+// keyed with redactionKey. Equal values have equal HMACs within one run, so a
+// diff still shows whether a value changed.
+func redactedValue(value []byte) string {
+	mac := hmac.New(sha256.New, redactionKey)
+	mac.Write(value)
+	return fmt.Sprintf("<redacted hmac:%x>", mac.Sum(nil)[:8])
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const testSecret = `{
+  "apiVersion": "v1",
+  "kind": "Secret",
+  "metadata": {"name": "creds", "namespace": "default"},
+  "data": {"password": "aHVudGVyMg=="},
+  "stringData": {"token": "hunter2"}
+}`
+
+func TestRedactSecret(t *testing.T) {
+	secret := mustUnstructured(t, testSecret)
+	require.NoError(t, setLastApplied(secret))
+
+	redacted := redactSecret(secret)
+
+	// The original is not modified.
+	require.Equal(t, "aHVudGVyMg==", secret.Object["data"].(map[string]interface{})["password"])
+
+	// Equal values have equal hashes, whether they are encoded or not.
+	hunter2 := redactedValue([]byte("hunter2"))
+	require.Equal(t, map[string]interface{}{"password": hunter2}, redacted.Object["data"])
+	require.Equal(t, map[string]interface{}{"token": hunter2}, redacted.Object["stringData"])
+
+	lastApplied := redacted.GetAnnotations()[AnnotationLastApplied]
+	require.NotContains(t, lastApplied, "hunter2")
+	require.NotContains(t, lastApplied, "aHVudGVyMg==")
+	require.Contains(t, lastApplied, hunter2)
+
+	configMap := mustUnstructured(t, `{
+  "apiVersion": "v1",
+  "kind": "ConfigMap",
+  "metadata": {"name": "cfg"},
+  "data": {"a": "1"}
+}`)
+	require.True(t, configMap == redactSecret(configMap))
+	require.Nil(t, redactSecret(nil))
+}
+
+func TestRedactPatch(t *testing.T) {
+	secret := mustUnstructured(t, testSecret)
+
+	got := redactPatch(secret, []byte(`{"data":{"password":"aHVudGVyMw==","old":null}}`))
+	var patch map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(got), &patch))
+	require.Equal(t, map[string]interface{}{
+		"data": map[string]interface{}{"password": redactedValue([]byte("hunter3")), "old": nil},
+	}, patch)
+
+	require.Equal(t, redactedValue([]byte("not json")), redactPatch(secret, []byte("not json")))
+
+	configMap := &unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap"}}
+	require.Equal(t, `{"data":{"a":"2"}}`, redactPatch(configMap, []byte(`{"data":{"a":"2"}}`)))
+}
+
+func TestDiffRedactsSecrets(t *testing.T) {
+	secret := mustUnstructured(t, testSecret)
+	changed := secret.DeepCopy()
+	changed.Object["data"] = map[string]interface{}{"password": "aHVudGVyMw=="}
+
+	c := &DiffLocalCmd{
+		Diff: Diff{DiffStrategy: DiffStrategyAll, Output: DiffOutputJSON},
+		Env1: &LocalEnv{Name: "local", APIObjects: []*unstructured.Unstructured{secret}},
+		Env2: &LocalEnv{Name: "other", APIObjects: []*unstructured.Unstructured{changed}},
+	}
+
+	var buf bytes.Buffer
+	require.Equal(t, ErrDiffFound, c.Run(&buf))
+	require.NotContains(t, buf.String(), "hunter")
+	require.NotContains(t, buf.String(), "aHVudGVy")
+	require.Contains(t, buf.String(), redactedValue([]byte("hunter2")))
+	require.Contains(t, buf.String(), redactedValue([]byte("hunter3")))
+}
+
+func TestShowRedact(t *testing.T) {
+	secret := mustUnstructured(t, testSecret)
+
+	var buf bytes.Buffer
+	require.NoError(t, ShowCmd{Format: "yaml", Redact: true}.Run([]*unstructured.Unstructured{secret}, &buf))
+	require.NotContains(t, buf.String(), "hunter2")
+	require.Contains(t, buf.String(), redactedValue([]byte("hunter2")))
+
+	buf.Reset()
+	require.NoError(t, ShowCmd{Format: "yaml"}.Run([]*unstructured.Unstructured{secret}, &buf))
+	require.Contains(t, buf.String(), "hunter2")
+}
+
+func TestRedactedValue(t *testing.T) {
+	hunter2 := redactedValue([]byte("hunter2"))
+	require.Equal(t, hunter2, redactedValue([]byte("hunter2")))
+	require.NotEqual(t, hunter2, redactedValue([]byte("hunter3")))
+
+	// The value is keyed, so it can't be looked up in a table of the hashes
+	// of common secrets.
+	sum := sha256.Sum256([]byte("hunter2"))
+	require.NotContains(t, hunter2, fmt.Sprintf("%x", sum[:8]))
+
+	// Another run uses another key.
+	defer func(key []byte) { redactionKey = key }(redactionKey)
+	redactionKey = newRedactionKey()
+	require.NotEqual(t, hunter2, redactedValue([]byte("hunter2")))
+}
@@ -0,0 +1,124 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ksonnet/ksonnet/utils"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// AnnotationForceReplace opts an object into being deleted and
+	// recreated when an apply changes one of its immutable fields, as if
+	// `--force` was given. Set it to "true" to enable.
+	AnnotationForceReplace = "kubecfg.ksonnet.io/force-replace"
+)
+
+// isImmutableFieldError reports whether err is the API server rejecting a
+// change to a field that can't be updated.
+func isImmutableFieldError(err error) bool {
+	if !errors.IsInvalid(err) {
+		return false
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "field is immutable") ||
+		// StatefulSets only allow updates to some fields of their spec.
+		strings.Contains(msg, "Forbidden: updates to")
+}
+
+// shouldReplace reports whether obj may be deleted and recreated when it
+// can't be patched.
+func (c ApplyCmd) shouldReplace(obj *unstructured.Unstructured) bool {
+	return c.Force || obj.GetAnnotations()[AnnotationForceReplace] == "true"
+}
+
+// replaceObject deletes the live version of obj and its dependents, waits
+// until it is gone and creates obj again.
+func (c ApplyCmd) replaceObject(rc dynamic.ResourceInterface, obj *unstructured.Unstructured, desc string,
+	version *utils.ServerVersion) (*unstructured.Unstructured, error) {
+	live, err := rc.Get(obj.GetName(), metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		uid := live.GetUID()
+		deleteOpts := cascadingDeleteOptions(version)
+		deleteOpts.Preconditions = &metav1.Preconditions{UID: &uid}
+
+		log.Info(" Deleting ", desc, " to replace it")
+		err = c.Retry.retry("Deleting "+desc, func() error {
+			return rc.Delete(obj.GetName(), &deleteOpts)
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("unable to delete: %s", err)
+		}
+
+		if err = waitForDeletion(rc, obj.GetName(), uid, c.WaitTimeout); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Info(" Recreating ", desc)
+	var newobj *unstructured.Unstructured
+	err = c.Retry.retry("Creating "+desc, func() error {
+		var err error
+		newobj, err = rc.Create(obj)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to recreate after deleting it: %s", err)
+	}
+
+	return newobj, nil
+}
+
+// waitForDeletion waits until the object with the given name and UID no
+// longer exists. Foreground deletion keeps the object until its dependents
+// are deleted.
+func waitForDeletion(rc dynamic.ResourceInterface, name string, uid types.UID, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+
+	err := wait.PollImmediate(waitPollInterval, timeout, func() (bool, error) {
+		live, err := rc.Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			log.Debugf("Fetching %s failed: %s", name, err)
+			return false, nil
+		}
+		return live.GetUID() != uid, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("timed out after %s waiting for deletion", timeout)
+	}
+
+	return err
+}
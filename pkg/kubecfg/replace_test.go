@@ -0,0 +1,79 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ksonnet/ksonnet/utils"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestIsImmutableFieldError(t *testing.T) {
+	gk := schema.GroupKind{Group: "batch", Kind: "Job"}
+
+	immutable := errors.NewInvalid(gk, "job", field.ErrorList{
+		field.Invalid(field.NewPath("spec", "template"), "", "field is immutable"),
+	})
+	require.True(t, isImmutableFieldError(immutable))
+
+	statefulSet := errors.NewInvalid(schema.GroupKind{Group: "apps", Kind: "StatefulSet"}, "db", field.ErrorList{
+		field.Forbidden(field.NewPath("spec"), "updates to statefulset spec for fields other than 'replicas', 'template', and 'updateStrategy' are forbidden."),
+	})
+	require.True(t, isImmutableFieldError(statefulSet))
+
+	invalid := errors.NewInvalid(gk, "job", field.ErrorList{
+		field.Required(field.NewPath("spec", "template"), ""),
+	})
+	require.False(t, isImmutableFieldError(invalid))
+
+	require.False(t, isImmutableFieldError(fmt.Errorf("field is immutable")))
+	require.False(t, isImmutableFieldError(nil))
+}
+
+func TestShouldReplace(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+
+	require.False(t, ApplyCmd{}.shouldReplace(obj))
+	require.True(t, ApplyCmd{Force: true}.shouldReplace(obj))
+
+	obj.SetAnnotations(map[string]string{AnnotationForceReplace: "true"})
+	require.True(t, ApplyCmd{}.shouldReplace(obj))
+}
+
+func TestReplaceObject(t *testing.T) {
+	client := newFakeResourceClient()
+
+	live := &unstructured.Unstructured{}
+	live.SetName("job")
+	live.SetUID(types.UID("old"))
+	client.objects["job"] = live
+
+	obj := &unstructured.Unstructured{}
+	obj.SetName("job")
+	obj.SetLabels(map[string]string{"new": "true"})
+
+	newobj, err := ApplyCmd{}.replaceObject(client, obj, "job", &utils.ServerVersion{Major: 1, Minor: 8})
+	require.NoError(t, err)
+	require.Equal(t, "true", newobj.GetLabels()["new"])
+	require.Equal(t, obj, client.objects["job"])
+}
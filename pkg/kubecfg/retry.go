@@ -0,0 +1,99 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// DefaultRetries is how often a failed request is retried if the
+	// environment doesn't configure it.
+	DefaultRetries = 5
+	// DefaultRetryDelay is the delay before the first retry.
+	DefaultRetryDelay = 500 * time.Millisecond
+	// DefaultMaxRetryDelay is the longest delay between two retries.
+	DefaultMaxRetryDelay = 30 * time.Second
+)
+
+// RetryOptions configures how requests that failed with a transient error
+// are retried. The zero value doesn't retry.
+type RetryOptions struct {
+	// Retries is how often a request is retried before giving up.
+	Retries int
+	// InitialDelay is the delay before the first retry. It doubles with
+	// every retry.
+	InitialDelay time.Duration
+	// MaxDelay caps the delay between two retries.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryOptions returns the retry options used if none are configured.
+func DefaultRetryOptions() RetryOptions {
+	return RetryOptions{
+		Retries:      DefaultRetries,
+		InitialDelay: DefaultRetryDelay,
+		MaxDelay:     DefaultMaxRetryDelay,
+	}
+}
+
+// sleep is replaced in tests.
+var sleep = time.Sleep
+
+// isRetryable reports whether a request that failed with err may succeed
+// when it is sent again: conflicts, throttling, timeouts and server errors.
+func isRetryable(err error) bool {
+	switch {
+	case errors.IsConflict(err),
+		errors.IsTooManyRequests(err),
+		errors.IsServerTimeout(err),
+		errors.IsTimeout(err),
+		errors.IsServiceUnavailable(err),
+		errors.IsInternalError(err),
+		errors.IsUnexpectedServerError(err):
+		return true
+	}
+
+	if status, ok := err.(errors.APIStatus); ok {
+		return status.Status().Code >= 500
+	}
+
+	return false
+}
+
+// retry calls fn until it succeeds, fails with an error that isn't
+// retryable or the retries are used up. The delay between calls grows
+// exponentially.
+func (o RetryOptions) retry(desc string, fn func() error) error {
+	delay := o.InitialDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= o.Retries || !isRetryable(err) {
+			return err
+		}
+
+		log.Warnf("%s failed, retrying in %s (%d/%d): %s", desc, delay, attempt+1, o.Retries, err)
+		sleep(delay)
+
+		delay *= 2
+		if o.MaxDelay > 0 && delay > o.MaxDelay {
+			delay = o.MaxDelay
+		}
+	}
+}
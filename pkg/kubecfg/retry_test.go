@@ -0,0 +1,127 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func withFakeSleep() *[]time.Duration {
+	var delays []time.Duration
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	return &delays
+}
+
+func TestRetry(t *testing.T) {
+	defer func() { sleep = time.Sleep }()
+
+	gr := schema.GroupResource{Resource: "configmaps"}
+	opts := RetryOptions{Retries: 4, InitialDelay: time.Second, MaxDelay: 3 * time.Second}
+
+	cases := []struct {
+		name   string
+		err    error
+		calls  int
+		delays []time.Duration
+	}{
+		{
+			name:   "conflict",
+			err:    errors.NewConflict(gr, "config", fmt.Errorf("modified")),
+			calls:  5,
+			delays: []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			name:   "throttled",
+			err:    errors.NewTooManyRequests("slow down", 1),
+			calls:  5,
+			delays: []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			name:   "server error",
+			err:    errors.NewInternalError(fmt.Errorf("boom")),
+			calls:  5,
+			delays: []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			name:  "not found",
+			err:   errors.NewNotFound(gr, "config"),
+			calls: 1,
+		},
+		{
+			name:  "other error",
+			err:   fmt.Errorf("invalid"),
+			calls: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			delays := withFakeSleep()
+
+			calls := 0
+			err := opts.retry("test", func() error {
+				calls++
+				return tc.err
+			})
+
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.calls, calls)
+			require.Equal(t, tc.delays, *delays)
+		})
+	}
+}
+
+func TestRetry_succeeds(t *testing.T) {
+	defer func() { sleep = time.Sleep }()
+	withFakeSleep()
+
+	calls := 0
+	err := DefaultRetryOptions().retry("test", func() error {
+		calls++
+		if calls < 3 {
+			return errors.NewServiceUnavailable("unavailable")
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+}
+
+func TestRetry_zero_value(t *testing.T) {
+	defer func() { sleep = time.Sleep }()
+	withFakeSleep()
+
+	calls := 0
+	err := RetryOptions{}.retry("test", func() error {
+		calls++
+		return errors.NewServiceUnavailable("unavailable")
+	})
+
+	require.Error(t, err)
+	require.Equal(t, 1, calls)
+}
+
+func TestSucceededText(t *testing.T) {
+	require.Equal(t, "\nNo objects were updated.", succeededText("updated", nil))
+	require.Equal(t, "\nThe following 2 object(s) were deleted:\n  a\n  b", succeededText("deleted", []string{"a", "b"}))
+}
@@ -27,9 +27,19 @@ import (
 // ShowCmd represents the show subcommand
 type ShowCmd struct {
 	Format string
+	// Redact replaces the values of Secrets with their hashes.
+	Redact bool
 }
 
 func (c ShowCmd) Run(apiObjects []*unstructured.Unstructured, out io.Writer) error {
+	if c.Redact {
+		redacted := make([]*unstructured.Unstructured, 0, len(apiObjects))
+		for _, obj := range apiObjects {
+			redacted = append(redacted, redactSecret(obj))
+		}
+		apiObjects = redacted
+	}
+
 	switch c.Format {
 	case "yaml":
 		for _, obj := range apiObjects {
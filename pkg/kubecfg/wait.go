@@ -0,0 +1,331 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ksonnet/ksonnet/utils"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// DefaultWaitTimeout is how long apply waits for objects to become ready
+	// if no timeout was given.
+	DefaultWaitTimeout = 5 * time.Minute
+
+	waitPollInterval = 2 * time.Second
+)
+
+var gkCRD = schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}
+
+// readinessFunc reports whether obj is ready. The message describes the
+// current progress. An error means the object will never become ready.
+type readinessFunc func(obj *unstructured.Unstructured) (ready bool, message string, err error)
+
+// readinessFor returns the readiness check for obj, or nil if apply doesn't
+// need to wait for objects of this kind.
+func readinessFor(obj *unstructured.Unstructured) readinessFunc {
+	switch obj.GetKind() {
+	case "Deployment":
+		return deploymentReady
+	case "StatefulSet":
+		return statefulSetReady
+	case "DaemonSet":
+		return daemonSetReady
+	case "Job":
+		return jobReady
+	case "PersistentVolumeClaim":
+		return pvcReady
+	default:
+		return nil
+	}
+}
+
+// waitObject is an object apply is waiting for.
+type waitObject struct {
+	desc     string
+	client   dynamic.ResourceInterface
+	obj      *unstructured.Unstructured
+	ready    readinessFunc
+	done     bool
+	message  string
+	lastSeen string
+}
+
+// waitForReady polls the objects until all of them are ready, one of them
+// fails or the timeout expires.
+func waitForReady(objects []*waitObject, timeout time.Duration) error {
+	if len(objects) == 0 {
+		return nil
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+
+	log.Infof("Waiting up to %s for %d object(s) to become ready", timeout, len(objects))
+
+	err := wait.PollImmediate(waitPollInterval, timeout, func() (bool, error) {
+		allDone := true
+		for _, o := range objects {
+			if o.done {
+				continue
+			}
+
+			if err := o.poll(); err != nil {
+				return false, err
+			}
+
+			if !o.done {
+				allDone = false
+			}
+		}
+
+		return allDone, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		var pending []string
+		for _, o := range objects {
+			if !o.done {
+				pending = append(pending, fmt.Sprintf("%s: %s", o.desc, o.message))
+			}
+		}
+		sort.Strings(pending)
+		return fmt.Errorf("Timed out after %s waiting for objects to become ready:\n  %s",
+			timeout, strings.Join(pending, "\n  "))
+	}
+
+	return err
+}
+
+func (o *waitObject) poll() error {
+	live, err := o.client.Get(o.obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		o.message = fmt.Sprintf("unable to fetch: %s", err)
+		log.Debugf("Fetching %s failed: %s", o.desc, err)
+		return nil
+	}
+
+	ready, message, err := o.ready(live)
+	if err != nil {
+		return fmt.Errorf("%s failed: %s", o.desc, err)
+	}
+
+	o.message = message
+	if ready {
+		o.done = true
+		log.Infof("%s is ready", o.desc)
+		return nil
+	}
+
+	if message != o.lastSeen {
+		log.Infof("%s: %s", o.desc, message)
+		o.lastSeen = message
+	}
+
+	return nil
+}
+
+// observedGeneration reports whether the controller has seen the latest
+// spec of obj.
+func observedGeneration(obj *unstructured.Unstructured) bool {
+	observed, ok := utils.NestedInt64(obj.Object, "status", "observedGeneration")
+	if !ok {
+		return false
+	}
+	return observed >= obj.GetGeneration()
+}
+
+func specReplicas(obj *unstructured.Unstructured) int64 {
+	replicas, ok := utils.NestedInt64(obj.Object, "spec", "replicas")
+	if !ok {
+		return 1
+	}
+	return replicas
+}
+
+func statusInt(obj *unstructured.Unstructured, field string) int64 {
+	i, _ := utils.NestedInt64(obj.Object, "status", field)
+	return i
+}
+
+// findCondition returns the status condition of the given type.
+func findCondition(obj *unstructured.Unstructured, conditionType string) map[string]interface{} {
+	for _, c := range utils.NestedSlice(obj.Object, "status", "conditions") {
+		m, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if m["type"] == conditionType {
+			return m
+		}
+	}
+	return nil
+}
+
+func deploymentReady(obj *unstructured.Unstructured) (bool, string, error) {
+	if !observedGeneration(obj) {
+		return false, "waiting for deployment spec update to be observed", nil
+	}
+
+	if c := findCondition(obj, "Progressing"); c != nil && c["reason"] == "ProgressDeadlineExceeded" {
+		return false, "", fmt.Errorf("deployment exceeded its progress deadline")
+	}
+
+	replicas := specReplicas(obj)
+	updated := statusInt(obj, "updatedReplicas")
+	total := statusInt(obj, "replicas")
+	available := statusInt(obj, "availableReplicas")
+
+	switch {
+	case updated < replicas:
+		return false, fmt.Sprintf("%d out of %d new replicas have been updated", updated, replicas), nil
+	case total > updated:
+		return false, fmt.Sprintf("%d old replicas are pending termination", total-updated), nil
+	case available < updated:
+		return false, fmt.Sprintf("%d of %d updated replicas are available", available, updated), nil
+	}
+
+	return true, "rollout complete", nil
+}
+
+func statefulSetReady(obj *unstructured.Unstructured) (bool, string, error) {
+	if utils.NestedString(obj.Object, "spec", "updateStrategy", "type") == "OnDelete" {
+		return true, "rollout is managed by the OnDelete strategy", nil
+	}
+
+	if !observedGeneration(obj) {
+		return false, "waiting for statefulset spec update to be observed", nil
+	}
+
+	replicas := specReplicas(obj)
+	ready := statusInt(obj, "readyReplicas")
+	if ready < replicas {
+		return false, fmt.Sprintf("%d of %d replicas are ready", ready, replicas), nil
+	}
+
+	current := utils.NestedString(obj.Object, "status", "currentRevision")
+	update := utils.NestedString(obj.Object, "status", "updateRevision")
+	if update != "" && current != update {
+		updated := statusInt(obj, "updatedReplicas")
+		return false, fmt.Sprintf("%d of %d replicas have been updated to revision %s", updated, replicas, update), nil
+	}
+
+	return true, "rollout complete", nil
+}
+
+func daemonSetReady(obj *unstructured.Unstructured) (bool, string, error) {
+	if utils.NestedString(obj.Object, "spec", "updateStrategy", "type") == "OnDelete" {
+		return true, "rollout is managed by the OnDelete strategy", nil
+	}
+
+	if !observedGeneration(obj) {
+		return false, "waiting for daemonset spec update to be observed", nil
+	}
+
+	desired := statusInt(obj, "desiredNumberScheduled")
+	updated := statusInt(obj, "updatedNumberScheduled")
+	available := statusInt(obj, "numberAvailable")
+
+	switch {
+	case updated < desired:
+		return false, fmt.Sprintf("%d out of %d new pods have been updated", updated, desired), nil
+	case available < desired:
+		return false, fmt.Sprintf("%d of %d updated pods are available", available, desired), nil
+	}
+
+	return true, "rollout complete", nil
+}
+
+func jobReady(obj *unstructured.Unstructured) (bool, string, error) {
+	if c := findCondition(obj, "Failed"); c != nil && c["status"] == "True" {
+		return false, "", fmt.Errorf("job failed: %v", c["message"])
+	}
+
+	if c := findCondition(obj, "Complete"); c != nil && c["status"] == "True" {
+		return true, "job complete", nil
+	}
+
+	succeeded := statusInt(obj, "succeeded")
+	active := statusInt(obj, "active")
+	return false, fmt.Sprintf("%d active, %d succeeded", active, succeeded), nil
+}
+
+func isCRD(obj *unstructured.Unstructured) bool {
+	return obj.GroupVersionKind().GroupKind() == gkCRD
+}
+
+// crdKind returns the kind defined by a CustomResourceDefinition.
+func crdKind(crd *unstructured.Unstructured) schema.GroupKind {
+	return schema.GroupKind{
+		Group: utils.NestedString(crd.Object, "spec", "group"),
+		Kind:  utils.NestedString(crd.Object, "spec", "names", "kind"),
+	}
+}
+
+// waitForCrds waits until the CRDs are established and then drops the cached
+// discovery information, so the kinds they define can be resolved.
+func waitForCrds(disco discovery.DiscoveryInterface, crds []*waitObject, timeout time.Duration) error {
+	if err := waitForReady(crds, timeout); err != nil {
+		return err
+	}
+
+	invalidateDiscovery(disco)
+	return nil
+}
+
+func invalidateDiscovery(disco discovery.DiscoveryInterface) {
+	if cached, ok := disco.(discovery.CachedDiscoveryInterface); ok {
+		cached.Invalidate()
+	}
+}
+
+func crdReady(obj *unstructured.Unstructured) (bool, string, error) {
+	if c := findCondition(obj, "NamesAccepted"); c != nil && c["status"] == "False" {
+		return false, "", fmt.Errorf("names not accepted: %v", c["message"])
+	}
+
+	if c := findCondition(obj, "Established"); c != nil && c["status"] == "True" {
+		return true, "established", nil
+	}
+
+	return false, "waiting for definition to be established", nil
+}
+
+func pvcReady(obj *unstructured.Unstructured) (bool, string, error) {
+	phase := utils.NestedString(obj.Object, "status", "phase")
+	switch phase {
+	case "Bound":
+		return true, "bound", nil
+	case "Lost":
+		return false, "", fmt.Errorf("persistent volume claim lost its volume")
+	case "":
+		return false, "waiting for claim to be processed", nil
+	}
+
+	return false, fmt.Sprintf("claim is %s", strings.ToLower(phase)), nil
+}
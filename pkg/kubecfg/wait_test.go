@@ -0,0 +1,175 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestReadiness(t *testing.T) {
+	newObj := func(kind string, generation int64, spec, status map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"kind": kind,
+				"metadata": map[string]interface{}{
+					"name":       "name",
+					"generation": generation,
+				},
+				"spec":   spec,
+				"status": status,
+			},
+		}
+	}
+
+	cases := []struct {
+		name    string
+		obj     *unstructured.Unstructured
+		ready   bool
+		isError bool
+	}{
+		{
+			name: "deployment not observed",
+			obj: newObj("Deployment", 2,
+				map[string]interface{}{"replicas": int64(2)},
+				map[string]interface{}{"observedGeneration": int64(1)}),
+		},
+		{
+			name: "deployment rolling",
+			obj: newObj("Deployment", 2,
+				map[string]interface{}{"replicas": int64(2)},
+				map[string]interface{}{"observedGeneration": int64(2), "updatedReplicas": int64(1), "replicas": int64(3)}),
+		},
+		{
+			name: "deployment complete",
+			obj: newObj("Deployment", 2,
+				map[string]interface{}{"replicas": int64(2)},
+				map[string]interface{}{"observedGeneration": int64(2), "updatedReplicas": int64(2), "replicas": int64(2), "availableReplicas": int64(2)}),
+			ready: true,
+		},
+		{
+			name: "deployment deadline exceeded",
+			obj: newObj("Deployment", 2,
+				map[string]interface{}{"replicas": int64(2)},
+				map[string]interface{}{
+					"observedGeneration": int64(2),
+					"conditions": []interface{}{
+						map[string]interface{}{"type": "Progressing", "reason": "ProgressDeadlineExceeded"},
+					},
+				}),
+			isError: true,
+		},
+		{
+			name: "statefulset ready",
+			obj: newObj("StatefulSet", 1,
+				map[string]interface{}{"replicas": int64(3)},
+				map[string]interface{}{"observedGeneration": int64(1), "readyReplicas": int64(3), "currentRevision": "a", "updateRevision": "a"}),
+			ready: true,
+		},
+		{
+			name: "statefulset updating",
+			obj: newObj("StatefulSet", 1,
+				map[string]interface{}{"replicas": int64(3)},
+				map[string]interface{}{"observedGeneration": int64(1), "readyReplicas": int64(3), "currentRevision": "a", "updateRevision": "b"}),
+		},
+		{
+			name: "daemonset ready",
+			obj: newObj("DaemonSet", 1, nil,
+				map[string]interface{}{"observedGeneration": int64(1), "desiredNumberScheduled": int64(2), "updatedNumberScheduled": int64(2), "numberAvailable": int64(2)}),
+			ready: true,
+		},
+		{
+			name: "job complete",
+			obj: newObj("Job", 1, nil,
+				map[string]interface{}{"conditions": []interface{}{
+					map[string]interface{}{"type": "Complete", "status": "True"},
+				}}),
+			ready: true,
+		},
+		{
+			name: "job failed",
+			obj: newObj("Job", 1, nil,
+				map[string]interface{}{"conditions": []interface{}{
+					map[string]interface{}{"type": "Failed", "status": "True", "message": "BackoffLimitExceeded"},
+				}}),
+			isError: true,
+		},
+		{
+			name:  "pvc bound",
+			obj:   newObj("PersistentVolumeClaim", 1, nil, map[string]interface{}{"phase": "Bound"}),
+			ready: true,
+		},
+		{
+			name: "pvc pending",
+			obj:  newObj("PersistentVolumeClaim", 1, nil, map[string]interface{}{"phase": "Pending"}),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fn := readinessFor(tc.obj)
+			require.NotNil(t, fn)
+
+			ready, _, err := fn(tc.obj)
+			if tc.isError {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.ready, ready)
+		})
+	}
+}
+
+func TestReadiness_unknown_kind(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"kind": "ConfigMap"}}
+	require.Nil(t, readinessFor(obj))
+}
+
+func TestCrdReady(t *testing.T) {
+	newCrd := func(conditions ...interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "apiextensions.k8s.io/v1beta1",
+				"kind":       "CustomResourceDefinition",
+				"spec": map[string]interface{}{
+					"group": "example.com",
+					"names": map[string]interface{}{"kind": "Widget"},
+				},
+				"status": map[string]interface{}{"conditions": conditions},
+			},
+		}
+	}
+
+	crd := newCrd()
+	require.True(t, isCRD(crd))
+	gk := crdKind(crd)
+	require.Equal(t, "Widget.example.com", gk.String())
+
+	ready, _, err := crdReady(crd)
+	require.NoError(t, err)
+	require.False(t, ready)
+
+	ready, _, err = crdReady(newCrd(map[string]interface{}{"type": "Established", "status": "True"}))
+	require.NoError(t, err)
+	require.True(t, ready)
+
+	_, _, err = crdReady(newCrd(map[string]interface{}{"type": "NamesAccepted", "status": "False", "message": "conflict"}))
+	require.Error(t, err)
+}
@@ -0,0 +1,517 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/ksonnet/ksonnet/client"
+	"github.com/ksonnet/ksonnet/utils"
+)
+
+const (
+	// DefaultWatchDebounce is how long file changes have to settle before
+	// the environment is rendered again.
+	DefaultWatchDebounce = 500 * time.Millisecond
+
+	// watchRetryDelay is the delay before a failed watch of the cluster is
+	// started again.
+	watchRetryDelay = 5 * time.Second
+)
+
+// WatchCmd renders an environment whenever its files change, watches its
+// objects in the cluster and reports when the live objects drift from the
+// rendered ones.
+type WatchCmd struct {
+	ClientConfig *client.Config
+	App          string
+	Env          string
+
+	// Render renders the objects of the environment.
+	Render func() ([]*unstructured.Unstructured, error)
+	// Paths are the directories that are watched for changes. Their
+	// subdirectories are watched as well.
+	Paths []string
+	// Debounce is how long file changes have to settle before the objects
+	// are rendered again.
+	Debounce time.Duration
+
+	// Diff configures how live and rendered objects are compared.
+	Diff Diff
+	// Reconcile applies objects that drifted.
+	Reconcile bool
+	// GcTag is the garbage collection tag that reconciled objects are
+	// applied with. It has to match the tag of `ks apply --gc-tag`, or
+	// reconciling removes the tag from the objects.
+	GcTag string
+	// Retry configures how failed requests are retried when reconciling.
+	Retry RetryOptions
+}
+
+// reconcileCmd returns the command that applies objects that drifted.
+func (c WatchCmd) reconcileCmd() ApplyCmd {
+	return ApplyCmd{
+		ClientConfig: c.ClientConfig,
+		App:          c.App,
+		Env:          c.Env,
+		GcTag:        c.GcTag,
+		Create:       true,
+		Retry:        c.Retry,
+	}
+}
+
+// watchTarget is a resource type in a namespace that is watched, and the
+// rendered objects of that type and namespace by name.
+type watchTarget struct {
+	desc    string
+	client  dynamic.ResourceInterface
+	objects map[string]*unstructured.Unstructured
+}
+
+// liveEvent is a change of a watched object.
+type liveEvent struct {
+	target  *watchTarget
+	obj     *unstructured.Unstructured
+	deleted bool
+}
+
+// Run watches the environment until stop is closed.
+func (c WatchCmd) Run(out io.Writer, stop <-chan struct{}) error {
+	clientPool, disco, namespace, err := c.ClientConfig.RestClient(&c.Env)
+	if err != nil {
+		return err
+	}
+
+	version, err := utils.FetchVersion(disco)
+	if err != nil {
+		return err
+	}
+
+	fsWatcher, err := newRecursiveWatcher(c.Paths)
+	if err != nil {
+		return err
+	}
+	defer fsWatcher.Close()
+
+	apply := c.reconcileCmd()
+
+	dw := newDriftWatcher(out, c.Diff)
+	if c.Reconcile {
+		dw.reconcile = func(obj *unstructured.Unstructured) error {
+			r := apply.applyOne(clientPool, disco, &version, namespace, obj.DeepCopy(), nil)
+			return r.err
+		}
+	}
+
+	events := make(chan liveEvent)
+	var targets map[*watchTarget]bool
+	var stopWatches chan struct{}
+
+	render := func() {
+		objs, err := c.Render()
+		if err != nil {
+			dw.printf("Unable to render environment %q: %s", c.Env, err)
+			return
+		}
+
+		newTargets, err := watchTargets(clientPool, disco, namespace, objs)
+		if err != nil {
+			dw.printf("Unable to watch environment %q: %s", c.Env, err)
+			return
+		}
+
+		if stopWatches != nil {
+			close(stopWatches)
+		}
+		stopWatches = make(chan struct{})
+
+		targets = make(map[*watchTarget]bool)
+		var descs []string
+		for _, t := range newTargets {
+			targets[t] = true
+			for _, obj := range t.objects {
+				descs = append(descs, hash(&disco, obj, true))
+			}
+			go watchResource(t, events, stopWatches)
+		}
+		dw.retain(descs)
+
+		log.Infof("Watching %d object(s) of environment %q", len(descs), c.Env)
+	}
+	defer func() {
+		if stopWatches != nil {
+			close(stopWatches)
+		}
+	}()
+
+	render()
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return nil
+		case ev := <-fsWatcher.Events:
+			log.Debugf("File changed: %s", ev)
+			if ev.Op&fsnotify.Create != 0 {
+				fsWatcher.addDir(ev.Name)
+			}
+			debounce = time.After(c.Debounce)
+		case err := <-fsWatcher.Errors:
+			log.Warnf("Error watching files: %s", err)
+		case <-debounce:
+			debounce = nil
+			log.Info("Files changed, rendering environment again")
+			render()
+		case ev := <-events:
+			if !targets[ev.target] {
+				// The event is from before the last render.
+				continue
+			}
+			desired, ok := ev.target.objects[ev.obj.GetName()]
+			if !ok {
+				continue
+			}
+
+			live := ev.obj
+			if ev.deleted {
+				live = nil
+			}
+			dw.check(hash(&disco, desired, true), desired, live)
+		}
+	}
+}
+
+// watchTargets groups objs by resource type and namespace.
+func watchTargets(clientPool dynamic.ClientPool, disco discovery.DiscoveryInterface, namespace string,
+	objs []*unstructured.Unstructured) ([]*watchTarget, error) {
+	byKey := make(map[string]*watchTarget)
+	var targets []*watchTarget
+	for _, obj := range objs {
+		ns := obj.GetNamespace()
+		if ns == "" {
+			ns = namespace
+		}
+		key := fmt.Sprintf("%s %s", obj.GroupVersionKind(), ns)
+
+		t, ok := byKey[key]
+		if !ok {
+			rc, err := utils.ClientForResource(clientPool, disco, obj, namespace)
+			if err != nil {
+				return nil, err
+			}
+			t = &watchTarget{
+				desc:    fmt.Sprintf("%s in namespace %q", utils.ResourceNameFor(disco, obj), ns),
+				client:  rc,
+				objects: make(map[string]*unstructured.Unstructured),
+			}
+			byKey[key] = t
+			targets = append(targets, t)
+		}
+		t.objects[obj.GetName()] = obj
+	}
+	return targets, nil
+}
+
+// watchResource sends the changes of the objects of t to events until stop
+// is closed. It lists the objects first, and then watches them from the
+// resource version of the list. A watch that ends is resumed from the last
+// resource version it saw, so existing objects aren't reported again. The
+// objects are only listed again if that version expired.
+func watchResource(t *watchTarget, events chan<- liveEvent, stop <-chan struct{}) {
+	var resourceVersion string
+	for {
+		var err error
+		if resourceVersion == "" {
+			var done bool
+			resourceVersion, done, err = listResource(t, events, stop)
+			if done {
+				return
+			}
+		}
+
+		var w watch.Interface
+		if err == nil {
+			w, err = t.client.Watch(metav1.ListOptions{ResourceVersion: resourceVersion})
+			if resourceVersionExpired(err) {
+				resourceVersion = ""
+			}
+		}
+		if err != nil {
+			log.Warnf("Unable to watch %s, retrying in %s: %s", t.desc, watchRetryDelay, err)
+			select {
+			case <-stop:
+				return
+			case <-time.After(watchRetryDelay):
+				continue
+			}
+		}
+
+		log.Debugf("Watching %s from resource version %s", t.desc, resourceVersion)
+		var done bool
+		resourceVersion, done = forwardEvents(t, w, events, stop, resourceVersion)
+		if done {
+			return
+		}
+		if resourceVersion == "" {
+			log.Debugf("Resource version of %s expired, listing it again", t.desc)
+		} else {
+			log.Debugf("Watch of %s ended, resuming it", t.desc)
+		}
+	}
+}
+
+// listResource sends the live versions of the objects of t to events.
+// Objects which don't exist in the cluster are sent as deleted. It returns
+// the resource version of the list, and true if stop was closed.
+func listResource(t *watchTarget, events chan<- liveEvent, stop <-chan struct{}) (string, bool, error) {
+	obj, err := t.client.List(metav1.ListOptions{})
+	if err != nil {
+		return "", false, err
+	}
+	list, ok := obj.(*unstructured.UnstructuredList)
+	if !ok {
+		return "", false, fmt.Errorf("unexpected %T listing %s", obj, t.desc)
+	}
+
+	found := make(map[string]bool)
+	for i := range list.Items {
+		item := &list.Items[i]
+		if _, ok := t.objects[item.GetName()]; !ok {
+			continue
+		}
+		found[item.GetName()] = true
+		if !sendEvent(events, liveEvent{target: t, obj: item}, stop) {
+			return "", true, nil
+		}
+	}
+	for name, obj := range t.objects {
+		if found[name] {
+			continue
+		}
+		if !sendEvent(events, liveEvent{target: t, obj: obj, deleted: true}, stop) {
+			return "", true, nil
+		}
+	}
+
+	return list.GetResourceVersion(), false, nil
+}
+
+// forwardEvents sends the events of w to events. It returns the resource
+// version to resume watching from, which is empty if the resource version
+// expired, and true if stop was closed.
+func forwardEvents(t *watchTarget, w watch.Interface, events chan<- liveEvent, stop <-chan struct{}, resourceVersion string) (string, bool) {
+	defer w.Stop()
+	for {
+		select {
+		case <-stop:
+			return resourceVersion, true
+		case ev, ok := <-w.ResultChan():
+			if !ok {
+				return resourceVersion, false
+			}
+
+			switch ev.Type {
+			case watch.Added, watch.Modified, watch.Deleted:
+			case watch.Error:
+				err := kerrors.FromObject(ev.Object)
+				if resourceVersionExpired(err) {
+					return "", false
+				}
+				log.Debugf("Error watching %s: %v", t.desc, err)
+				return resourceVersion, false
+			default:
+				continue
+			}
+
+			obj, ok := ev.Object.(*unstructured.Unstructured)
+			if !ok {
+				log.Debugf("Ignoring unexpected %T watching %s", ev.Object, t.desc)
+				continue
+			}
+			resourceVersion = obj.GetResourceVersion()
+
+			if !sendEvent(events, liveEvent{target: t, obj: obj, deleted: ev.Type == watch.Deleted}, stop) {
+				return resourceVersion, true
+			}
+		}
+	}
+}
+
+// sendEvent sends ev to events. It returns false if stop was closed first.
+func sendEvent(events chan<- liveEvent, ev liveEvent, stop <-chan struct{}) bool {
+	select {
+	case events <- ev:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// resourceVersionExpired reports whether err means that a watch can't be
+// started from its resource version (410 Gone), because the version is
+// older than the history the API server keeps.
+func resourceVersionExpired(err error) bool {
+	return kerrors.IsGone(err) || kerrors.IsResourceExpired(err)
+}
+
+// driftWatcher reports when live objects drift from the rendered objects,
+// and when they are back in sync.
+type driftWatcher struct {
+	out  io.Writer
+	diff Diff
+	// reconcile applies an object that drifted. It may be nil.
+	reconcile func(*unstructured.Unstructured) error
+	now       func() time.Time
+	// reported is the drift last reported for an object.
+	reported map[string]string
+}
+
+func newDriftWatcher(out io.Writer, diff Diff) *driftWatcher {
+	diff.ThreeWay = true
+	return &driftWatcher{
+		out:      out,
+		diff:     diff,
+		now:      time.Now,
+		reported: make(map[string]string),
+	}
+}
+
+func (dw *driftWatcher) printf(format string, args ...interface{}) {
+	fmt.Fprintf(dw.out, "%s %s\n", dw.now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
+
+// retain forgets the reported drift of objects that are no longer rendered.
+func (dw *driftWatcher) retain(descs []string) {
+	keep := make(map[string]bool)
+	for _, desc := range descs {
+		keep[desc] = true
+	}
+	for desc := range dw.reported {
+		if !keep[desc] {
+			delete(dw.reported, desc)
+		}
+	}
+}
+
+// check compares the live version of an object with the desired version. A
+// nil live object was deleted from the cluster. Drift is only reported when
+// it changes.
+func (dw *driftWatcher) check(desc string, desired, live *unstructured.Unstructured) {
+	drift := dw.drift(desc, desired, live)
+	if drift == dw.reported[desc] {
+		return
+	}
+	dw.reported[desc] = drift
+
+	if drift == "" {
+		dw.printf("%s is in sync", desc)
+		return
+	}
+
+	dw.printf("%s drifted:\n%s", desc, drift)
+
+	if dw.reconcile != nil {
+		dw.printf("Reconciling %s", desc)
+		if err := dw.reconcile(desired); err != nil {
+			dw.printf("Unable to reconcile %s: %s", desc, err)
+		}
+	}
+}
+
+// drift describes how live differs from desired. It is empty if they are
+// in sync. Fields that are only set in the cluster are ignored.
+func (dw *driftWatcher) drift(desc string, desired, live *unstructured.Unstructured) string {
+	if live == nil {
+		return "  the object doesn't exist in the cluster"
+	}
+
+	od := dw.diff.diffObject(desc, desired, live)
+	if od.status == DiffStatusUnchanged {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	if od.hasLastApplied {
+		writeThreeWayText(&buf, od.threeWay)
+	} else {
+		for _, c := range fieldChanges("", od.b, od.a, od.t, true) {
+			fmt.Fprintf(&buf, "%s: %s in the cluster, %s in the configuration\n", c.Path, formatField(c.Old), formatField(c.New))
+		}
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if line != "" {
+			lines = append(lines, "  "+line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// recursiveWatcher is a fsnotify.Watcher that watches directory trees.
+type recursiveWatcher struct {
+	*fsnotify.Watcher
+}
+
+func newRecursiveWatcher(paths []string) (*recursiveWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	rw := &recursiveWatcher{Watcher: w}
+	for _, p := range paths {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			log.Debugf("Not watching %s, it doesn't exist", p)
+			continue
+		}
+		if err := rw.addDir(p); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	return rw, nil
+}
+
+// addDir watches dir and its subdirectories. It ignores files.
+func (rw *recursiveWatcher) addDir(dir string) error {
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		log.Debugf("Watching directory %s", path)
+		return rw.Add(path)
+	})
+}
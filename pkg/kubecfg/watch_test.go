@@ -0,0 +1,295 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestDriftWatcher(t *testing.T) {
+	desired := mustUnstructured(t, `{
+  "apiVersion": "v1",
+  "kind": "ConfigMap",
+  "metadata": {"name": "cfg", "namespace": "default"},
+  "data": {"a": "1"}
+}`)
+
+	var buf bytes.Buffer
+	var reconciled []string
+	dw := newDriftWatcher(&buf, Diff{DiffStrategy: DiffStrategyNormalized})
+	dw.now = func() time.Time {
+		return time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	}
+	dw.reconcile = func(obj *unstructured.Unstructured) error {
+		reconciled = append(reconciled, obj.GetName())
+		return nil
+	}
+
+	const desc = "configmap default.cfg"
+	check := func(live *unstructured.Unstructured) string {
+		buf.Reset()
+		dw.check(desc, desired, live)
+		return buf.String()
+	}
+
+	// Fields that are only set in the cluster are not drift.
+	live := desired.DeepCopy()
+	live.SetUID("1234")
+	live.Object["binaryData"] = map[string]interface{}{"b": "Mg=="}
+	require.Equal(t, "", check(live))
+
+	live.Object["data"] = map[string]interface{}{"a": "2"}
+	require.Equal(t, "2018-01-02T03:04:05Z configmap default.cfg drifted:\n"+
+		"  data.a: \"2\" in the cluster, \"1\" in the configuration\n"+
+		"2018-01-02T03:04:05Z Reconciling configmap default.cfg\n", check(live))
+	require.Equal(t, []string{"cfg"}, reconciled)
+
+	// The same drift is only reported once.
+	require.Equal(t, "", check(live))
+
+	require.Equal(t, "2018-01-02T03:04:05Z configmap default.cfg is in sync\n", check(desired.DeepCopy()))
+
+	require.Contains(t, check(nil), "the object doesn't exist in the cluster")
+	require.Equal(t, []string{"cfg", "cfg"}, reconciled)
+}
+
+func TestDriftWatcherThreeWay(t *testing.T) {
+	desired := mustUnstructured(t, `{
+  "apiVersion": "v1",
+  "kind": "ConfigMap",
+  "metadata": {"name": "cfg", "namespace": "default"},
+  "data": {"a": "1", "b": "1"}
+}`)
+
+	data, err := json.Marshal(desired.Object)
+	require.NoError(t, err)
+
+	// The cluster changed b since the configuration was applied.
+	live := desired.DeepCopy()
+	live.Object["data"] = map[string]interface{}{"a": "1", "b": "2"}
+	live.SetAnnotations(map[string]string{AnnotationLastApplied: string(data)})
+
+	var buf bytes.Buffer
+	dw := newDriftWatcher(&buf, Diff{DiffStrategy: DiffStrategyNormalized})
+	dw.check("configmap default.cfg", desired, live)
+
+	require.Contains(t, buf.String(), "configmap default.cfg drifted:")
+	require.Contains(t, buf.String(), FieldDriftedInCluster)
+	require.Contains(t, buf.String(), "data.b")
+	require.NotContains(t, buf.String(), "data.a")
+}
+
+func TestDriftWatcherRetain(t *testing.T) {
+	dw := newDriftWatcher(&bytes.Buffer{}, Diff{})
+	dw.reported["a"] = "drift"
+	dw.reported["b"] = "drift"
+
+	dw.retain([]string{"b", "c"})
+	require.Equal(t, map[string]string{"b": "drift"}, dw.reported)
+}
+
+func TestForwardEvents(t *testing.T) {
+	target := &watchTarget{desc: "configmaps"}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "cfg", "resourceVersion": "2"},
+	}}
+
+	type result struct {
+		resourceVersion string
+		done            bool
+	}
+
+	w := watch.NewFake()
+	events := make(chan liveEvent)
+	stop := make(chan struct{})
+	results := make(chan result)
+	forward := func(w watch.Interface) {
+		go func() {
+			rv, done := forwardEvents(target, w, events, stop, "1")
+			results <- result{rv, done}
+		}()
+	}
+
+	forward(w)
+	w.Add(obj)
+	require.Equal(t, liveEvent{target: target, obj: obj}, <-events)
+	w.Delete(obj)
+	require.Equal(t, liveEvent{target: target, obj: obj, deleted: true}, <-events)
+
+	// The watch ended, so it has to be resumed from the last version.
+	w.Stop()
+	require.Equal(t, result{"2", false}, <-results)
+
+	// An expired resource version can't be resumed from.
+	w = watch.NewFake()
+	forward(w)
+	w.Error(&metav1.Status{Status: metav1.StatusFailure, Code: http.StatusGone, Reason: metav1.StatusReasonGone})
+	require.Equal(t, result{"", false}, <-results)
+
+	w = watch.NewFake()
+	forward(w)
+	close(stop)
+	require.Equal(t, result{"1", true}, <-results)
+}
+
+// watchClient is a fakeResourceClient whose watches are started by the
+// test.
+type watchClient struct {
+	*fakeResourceClient
+	resourceVersion string
+	lists           int
+	watches         chan string
+	watchers        chan *watch.FakeWatcher
+}
+
+func (c *watchClient) List(opts metav1.ListOptions) (runtime.Object, error) {
+	c.lists++
+	obj, err := c.fakeResourceClient.List(opts)
+	if err != nil {
+		return nil, err
+	}
+	list := obj.(*unstructured.UnstructuredList)
+	list.SetResourceVersion(c.resourceVersion)
+	return list, nil
+}
+
+func (c *watchClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	c.watches <- opts.ResourceVersion
+	return <-c.watchers, nil
+}
+
+func TestWatchResource(t *testing.T) {
+	newObj := func(name, resourceVersion string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": name, "resourceVersion": resourceVersion},
+		}}
+	}
+
+	client := &watchClient{
+		fakeResourceClient: newFakeResourceClient(),
+		resourceVersion:    "10",
+		watches:            make(chan string),
+		watchers:           make(chan *watch.FakeWatcher),
+	}
+	client.objects["live"] = newObj("live", "5")
+	client.objects["other"] = newObj("other", "6")
+
+	target := &watchTarget{
+		desc:   "configmaps",
+		client: client,
+		objects: map[string]*unstructured.Unstructured{
+			"live":    newObj("live", ""),
+			"missing": newObj("missing", ""),
+		},
+	}
+
+	events := make(chan liveEvent)
+	stop := make(chan struct{})
+	defer close(stop)
+	go watchResource(target, events, stop)
+
+	// The listed objects are reported first. Rendered objects which don't
+	// exist are reported as deleted.
+	listed := func() map[string]bool {
+		got := map[string]bool{}
+		for i := 0; i < 2; i++ {
+			ev := <-events
+			got[ev.obj.GetName()] = ev.deleted
+		}
+		return got
+	}
+	require.Equal(t, map[string]bool{"live": false, "missing": true}, listed())
+
+	// The watch starts from the version of the list.
+	require.Equal(t, "10", <-client.watches)
+	w := watch.NewFake()
+	client.watchers <- w
+	w.Modify(newObj("live", "11"))
+	require.Equal(t, "11", (<-events).obj.GetResourceVersion())
+
+	// A watch that ends is resumed without listing the objects again.
+	w.Stop()
+	require.Equal(t, "11", <-client.watches)
+	require.Equal(t, 1, client.lists)
+
+	// If the version expired, the objects are listed again.
+	w = watch.NewFake()
+	client.watchers <- w
+	client.resourceVersion = "20"
+	w.Error(&metav1.Status{Status: metav1.StatusFailure, Code: http.StatusGone, Reason: metav1.StatusReasonGone})
+	require.Equal(t, map[string]bool{"live": false, "missing": true}, listed())
+	require.Equal(t, "20", <-client.watches)
+	require.Equal(t, 2, client.lists)
+	client.watchers <- watch.NewFake()
+}
+
+// patchRecorder records the patches sent to a fakeResourceClient.
+type patchRecorder struct {
+	*fakeResourceClient
+	patches []string
+}
+
+func (c *patchRecorder) Patch(name string, pt types.PatchType, data []byte) (*unstructured.Unstructured, error) {
+	c.patches = append(c.patches, string(data))
+	return c.fakeResourceClient.Patch(name, pt, data)
+}
+
+func TestWatchReconcileKeepsGcTag(t *testing.T) {
+	c := WatchCmd{App: "guestbook", Env: "dev", GcTag: "dev"}
+	apply := c.reconcileCmd()
+
+	rendered := func() *unstructured.Unstructured {
+		return mustUnstructured(t, `{
+			"apiVersion": "v1",
+			"kind": "ConfigMap",
+			"metadata": {"name": "cfg"},
+			"data": {"key": "value"}
+		}`)
+	}
+
+	// The live object was applied with `ks apply --gc-tag dev`, and its data
+	// drifted since.
+	live := rendered()
+	ApplyCmd{App: "guestbook", Env: "dev", GcTag: "dev"}.setOwnership(live)
+	require.NoError(t, setLastApplied(live))
+	live.Object["data"] = map[string]interface{}{"key": "drifted"}
+
+	client := &patchRecorder{fakeResourceClient: newFakeResourceClient()}
+	client.objects[live.GetName()] = live
+
+	obj := rendered()
+	apply.setOwnership(obj)
+	_, action, err := apply.applyObject(client, obj, "configmaps cfg")
+	require.NoError(t, err)
+	require.Equal(t, ActionPatch, action)
+
+	// Only the drifted data is patched; the gc-tag label and annotation are
+	// left alone.
+	require.Equal(t, []string{`{"data":{"key":"value"}}`}, client.patches)
+}
@@ -0,0 +1,249 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/ksonnet/ksonnet/component"
+	jsonnetutil "github.com/ksonnet/ksonnet/pkg/util/jsonnet"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// CacheDir is the directory of the render cache, relative to the root
+	// of the app.
+	CacheDir = ".ksonnet/cache"
+
+	// cacheVersion is part of every key, so entries written in another
+	// format are never read.
+	cacheVersion = "1"
+)
+
+// WithCache stores the objects of evaluated components in c, and reuses them
+// while the inputs of the components are unchanged.
+func WithCache(c *Cache) Opt {
+	return func(p *Pipeline) {
+		p.cache = c
+	}
+}
+
+// Cache stores the objects components evaluate to. An entry is keyed by the
+// component, the environment, the parameters and the evaluation options, and
+// is only used while the component's source and every file it imported,
+// including files that were looked for but didn't exist, are unchanged.
+// Images resolved from a registry aren't part of the key, so a pipeline
+// which resolves them shouldn't use a cache.
+type Cache struct {
+	fs  afero.Fs
+	dir string
+}
+
+// NewCache creates an instance of Cache which stores its entries in dir.
+func NewCache(fs afero.Fs, dir string) *Cache {
+	return &Cache{
+		fs:  fs,
+		dir: dir,
+	}
+}
+
+// Clean removes all entries from the cache.
+func (c *Cache) Clean() error {
+	return c.fs.RemoveAll(c.dir)
+}
+
+// cacheEntry is the stored evaluation of a component.
+type cacheEntry struct {
+	Dependencies []jsonnetutil.Dependency `json:"dependencies"`
+	Objects      []json.RawMessage        `json:"objects"`
+}
+
+// sourcer is a component which is defined in a file.
+type sourcer interface {
+	Source() string
+}
+
+// cacheKey returns the key of the evaluation of c, or false if c can't be
+// cached.
+func cacheKey(envName string, c component.Component, paramsStr string, opts component.EvalOptions, importer *jsonnetutil.Importer) (string, bool) {
+	s, ok := c.(sourcer)
+	if !ok {
+		return "", false
+	}
+
+	h := sha256.New()
+	write := func(values ...string) {
+		for _, v := range values {
+			// Prefix the length, so values can't run into each other.
+			fmt.Fprintf(h, "%d:%s", len(v), v)
+		}
+	}
+	writeMap := func(m map[string]string) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		write(fmt.Sprint(len(keys)))
+		for _, k := range keys {
+			write(k, m[k])
+		}
+	}
+
+	write(cacheVersion, envName, c.Name(true), s.Source(), paramsStr)
+	// The search paths include the ksonnet library of the environment, so
+	// they change with its version.
+	write(fmt.Sprint(len(importer.SearchPaths())))
+	write(importer.SearchPaths()...)
+	writeMap(opts.ExtVars)
+	writeMap(opts.ExtCodes)
+	writeMap(opts.TLAVars)
+
+	return fmt.Sprintf("%x", h.Sum(nil)), true
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// get returns the objects stored for key, or false if there are none or
+// a dependency changed. Files are read with importer.
+func (c *Cache) get(key string, importer *jsonnetutil.Importer) ([]*unstructured.Unstructured, bool) {
+	data, err := afero.ReadFile(c.fs, c.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry cacheEntry
+	if err = json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+
+	for _, dep := range entry.Dependencies {
+		hash, err := importer.Hash(dep.Path)
+		if err != nil || hash != dep.Hash {
+			return nil, false
+		}
+	}
+
+	objects := make([]*unstructured.Unstructured, 0, len(entry.Objects))
+	for _, raw := range entry.Objects {
+		// Decode like components do, so numbers stay integers.
+		obj, _, err := unstructured.UnstructuredJSONScheme.Decode(raw, nil, nil)
+		if err != nil {
+			return nil, false
+		}
+		uns, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return nil, false
+		}
+		objects = append(objects, uns)
+	}
+
+	return objects, true
+}
+
+// put stores objects for key.
+func (c *Cache) put(key string, deps []jsonnetutil.Dependency, objects []*unstructured.Unstructured) error {
+	entry := cacheEntry{
+		Dependencies: deps,
+		Objects:      make([]json.RawMessage, 0, len(objects)),
+	}
+	for _, obj := range objects {
+		data, err := obj.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		entry.Objects = append(entry.Objects, data)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err = c.fs.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a concurrent render never reads
+	// a partial entry.
+	f, err := afero.TempFile(c.fs, c.dir, key)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		c.fs.Remove(f.Name())
+		return err
+	}
+
+	if err = c.fs.Rename(f.Name(), c.path(key)); err != nil {
+		c.fs.Remove(f.Name())
+		return errors.Wrap(err, "store cache entry")
+	}
+
+	return nil
+}
+
+// evaluateCached evaluates job with the objects stored in p.cache, or stores
+// the objects it evaluates to. Components that can't be cached are evaluated
+// as usual.
+func (p *Pipeline) evaluateCached(job *evalJob, opts component.EvalOptions, importer *jsonnetutil.Importer) {
+	key, ok := cacheKey(p.envName, job.component, job.paramsStr, opts, importer)
+	if !ok {
+		job.objects, job.err = job.component.Objects(job.paramsStr, p.envName, opts)
+		return
+	}
+
+	name := job.component.Name(true)
+	if objects, ok := p.cache.get(key, importer); ok {
+		logrus.Debugf("using cached objects of component %s", name)
+		job.objects = objects
+		return
+	}
+
+	// Hash the source before it is evaluated, so a change during the
+	// evaluation invalidates the entry.
+	source := job.component.(sourcer).Source()
+	sourceHash, err := importer.Hash(source)
+	if err != nil {
+		sourceHash = ""
+	}
+
+	tracker := importer.Track()
+	opts.Importer = tracker
+	job.objects, job.err = job.component.Objects(job.paramsStr, p.envName, opts)
+	if job.err != nil || sourceHash == "" {
+		return
+	}
+
+	deps := append(tracker.Dependencies(), jsonnetutil.Dependency{Path: source, Hash: sourceHash})
+	if err = p.cache.put(key, deps, job.objects); err != nil {
+		logrus.Warnf("unable to cache objects of component %s: %v", name, err)
+	}
+}
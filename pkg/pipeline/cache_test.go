@@ -0,0 +1,146 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package pipeline
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ksonnet/ksonnet/component"
+	cmocks "github.com/ksonnet/ksonnet/component/mocks"
+	appmocks "github.com/ksonnet/ksonnet/metadata/app/mocks"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// sourceComponent is a mocked component which is defined in a file.
+type sourceComponent struct {
+	*cmocks.Component
+	source string
+}
+
+func (c *sourceComponent) Source() string {
+	return c.source
+}
+
+// withCachedPipeline creates a pipeline with a cache, and a namespace which
+// contains the components returned by components.
+func withCachedPipeline(t *testing.T, components func(a *appmocks.App) []component.Component, fn func(p *Pipeline, fs afero.Fs)) {
+	withPipeline(t, func(p *Pipeline, m *cmocks.Manager, a *appmocks.App) {
+		fs := a.Fs()
+		WithCache(NewCache(fs, filepath.Join(a.Root(), CacheDir)))(p)
+
+		ns := component.NewNamespace(p.app, "/")
+		m.On("Namespaces", p.app, "default").Return([]component.Namespace{ns}, nil)
+		m.On("Namespace", p.app, "/").Return(ns, nil)
+		m.On("NSResolveParams", ns).Return("", nil)
+		a.On("EnvironmentParams", "default").Return("{}", nil)
+		m.On("Components", ns).Return(components(a), nil)
+
+		fn(p, fs)
+	})
+}
+
+func TestPipeline_Objects_cache(t *testing.T) {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion("v1")
+	u.SetKind("ConfigMap")
+	u.SetName("cfg")
+	u.Object["data"] = map[string]interface{}{"replicas": int64(3)}
+
+	cpnt := &sourceComponent{Component: mockComponent("cfg"), source: "/app/components/cfg.jsonnet"}
+	cpnt.On("Objects", mock.Anything, "default", mock.Anything).Return([]*unstructured.Unstructured{u}, nil)
+
+	components := func(*appmocks.App) []component.Component {
+		return []component.Component{cpnt}
+	}
+
+	withCachedPipeline(t, components, func(p *Pipeline, fs afero.Fs) {
+		require.NoError(t, afero.WriteFile(fs, cpnt.source, []byte("{}"), 0644))
+
+		render := func() {
+			got, err := p.Objects(nil)
+			require.NoError(t, err)
+			require.Equal(t, []*unstructured.Unstructured{u}, got)
+		}
+
+		render()
+		render()
+		cpnt.AssertNumberOfCalls(t, "Objects", 1)
+
+		// Changing the source invalidates the entry.
+		require.NoError(t, afero.WriteFile(fs, cpnt.source, []byte("{ }"), 0644))
+		render()
+		render()
+		cpnt.AssertNumberOfCalls(t, "Objects", 2)
+
+		// Changing the options changes the key.
+		WithEvalOptions(component.EvalOptions{ExtVars: map[string]string{"a": "b"}})(p)
+		render()
+		cpnt.AssertNumberOfCalls(t, "Objects", 3)
+
+		// Corrupt entries are ignored.
+		entries, err := afero.ReadDir(fs, "/app/.ksonnet/cache")
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		for _, entry := range entries {
+			require.NoError(t, afero.WriteFile(fs, filepath.Join("/app/.ksonnet/cache", entry.Name()), []byte("{"), 0644))
+		}
+		render()
+		cpnt.AssertNumberOfCalls(t, "Objects", 4)
+
+		require.NoError(t, p.cache.Clean())
+		render()
+		cpnt.AssertNumberOfCalls(t, "Objects", 5)
+	})
+}
+
+func TestPipeline_Objects_cacheImports(t *testing.T) {
+	source := "/app/components/web.jsonnet"
+	components := func(a *appmocks.App) []component.Component {
+		return []component.Component{component.NewJsonnet(a, "/", source, "/app/components/params.libsonnet")}
+	}
+
+	withCachedPipeline(t, components, func(p *Pipeline, fs afero.Fs) {
+		require.NoError(t, afero.WriteFile(fs, source, []byte(`{
+  apiVersion: "v1",
+  kind: "ConfigMap",
+  metadata: { name: "web" },
+  data: { value: import "value.libsonnet" },
+}`), 0644))
+
+		value := func() interface{} {
+			got, err := p.Objects(nil)
+			require.NoError(t, err)
+			require.Len(t, got, 1)
+			return got[0].Object["data"].(map[string]interface{})["value"]
+		}
+
+		require.NoError(t, afero.WriteFile(fs, "/app/lib/value.libsonnet", []byte(`"lib"`), 0644))
+		require.Equal(t, "lib", value())
+		require.Equal(t, "lib", value())
+
+		// Changing an imported file invalidates the entry.
+		require.NoError(t, afero.WriteFile(fs, "/app/lib/value.libsonnet", []byte(`"changed"`), 0644))
+		require.Equal(t, "changed", value())
+
+		// So does creating a file which is imported instead.
+		require.NoError(t, afero.WriteFile(fs, "/app/components/value.libsonnet", []byte(`"sibling"`), 0644))
+		require.Equal(t, "sibling", value())
+	})
+}
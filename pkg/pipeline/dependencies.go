@@ -0,0 +1,190 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package pipeline
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// namespaceDependencies is the part of the parameters of a namespace that
+// declares the components its components depend on, by the local name of
+// the dependent component.
+type namespaceDependencies struct {
+	Dependencies map[string][]string `json:"dependencies"`
+}
+
+// Layers converts components into Kubernetes objects like Objects does, and
+// groups them by the dependencies of their components. The components of a
+// layer only depend on components of earlier layers. Dependencies on
+// components that were filtered out are ignored.
+func (p *Pipeline) Layers(filter []string) ([][]*unstructured.Unstructured, error) {
+	jobs, err := p.render(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	components, err := p.Components(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool)
+	for _, c := range components {
+		known[c.Name(true)] = true
+	}
+
+	deps, err := jobDependencies(jobs, known)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, err := dependencyLayers(jobs, deps)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers [][]*unstructured.Unstructured
+	for _, layer := range indexes {
+		var objects []*unstructured.Unstructured
+		for _, i := range layer {
+			objects = append(objects, jobs[i].objects...)
+		}
+		if len(objects) > 0 {
+			layers = append(layers, objects)
+		}
+	}
+
+	return layers, nil
+}
+
+// jobDependencies returns the namespaced names of the components the
+// component of each job depends on, by the namespaced name of the component.
+// A dependency is the name of a component in the same namespace, or the
+// namespaced name of a component. known is the set of the namespaced names
+// of all components.
+func jobDependencies(jobs []evalJob, known map[string]bool) (map[string][]string, error) {
+	declared := make(map[string]map[string][]string)
+	out := make(map[string][]string)
+	for _, job := range jobs {
+		nsDeps, ok := declared[job.namespace]
+		if !ok {
+			var params namespaceDependencies
+			if err := json.Unmarshal([]byte(job.paramsStr), &params); err != nil {
+				return nil, errors.Wrapf(err, "read dependencies of namespace %s", job.namespace)
+			}
+			nsDeps = params.Dependencies
+			declared[job.namespace] = nsDeps
+		}
+
+		name := job.component.Name(true)
+		for _, dep := range nsDeps[job.component.Name(false)] {
+			resolved, ok := resolveDependency(job.namespace, dep, known)
+			if !ok {
+				return nil, errors.Errorf("component %s depends on unknown component %s", name, dep)
+			}
+			out[name] = append(out[name], resolved)
+		}
+	}
+
+	return out, nil
+}
+
+// resolveDependency returns the namespaced name of the component dep refers
+// to from namespace nsName.
+func resolveDependency(nsName, dep string, known map[string]bool) (string, bool) {
+	if nsName != "/" {
+		if local := path.Join(nsName, dep); known[local] {
+			return local, true
+		}
+	}
+
+	return dep, known[dep]
+}
+
+// dependencyLayers groups the indexes of jobs into layers, so the component
+// of a job only depends on components in earlier layers. Jobs keep their
+// order within a layer. deps are the dependencies of each component by
+// namespaced name; components without a job are ignored.
+func dependencyLayers(jobs []evalJob, deps map[string][]string) ([][]int, error) {
+	pending := make(map[string]bool)
+	for _, job := range jobs {
+		pending[job.component.Name(true)] = true
+	}
+
+	// blocked returns the first dependency of name that isn't placed yet.
+	blocked := func(name string) (string, bool) {
+		for _, dep := range deps[name] {
+			if pending[dep] {
+				return dep, true
+			}
+		}
+		return "", false
+	}
+
+	var layers [][]int
+	for len(pending) > 0 {
+		var layer []int
+		for i, job := range jobs {
+			name := job.component.Name(true)
+			if !pending[name] {
+				continue
+			}
+			if _, ok := blocked(name); !ok {
+				layer = append(layer, i)
+			}
+		}
+
+		if len(layer) == 0 {
+			return nil, errors.Errorf("component dependencies form a cycle: %s", dependencyCycle(jobs, pending, blocked))
+		}
+
+		for _, i := range layer {
+			delete(pending, jobs[i].component.Name(true))
+		}
+		layers = append(layers, layer)
+	}
+
+	return layers, nil
+}
+
+// dependencyCycle describes a cycle of pending components. Every pending
+// component is blocked by another one, so following them from any pending
+// component leads to a cycle.
+func dependencyCycle(jobs []evalJob, pending map[string]bool, blocked func(string) (string, bool)) string {
+	var name string
+	for _, job := range jobs {
+		if pending[job.component.Name(true)] {
+			name = job.component.Name(true)
+			break
+		}
+	}
+
+	var walk []string
+	seen := make(map[string]int)
+	for {
+		if i, ok := seen[name]; ok {
+			return strings.Join(append(walk[i:], name), " -> ")
+		}
+		seen[name] = len(walk)
+		walk = append(walk, name)
+		name, _ = blocked(name)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/ksonnet/ksonnet/component"
+	cmocks "github.com/ksonnet/ksonnet/component/mocks"
+	appmocks "github.com/ksonnet/ksonnet/metadata/app/mocks"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPipeline_Layers(t *testing.T) {
+	rootParams := `{ dependencies: { api: ["db"], web: ["api", "nested/worker"] } }`
+	nestedParams := `{ dependencies: { worker: ["db"] } }`
+
+	cases := []struct {
+		name     string
+		filter   []string
+		expected [][]string
+	}{
+		{
+			name:     "all components",
+			expected: [][]string{{"db"}, {"api", "worker"}, {"web"}},
+		},
+		{
+			name:     "dependencies that were filtered out are ignored",
+			filter:   []string{"web", "api"},
+			expected: [][]string{{"api"}, {"web"}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			withLayersPipeline(t, rootParams, nestedParams, func(p *Pipeline) {
+				got, err := p.Layers(tc.filter)
+				require.NoError(t, err)
+
+				var names [][]string
+				for _, layer := range got {
+					var layerNames []string
+					for _, o := range layer {
+						layerNames = append(layerNames, o.GetName())
+					}
+					names = append(names, layerNames)
+				}
+				require.Equal(t, tc.expected, names)
+			})
+		})
+	}
+}
+
+func TestPipeline_Layers_errors(t *testing.T) {
+	cases := []struct {
+		name         string
+		rootParams   string
+		nestedParams string
+		err          string
+	}{
+		{
+			name:         "unknown component",
+			rootParams:   `{ dependencies: { api: ["cache"] } }`,
+			nestedParams: `{}`,
+			err:          "component api depends on unknown component cache",
+		},
+		{
+			name:         "cycle",
+			rootParams:   `{ dependencies: { db: ["web"], api: ["db"], web: ["api"] } }`,
+			nestedParams: `{}`,
+			err:          "component dependencies form a cycle: db -> web -> api -> db",
+		},
+		{
+			name:         "cycle across namespaces",
+			rootParams:   `{ dependencies: { web: ["nested/worker"] } }`,
+			nestedParams: `{ dependencies: { worker: ["web"] } }`,
+			err:          "component dependencies form a cycle: web -> nested/worker -> web",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			withLayersPipeline(t, tc.rootParams, tc.nestedParams, func(p *Pipeline) {
+				_, err := p.Layers(nil)
+				require.EqualError(t, err, tc.err)
+			})
+		})
+	}
+}
+
+// withLayersPipeline creates a pipeline with the components db, api and web
+// in the root namespace, and worker in the nested namespace. Every component
+// evaluates to an object with its name.
+func withLayersPipeline(t *testing.T, rootParams, nestedParams string, fn func(p *Pipeline)) {
+	withPipeline(t, func(p *Pipeline, m *cmocks.Manager, a *appmocks.App) {
+		mockLayerComponent := func(nsName, name string) *cmocks.Component {
+			obj := &unstructured.Unstructured{}
+			obj.SetName(name)
+
+			fullName := name
+			if nsName != "/" {
+				fullName = nsName + "/" + name
+			}
+
+			c := mockComponent(fullName)
+			c.On("Name", false).Return(name)
+			c.On("Objects", mock.Anything, "default", mock.Anything).Return([]*unstructured.Unstructured{obj}, nil)
+			return c
+		}
+
+		root := component.NewNamespace(p.app, "/")
+		nested := component.NewNamespace(p.app, "nested")
+		m.On("Namespaces", p.app, "default").Return([]component.Namespace{root, nested}, nil)
+		m.On("Namespace", p.app, "/").Return(root, nil)
+		m.On("Namespace", p.app, "nested").Return(nested, nil)
+		m.On("NSResolveParams", root).Return(rootParams, nil)
+		m.On("NSResolveParams", nested).Return(nestedParams, nil)
+		a.On("EnvironmentParams", "default").Return(`std.extVar("__ksonnet/params")`, nil)
+		m.On("Components", root).Return([]component.Component{
+			mockLayerComponent("/", "db"),
+			mockLayerComponent("/", "api"),
+			mockLayerComponent("/", "web"),
+		}, nil)
+		m.On("Components", nested).Return([]component.Component{
+			mockLayerComponent("nested", "worker"),
+		}, nil)
+
+		fn(p)
+	})
+}
@@ -0,0 +1,377 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	jsonnet "github.com/google/go-jsonnet"
+	"github.com/ksonnet/ksonnet/component"
+	"github.com/ksonnet/ksonnet/metadata/app"
+	utilyaml "github.com/ksonnet/ksonnet/pkg/util/yaml"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	amyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+const (
+	// OverlayDir is the directory of the overlays of an environment,
+	// relative to the directory of the environment.
+	OverlayDir = "overlays"
+
+	// overlayField is the hidden field of a Jsonnet overlay that selects
+	// the objects it applies to.
+	overlayField = "overlay"
+	// overlayObjectVar is the external variable a Jsonnet overlay is
+	// applied to.
+	overlayObjectVar = "__ksonnet/object"
+)
+
+// overlayTarget selects the objects an overlay applies to. Kind and name
+// are required. An empty API version or namespace matches any.
+type overlayTarget struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	// Delete removes the objects instead of changing them.
+	Delete bool `json:"delete"`
+}
+
+func (t overlayTarget) validate() error {
+	if t.Kind == "" || t.Name == "" {
+		return errors.New("the target of an overlay needs a kind and a name")
+	}
+	return nil
+}
+
+// matches returns true if obj is selected by t. Objects without a namespace
+// are in defaultNamespace.
+func (t overlayTarget) matches(obj *unstructured.Unstructured, defaultNamespace string) bool {
+	if t.APIVersion != "" && t.APIVersion != obj.GetAPIVersion() {
+		return false
+	}
+	if t.Kind != obj.GetKind() || t.Name != obj.GetName() {
+		return false
+	}
+	if t.Namespace == "" {
+		return true
+	}
+
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	return t.Namespace == namespace
+}
+
+// overlay is a change to the rendered objects of an environment.
+type overlay struct {
+	path   string
+	target overlayTarget
+	// apply returns obj with the overlay applied.
+	apply   func(obj *unstructured.Unstructured) (*unstructured.Unstructured, error)
+	matched bool
+}
+
+// overlays loads the overlays of the environment. They are read from
+// environments/<env>/overlays in file name order. Jsonnet files
+// (.jsonnet) are mixins with a hidden overlay field, e.g.
+//
+//	{
+//	  overlay:: { kind: "Deployment", name: "web" },
+//	  spec+: { replicas: 3 },
+//	}
+//
+// YAML and JSON files contain strategic merge patches, which select objects
+// by their apiVersion, kind, metadata.name and metadata.namespace. A patch
+// with `$patch: delete` removes the objects, as does a Jsonnet overlay with
+// `delete: true` in its overlay field. Other files, e.g. .libsonnet
+// libraries of the overlays, are skipped.
+func (p *Pipeline) overlays(opts component.EvalOptions) ([]*overlay, error) {
+	dir := filepath.Join(p.app.Root(), app.EnvironmentDirName, p.envName, OverlayDir)
+	fis, err := afero.ReadDir(p.app.Fs(), dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var overlays []*overlay
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(dir, fi.Name())
+		var loaded []*overlay
+		switch filepath.Ext(path) {
+		case ".jsonnet":
+			var o *overlay
+			o, err = jsonnetOverlay(path, opts)
+			loaded = []*overlay{o}
+		case ".yaml", ".yml", ".json":
+			loaded, err = patchOverlays(p.app.Fs(), path)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "load overlay %s", path)
+		}
+
+		overlays = append(overlays, loaded...)
+	}
+
+	return overlays, nil
+}
+
+// jsonnetOverlay loads a Jsonnet mixin from path.
+func jsonnetOverlay(path string, opts component.EvalOptions) (*overlay, error) {
+	base := filepath.Base(path)
+	vm := func() *jsonnet.VM {
+		vm := jsonnet.MakeVM()
+		if opts.Importer != nil {
+			vm.Importer(opts.Importer)
+		}
+		opts.ConfigureVM(vm)
+		return vm
+	}
+
+	snippet := fmt.Sprintf("local overlay = import %q; if std.objectHasEx(overlay, %q, true) then overlay.%s else null",
+		base, overlayField, overlayField)
+	out, err := vm().EvaluateSnippet(path, snippet)
+	if err != nil {
+		return nil, err
+	}
+
+	var target *overlayTarget
+	if err = json.Unmarshal([]byte(out), &target); err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, errors.Errorf("a Jsonnet overlay needs a hidden %s field with its target", overlayField)
+	}
+	if err = target.validate(); err != nil {
+		return nil, err
+	}
+
+	apply := func(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+		data, err := obj.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+
+		vm := vm()
+		vm.ExtCode(overlayObjectVar, string(data))
+		out, err := vm.EvaluateSnippet(path, fmt.Sprintf("std.extVar(%q) + (import %q)", overlayObjectVar, base))
+		if err != nil {
+			return nil, err
+		}
+
+		return decodeObject([]byte(out))
+	}
+
+	return &overlay{path: path, target: *target, apply: apply}, nil
+}
+
+// patchOverlays loads the strategic merge patches in the YAML or JSON file
+// at path.
+func patchOverlays(fs afero.Fs, path string) ([]*overlay, error) {
+	readers, err := utilyaml.Decode(fs, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var overlays []*overlay
+	for _, r := range readers {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(string(data)) == "" {
+			continue
+		}
+
+		patch, err := amyaml.ToJSON(data)
+		if err != nil {
+			return nil, err
+		}
+
+		o, err := patchOverlay(path, patch)
+		if err != nil {
+			return nil, err
+		}
+		overlays = append(overlays, o)
+	}
+
+	return overlays, nil
+}
+
+// patchOverlay creates an overlay which applies patch. Objects with a kind
+// that isn't built in get a JSON merge patch instead.
+func patchOverlay(path string, patch []byte) (*overlay, error) {
+	var doc struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Metadata   struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Directive string `json:"$patch"`
+	}
+	if err := json.Unmarshal(patch, &doc); err != nil {
+		return nil, err
+	}
+
+	target := overlayTarget{
+		APIVersion: doc.APIVersion,
+		Kind:       doc.Kind,
+		Namespace:  doc.Metadata.Namespace,
+		Name:       doc.Metadata.Name,
+		Delete:     doc.Directive == "delete",
+	}
+	if err := target.validate(); err != nil {
+		return nil, err
+	}
+
+	apply := func(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+		data, err := obj.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+
+		var patched []byte
+		if dataStruct, err := scheme.Scheme.New(obj.GroupVersionKind()); err == nil {
+			patched, err = strategicpatch.StrategicMergePatch(data, patch, dataStruct)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			patched, err = jsonpatch.MergePatch(data, patch)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return decodeObject(patched)
+	}
+
+	return &overlay{path: path, target: target, apply: apply}, nil
+}
+
+// decodeObject decodes a JSON object like components do, so numbers stay
+// integers.
+func decodeObject(data []byte) (*unstructured.Unstructured, error) {
+	obj, _, err := unstructured.UnstructuredJSONScheme.Decode(data, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	uns, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, errors.Errorf("overlay evaluated to %T instead of an object", obj)
+	}
+	return uns, nil
+}
+
+// applyOverlays applies the overlays of the environment to the objects of
+// jobs, in order. An overlay that doesn't match any object is reported,
+// unless the objects were filtered.
+func (p *Pipeline) applyOverlays(jobs []evalJob, filter []string, opts component.EvalOptions) error {
+	overlays, err := p.overlays(opts)
+	if err != nil {
+		return err
+	}
+	if len(overlays) == 0 {
+		return nil
+	}
+
+	defaultNamespace, err := p.defaultNamespace(overlays)
+	if err != nil {
+		return err
+	}
+
+	for i := range jobs {
+		out := make([]*unstructured.Unstructured, 0, len(jobs[i].objects))
+		for _, obj := range jobs[i].objects {
+			keep := true
+			for _, o := range overlays {
+				if !o.target.matches(obj, defaultNamespace) {
+					continue
+				}
+
+				o.matched = true
+				if o.target.Delete {
+					keep = false
+					break
+				}
+
+				desc := fmt.Sprintf("%s %s", obj.GetKind(), obj.GetName())
+				obj, err = o.apply(obj)
+				if err != nil {
+					return errors.Wrapf(err, "apply overlay %s to %s", o.path, desc)
+				}
+			}
+
+			if keep {
+				out = append(out, obj)
+			}
+		}
+		jobs[i].objects = out
+	}
+
+	if len(filter) == 0 {
+		for _, o := range overlays {
+			if !o.matched {
+				logrus.Warnf("overlay %s doesn't match any object: %s %s", o.path, o.target.Kind, o.target.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// defaultNamespace returns the namespace of the environment, if an overlay
+// selects objects by namespace.
+func (p *Pipeline) defaultNamespace(overlays []*overlay) (string, error) {
+	for _, o := range overlays {
+		if o.target.Namespace == "" {
+			continue
+		}
+
+		spec, err := p.app.Environment(p.envName)
+		if err != nil {
+			return "", err
+		}
+		if spec.Destination == nil {
+			return "", nil
+		}
+		return spec.Destination.Namespace, nil
+	}
+
+	return "", nil
+}
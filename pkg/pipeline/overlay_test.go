@@ -0,0 +1,162 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package pipeline
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ksonnet/ksonnet/component"
+	cmocks "github.com/ksonnet/ksonnet/component/mocks"
+	"github.com/ksonnet/ksonnet/metadata/app"
+	appmocks "github.com/ksonnet/ksonnet/metadata/app/mocks"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const overlayTestDir = "/app/environments/default/overlays"
+
+func withOverlays(t *testing.T, files map[string]string, objects []string, fn func(p *Pipeline)) {
+	withPipeline(t, func(p *Pipeline, m *cmocks.Manager, a *appmocks.App) {
+		for name, content := range files {
+			require.NoError(t, afero.WriteFile(a.Fs(), overlayTestDir+"/"+name, []byte(content), 0644))
+		}
+
+		var objs []*unstructured.Unstructured
+		for _, data := range objects {
+			obj, err := decodeObject([]byte(data))
+			require.NoError(t, err)
+			objs = append(objs, obj)
+		}
+
+		cpnt := mockComponent("cpnt")
+		cpnt.On("Objects", mock.Anything, "default", mock.Anything).Return(objs, nil)
+
+		ns := component.NewNamespace(p.app, "/")
+		m.On("Namespaces", p.app, "default").Return([]component.Namespace{ns}, nil)
+		m.On("Namespace", p.app, "/").Return(ns, nil)
+		m.On("NSResolveParams", ns).Return("", nil)
+		m.On("Components", ns).Return([]component.Component{cpnt}, nil)
+		a.On("EnvironmentParams", "default").Return("{}", nil)
+		a.On("Environment", "default").Return(&app.EnvironmentSpec{
+			Destination: &app.EnvironmentDestinationSpec{Namespace: "prod"},
+		}, nil)
+
+		fn(p)
+	})
+}
+
+func TestPipeline_Objects_overlays(t *testing.T) {
+	files := map[string]string{
+		"10-sidecar.jsonnet": `local sidecar = import "sidecar.libsonnet";
+{
+  overlay:: { kind: "Pod", name: "web" },
+  spec+: { containers+: [sidecar] },
+}`,
+		"sidecar.libsonnet": `{ name: "proxy", image: "proxy:1" }`,
+		"20-patches.yaml": `apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  containers:
+  - name: app
+    image: app:2
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cfg
+  namespace: prod
+data:
+  b: "2"
+---
+apiVersion: batch/v2alpha1
+kind: CronJob
+metadata:
+  name: cleanup
+$patch: delete
+`,
+		"30-widget.json": `{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": {"name": "w"}, "spec": {"sizes": [3]}}`,
+		"README.md":      "Not an overlay.",
+	}
+	objects := []string{
+		`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "web"}, "spec": {"containers": [{"name": "app", "image": "app:1"}]}}`,
+		`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "cfg"}, "data": {"a": "1"}}`,
+		`{"apiVersion": "batch/v2alpha1", "kind": "CronJob", "metadata": {"name": "cleanup"}}`,
+		`{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": {"name": "w"}, "spec": {"sizes": [1, 2], "replicas": 1}}`,
+	}
+
+	withOverlays(t, files, objects, func(p *Pipeline) {
+		got, err := p.Objects(nil)
+		require.NoError(t, err)
+
+		expected := []string{
+			`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "web"}, "spec": {"containers": [{"name": "app", "image": "app:2"}, {"name": "proxy", "image": "proxy:1"}]}}`,
+			`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "cfg", "namespace": "prod"}, "data": {"a": "1", "b": "2"}}`,
+			`{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": {"name": "w"}, "spec": {"sizes": [3], "replicas": 1}}`,
+		}
+		require.Len(t, got, len(expected))
+		for i := range expected {
+			data, err := json.Marshal(got[i].Object)
+			require.NoError(t, err)
+			require.JSONEq(t, expected[i], string(data))
+		}
+
+		// Numbers stay integers.
+		require.Equal(t, int64(1), got[2].Object["spec"].(map[string]interface{})["replicas"])
+	})
+}
+
+func TestPipeline_Objects_overlayWithoutTarget(t *testing.T) {
+	files := map[string]string{
+		"sidecar.jsonnet": `{ spec+: { replicas: 2 } }`,
+	}
+
+	withOverlays(t, files, nil, func(p *Pipeline) {
+		_, err := p.Objects(nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "hidden overlay field")
+	})
+}
+
+func TestOverlayTarget_matches(t *testing.T) {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("apps/v1beta1")
+	obj.SetKind("Deployment")
+	obj.SetName("web")
+
+	cases := []struct {
+		name     string
+		target   overlayTarget
+		expected bool
+	}{
+		{name: "kind and name", target: overlayTarget{Kind: "Deployment", Name: "web"}, expected: true},
+		{name: "api version", target: overlayTarget{APIVersion: "apps/v1beta1", Kind: "Deployment", Name: "web"}, expected: true},
+		{name: "other api version", target: overlayTarget{APIVersion: "extensions/v1beta1", Kind: "Deployment", Name: "web"}},
+		{name: "other name", target: overlayTarget{Kind: "Deployment", Name: "api"}},
+		{name: "default namespace", target: overlayTarget{Kind: "Deployment", Name: "web", Namespace: "default"}, expected: true},
+		{name: "other namespace", target: overlayTarget{Kind: "Deployment", Name: "web", Namespace: "prod"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.target.matches(obj, "default"))
+		})
+	}
+}
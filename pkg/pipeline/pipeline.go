@@ -19,10 +19,13 @@ import (
 	"bytes"
 	"io"
 	"regexp"
+	"runtime"
+	"sync"
 
 	jsonnet "github.com/google/go-jsonnet"
 	"github.com/ksonnet/ksonnet/component"
 	"github.com/ksonnet/ksonnet/metadata/app"
+	jsonnetutil "github.com/ksonnet/ksonnet/pkg/util/jsonnet"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -35,22 +38,43 @@ func OverrideManager(c component.Manager) Opt {
 	}
 }
 
+// WithEvalOptions sets the options components are evaluated with.
+func WithEvalOptions(opts component.EvalOptions) Opt {
+	return func(p *Pipeline) {
+		p.evalOpts = opts
+	}
+}
+
+// WithParallelism sets the number of components that are evaluated at the
+// same time. The default is the number of CPUs.
+func WithParallelism(n int) Opt {
+	return func(p *Pipeline) {
+		if n > 0 {
+			p.parallelism = n
+		}
+	}
+}
+
 // Opt is an option for configuring Pipeline.
 type Opt func(p *Pipeline)
 
 // Pipeline is the ks build pipeline.
 type Pipeline struct {
-	app     app.App
-	envName string
-	cm      component.Manager
+	app         app.App
+	envName     string
+	cm          component.Manager
+	evalOpts    component.EvalOptions
+	parallelism int
+	cache       *Cache
 }
 
 // New creates an instance of Pipeline.
 func New(ksApp app.App, envName string, opts ...Opt) *Pipeline {
 	p := &Pipeline{
-		app:     ksApp,
-		envName: envName,
-		cm:      component.DefaultManager,
+		app:         ksApp,
+		envName:     envName,
+		cm:          component.DefaultManager,
+		parallelism: runtime.NumCPU(),
 	}
 
 	for _, opt := range opts {
@@ -85,6 +109,7 @@ func (p *Pipeline) EnvParameters(nsName string) (string, error) {
 	envParams := upgradeParams(p.envName, data)
 
 	vm := jsonnet.MakeVM()
+	p.evalOpts.ConfigureVM(vm)
 	vm.ExtCode("__ksonnet/params", paramsStr)
 	return vm.EvaluateSnippet("snippet", string(envParams))
 }
@@ -110,36 +135,123 @@ func (p *Pipeline) Components(filter []string) ([]component.Component, error) {
 	return components, nil
 }
 
-// Objects converts components into Kubernetes objects.
+// Objects converts components into Kubernetes objects. Components are
+// evaluated concurrently, but the objects are returned in the order of the
+// namespaces and their components.
 func (p *Pipeline) Objects(filter []string) ([]*unstructured.Unstructured, error) {
-	namespaces, err := p.Namespaces()
+	jobs, err := p.render(filter)
 	if err != nil {
 		return nil, err
 	}
 
 	objects := make([]*unstructured.Unstructured, 0)
+	for _, job := range jobs {
+		objects = append(objects, job.objects...)
+	}
+
+	return objects, nil
+}
+
+// render evaluates the components that match filter, and applies the
+// overlays of the environment to their objects.
+func (p *Pipeline) render(filter []string) ([]evalJob, error) {
+	namespaces, err := p.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []evalJob
 	for _, ns := range namespaces {
 		paramsStr, err := p.EnvParameters(ns.Name())
 		if err != nil {
 			return nil, err
 		}
 
-		components, err := p.Components(filter)
+		members, err := p.cm.Components(ns)
 		if err != nil {
 			return nil, err
 		}
 
-		for _, c := range components {
-			o, err := c.Objects(paramsStr, p.envName)
-			if err != nil {
-				return nil, err
-			}
+		for _, c := range filterComponents(filter, members) {
+			jobs = append(jobs, evalJob{namespace: ns.Name(), component: c, paramsStr: paramsStr})
+		}
+	}
+
+	if len(jobs) == 0 {
+		return nil, nil
+	}
 
-			objects = append(objects, o...)
+	opts := p.evalOpts
+	if opts.Importer == nil {
+		// Share the importer, so libraries are read and parsed once for all
+		// components.
+		opts.Importer, err = component.NewImporter(p.app, p.envName, opts.JPaths)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return objects, nil
+	p.evaluate(jobs, opts)
+
+	for _, job := range jobs {
+		if job.err != nil {
+			return nil, errors.Wrapf(job.err, "evaluate component %s", job.component.Name(true))
+		}
+	}
+
+	if err = p.applyOverlays(jobs, filter, opts); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// evalJob is the evaluation of a component with the parameters of its
+// namespace.
+type evalJob struct {
+	namespace string
+	component component.Component
+	paramsStr string
+
+	objects []*unstructured.Unstructured
+	err     error
+}
+
+// evaluate evaluates jobs with at most p.parallelism workers. The workers
+// share opts.Importer, so every file is read and parsed once.
+func (p *Pipeline) evaluate(jobs []evalJob, opts component.EvalOptions) {
+	workers := p.parallelism
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				p.evaluateJob(&jobs[i], opts)
+			}
+		}()
+	}
+
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// evaluateJob evaluates job, using the cache if the pipeline has one.
+func (p *Pipeline) evaluateJob(job *evalJob, opts component.EvalOptions) {
+	if importer, ok := opts.Importer.(*jsonnetutil.Importer); ok && p.cache != nil {
+		p.evaluateCached(job, opts, importer)
+		return
+	}
+
+	job.objects, job.err = job.component.Objects(job.paramsStr, p.envName, opts)
 }
 
 // YAML converts components into YAML.
@@ -16,14 +16,22 @@
 package pipeline
 
 import (
+	"fmt"
 	"io/ioutil"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	gojsonnet "github.com/google/go-jsonnet"
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/mock"
 
 	"github.com/ksonnet/ksonnet/component"
 	cmocks "github.com/ksonnet/ksonnet/component/mocks"
 	appmocks "github.com/ksonnet/ksonnet/metadata/app/mocks"
+	jsonnetutil "github.com/ksonnet/ksonnet/pkg/util/jsonnet"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
@@ -56,6 +64,25 @@ func TestPipeline_EnvParameters(t *testing.T) {
 	})
 }
 
+func TestPipeline_EnvParameters_evalOptions(t *testing.T) {
+	withPipeline(t, func(p *Pipeline, m *cmocks.Manager, a *appmocks.App) {
+		WithEvalOptions(component.EvalOptions{
+			ExtVars: map[string]string{"replicas": "3"},
+		})(p)
+
+		ns := component.NewNamespace(p.app, "/")
+		m.On("Namespace", p.app, "/").Return(ns, nil)
+		m.On("NSResolveParams", ns).Return("", nil)
+		a.On("EnvironmentParams", "default").Return(
+			`{ replicas: std.extVar("replicas"), name: std.native("parseYaml")("a: b")[0].a }`, nil)
+
+		got, err := p.EnvParameters("/")
+		require.NoError(t, err)
+
+		require.Equal(t, "{\n   \"name\": \"b\",\n   \"replicas\": \"3\"\n}\n", got)
+	})
+}
+
 func TestPipeline_Components(t *testing.T) {
 	withPipeline(t, func(p *Pipeline, m *cmocks.Manager, a *appmocks.App) {
 		cpnt := &cmocks.Component{}
@@ -113,7 +140,7 @@ func TestPipeline_Objects(t *testing.T) {
 		}
 
 		cpnt := &cmocks.Component{}
-		cpnt.On("Objects", mock.Anything, "default").Return(u, nil)
+		cpnt.On("Objects", mock.Anything, "default", mock.Anything).Return(u, nil)
 		components := []component.Component{cpnt}
 
 		ns := component.NewNamespace(p.app, "/")
@@ -131,6 +158,95 @@ func TestPipeline_Objects(t *testing.T) {
 	})
 }
 
+func TestPipeline_Objects_namespaces(t *testing.T) {
+	withPipeline(t, func(p *Pipeline, m *cmocks.Manager, a *appmocks.App) {
+		WithParallelism(2)(p)
+
+		var mu sync.Mutex
+		var running, maxRunning int
+		calls := map[string]int{}
+
+		object := func(name string) *unstructured.Unstructured {
+			u := &unstructured.Unstructured{}
+			u.SetName(name)
+			return u
+		}
+		mockObjects := func(name, paramsStr string, objects ...*unstructured.Unstructured) *cmocks.Component {
+			c := mockComponent(name)
+			c.On("Objects", paramsStr, "default", mock.Anything).Return(objects, nil).Run(func(mock.Arguments) {
+				mu.Lock()
+				calls[name]++
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mu.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mu.Lock()
+				running--
+				mu.Unlock()
+			})
+			return c
+		}
+
+		root := component.NewNamespace(p.app, "/")
+		nested := component.NewNamespace(p.app, "nested")
+		m.On("Namespaces", p.app, "default").Return([]component.Namespace{root, nested}, nil)
+		m.On("Namespace", p.app, "/").Return(root, nil)
+		m.On("Namespace", p.app, "nested").Return(nested, nil)
+		m.On("NSResolveParams", root).Return(`{ ns: "root" }`, nil)
+		m.On("NSResolveParams", nested).Return(`{ ns: "nested" }`, nil)
+		a.On("EnvironmentParams", "default").Return(`std.extVar("__ksonnet/params")`, nil)
+
+		rootParams := "{\n   \"ns\": \"root\"\n}\n"
+		nestedParams := "{\n   \"ns\": \"nested\"\n}\n"
+		m.On("Components", root).Return([]component.Component{
+			mockObjects("a", rootParams, object("a1"), object("a2")),
+			mockObjects("b", rootParams, object("b")),
+			mockObjects("c", rootParams),
+		}, nil)
+		m.On("Components", nested).Return([]component.Component{
+			mockObjects("nested/d", nestedParams, object("d")),
+			mockObjects("nested/e", nestedParams, object("e")),
+		}, nil)
+
+		got, err := p.Objects(nil)
+		require.NoError(t, err)
+
+		var names []string
+		for _, o := range got {
+			names = append(names, o.GetName())
+		}
+		require.Equal(t, []string{"a1", "a2", "b", "d", "e"}, names)
+		require.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1, "nested/d": 1, "nested/e": 1}, calls)
+		require.True(t, maxRunning <= 2, "%d components were evaluated at the same time", maxRunning)
+	})
+}
+
+func TestPipeline_Objects_error(t *testing.T) {
+	withPipeline(t, func(p *Pipeline, m *cmocks.Manager, a *appmocks.App) {
+		failing := func(name string) *cmocks.Component {
+			c := mockComponent(name)
+			c.On("Objects", mock.Anything, "default", mock.Anything).Return(nil, errors.Errorf("%s failed", name))
+			return c
+		}
+
+		ns := component.NewNamespace(p.app, "/")
+		m.On("Namespaces", p.app, "default").Return([]component.Namespace{ns}, nil)
+		m.On("Namespace", p.app, "/").Return(ns, nil)
+		m.On("NSResolveParams", ns).Return("", nil)
+		a.On("EnvironmentParams", "default").Return("{}", nil)
+		m.On("Components", ns).Return([]component.Component{failing("a"), failing("b"), failing("c")}, nil)
+
+		// The error of the first component is returned, no matter which
+		// component finished first.
+		_, err := p.Objects(nil)
+		require.EqualError(t, err, "evaluate component a: a failed")
+	})
+}
+
 func TestPipeline_YAML(t *testing.T) {
 	withPipeline(t, func(p *Pipeline, m *cmocks.Manager, a *appmocks.App) {
 		u := []*unstructured.Unstructured{
@@ -138,7 +254,7 @@ func TestPipeline_YAML(t *testing.T) {
 		}
 
 		cpnt := &cmocks.Component{}
-		cpnt.On("Objects", mock.Anything, "default").Return(u, nil)
+		cpnt.On("Objects", mock.Anything, "default", mock.Anything).Return(u, nil)
 		components := []component.Component{cpnt}
 
 		ns := component.NewNamespace(p.app, "/")
@@ -169,8 +285,11 @@ func Test_upgradeParams(t *testing.T) {
 	require.Equal(t, expected, got)
 }
 
-func withPipeline(t *testing.T, fn func(p *Pipeline, m *cmocks.Manager, a *appmocks.App)) {
+func withPipeline(t testing.TB, fn func(p *Pipeline, m *cmocks.Manager, a *appmocks.App)) {
 	a := &appmocks.App{}
+	a.On("Fs").Return(afero.NewMemMapFs())
+	a.On("Root").Return("/app")
+	a.On("LibPath", "default").Return("/app/lib/v1.8.7", nil)
 	envName := "default"
 
 	manager := &cmocks.Manager{}
@@ -179,3 +298,72 @@ func withPipeline(t *testing.T, fn func(p *Pipeline, m *cmocks.Manager, a *appmo
 
 	fn(p, manager, a)
 }
+
+// importOnly hides the ImportAST method of an importer, so every evaluation
+// parses the files it imports.
+type importOnly struct {
+	gojsonnet.Importer
+}
+
+func BenchmarkPipeline_Objects(b *testing.B) {
+	var lib strings.Builder
+	lib.WriteString("{\n")
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&lib, "  f%d(x):: { a: x, b: [x, x + %d], c: { d: x } },\n", i, i)
+	}
+	lib.WriteString("}\n")
+
+	for _, tc := range []struct {
+		name      string
+		shareASTs bool
+	}{
+		{name: "shared ASTs", shareASTs: true},
+		{name: "unshared ASTs", shareASTs: false},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			withPipeline(b, func(p *Pipeline, m *cmocks.Manager, a *appmocks.App) {
+				fs := a.Fs()
+				require.NoError(b, afero.WriteFile(fs, "/app/lib/big.libsonnet", []byte(lib.String()), 0644))
+
+				var components []component.Component
+				for i := 0; i < 16; i++ {
+					source := fmt.Sprintf("/app/components/c%d.jsonnet", i)
+					require.NoError(b, afero.WriteFile(fs, source, []byte(fmt.Sprintf(`{
+  apiVersion: "v1",
+  kind: "ConfigMap",
+  metadata: { name: "c%d" },
+  data: { value: (import "big.libsonnet").f%d("c%d").a },
+}`, i, i, i)), 0644))
+					components = append(components, component.NewJsonnet(a, "/", source, "/app/components/params.libsonnet"))
+				}
+
+				ns := component.NewNamespace(p.app, "/")
+				m.On("Namespaces", p.app, "default").Return([]component.Namespace{ns}, nil)
+				m.On("Namespace", p.app, "/").Return(ns, nil)
+				m.On("NSResolveParams", ns).Return("", nil)
+				a.On("EnvironmentParams", "default").Return("{}", nil)
+				m.On("Components", ns).Return(components, nil)
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					// The mocks format their arguments, including the mocked
+					// app and its calls, on every call.
+					a.Calls = nil
+					m.Calls = nil
+
+					// Every render of an app creates a new importer.
+					importer := jsonnetutil.NewImporter(fs, "/app/lib")
+					opts := component.EvalOptions{Importer: importer}
+					if !tc.shareASTs {
+						opts.Importer = importOnly{importer}
+					}
+					WithEvalOptions(opts)(p)
+
+					objects, err := p.Objects(nil)
+					require.NoError(b, err)
+					require.Len(b, objects, 16)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package git reads the files of a git repository as they existed at a
+// given revision.
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+)
+
+// treeEntry is a file listed by `git ls-tree`.
+type treeEntry struct {
+	mode string
+	kind string
+	sha  string
+	path string
+}
+
+// NewFs returns a read-only filesystem with the files below dir as they
+// existed at the commit ref. dir must be inside a git work tree. The files
+// have the same paths as in the work tree, so an app in dir can be loaded
+// from the filesystem like from disk. The contents are read from the git
+// object store when the filesystem is created.
+func NewFs(dir, ref string) (afero.Fs, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	top, err := run(dir, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s is not in a git repository", dir)
+	}
+	root := strings.TrimSpace(string(top))
+
+	commit, err := run(dir, nil, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return nil, errors.Errorf("unknown git revision %q", ref)
+	}
+
+	prefix, err := filepath.Rel(root, realDir)
+	if err != nil {
+		return nil, err
+	}
+	prefix = filepath.ToSlash(prefix)
+
+	args := []string{"ls-tree", "-r", "-z", "--full-tree", strings.TrimSpace(string(commit))}
+	if prefix != "." {
+		args = append(args, "--", prefix)
+	}
+	out, err := run(root, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := parseTree(out)
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs []treeEntry
+	for _, e := range entries {
+		switch {
+		case e.kind != "blob":
+			log.Debugf("Skipping %s %s at %s", e.kind, e.path, ref)
+		case e.mode == "120000":
+			log.Debugf("Skipping symbolic link %s at %s", e.path, ref)
+		default:
+			blobs = append(blobs, e)
+		}
+	}
+
+	fs := afero.NewMemMapFs()
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	err = readBlobs(root, blobs, func(e treeEntry, data []byte) error {
+		rel := e.path
+		if prefix != "." {
+			rel = strings.TrimPrefix(rel, prefix+"/")
+		}
+		name := filepath.Join(dir, filepath.FromSlash(rel))
+
+		perm := os.FileMode(0644)
+		if e.mode == "100755" {
+			perm = 0755
+		}
+
+		if err := fs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		return afero.WriteFile(fs, name, data, perm)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return afero.NewReadOnlyFs(fs), nil
+}
+
+// parseTree parses the output of `git ls-tree -z`.
+func parseTree(out []byte) ([]treeEntry, error) {
+	var entries []treeEntry
+	for _, line := range bytes.Split(out, []byte{0}) {
+		if len(line) == 0 {
+			continue
+		}
+
+		tab := bytes.IndexByte(line, '\t')
+		if tab < 0 {
+			return nil, errors.Errorf("unexpected git ls-tree output %q", line)
+		}
+		fields := strings.Fields(string(line[:tab]))
+		if len(fields) != 3 {
+			return nil, errors.Errorf("unexpected git ls-tree output %q", line)
+		}
+
+		entries = append(entries, treeEntry{
+			mode: fields[0],
+			kind: fields[1],
+			sha:  fields[2],
+			path: path.Clean(string(line[tab+1:])),
+		})
+	}
+	return entries, nil
+}
+
+// readBlobs reads the contents of entries with a single `git cat-file`
+// process and passes them to fn.
+func readBlobs(root string, entries []treeEntry, fn func(treeEntry, []byte) error) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	var in bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintln(&in, e.sha)
+	}
+
+	out, err := run(root, &in, "cat-file", "--batch")
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(bytes.NewReader(out))
+	for _, e := range entries {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return errors.Wrap(err, "read git cat-file output")
+		}
+
+		// <sha> <type> <size>
+		fields := strings.Fields(header)
+		if len(fields) != 3 || fields[0] != e.sha {
+			return errors.Errorf("unexpected git cat-file output %q for %s", strings.TrimSpace(header), e.path)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return errors.Wrapf(err, "invalid size in git cat-file output for %s", e.path)
+		}
+
+		data := make([]byte, size+1)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return errors.Wrapf(err, "read %s", e.path)
+		}
+
+		if err := fn(e, data[:size]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run runs git in dir and returns its standard output.
+func run(dir string, stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdin = stdin
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, errors.Wrapf(err, "git %s", args[0])
+	}
+	return out, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func gitRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "gitfs")
+	require.NoError(t, err)
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	write := func(name, content string) {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+
+	git("init", "-q")
+	write("README.md", "readme")
+	write("app/app.yaml", "version: 1")
+	write("app/components/web.jsonnet", "{}")
+	git("add", "-A")
+	git("commit", "-q", "-m", "first")
+	git("tag", "v1")
+
+	write("app/app.yaml", "version: 2")
+	write("app/components/api.jsonnet", "{}")
+	git("add", "-A")
+	git("commit", "-q", "-m", "second")
+
+	return dir
+}
+
+func TestNewFs(t *testing.T) {
+	repo := gitRepo(t)
+	defer os.RemoveAll(repo)
+
+	appDir := filepath.Join(repo, "app")
+	fs, err := NewFs(appDir, "v1")
+	require.NoError(t, err)
+
+	data, err := afero.ReadFile(fs, filepath.Join(appDir, "app.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, "version: 1", string(data))
+
+	exists, err := afero.Exists(fs, filepath.Join(appDir, "components", "web.jsonnet"))
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// Files added later and files outside of dir are not included.
+	for _, name := range []string{filepath.Join(appDir, "components", "api.jsonnet"), filepath.Join(repo, "README.md")} {
+		exists, err = afero.Exists(fs, name)
+		require.NoError(t, err)
+		require.False(t, exists, name)
+	}
+
+	// The filesystem is read-only.
+	require.Error(t, afero.WriteFile(fs, filepath.Join(appDir, "app.yaml"), []byte{}, 0644))
+
+	fs, err = NewFs(appDir, "HEAD")
+	require.NoError(t, err)
+	data, err = afero.ReadFile(fs, filepath.Join(appDir, "app.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, "version: 2", string(data))
+}
+
+func TestNewFs_unknownRevision(t *testing.T) {
+	repo := gitRepo(t)
+	defer os.RemoveAll(repo)
+
+	_, err := NewFs(repo, "does-not-exist")
+	require.EqualError(t, err, `unknown git revision "does-not-exist"`)
+}
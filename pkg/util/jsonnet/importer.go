@@ -0,0 +1,253 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package jsonnet
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	gojsonnet "github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/spf13/afero"
+)
+
+// Importer imports Jsonnet files from an afero filesystem. Relative imports
+// are resolved against the directory of the importing file first, and then
+// against the search paths in order. Files are read and parsed once and
+// cached, so an Importer can be shared by VMs. It is safe for concurrent use.
+type Importer struct {
+	fs          afero.Fs
+	searchPaths []string
+	// externalFs holds the files below externalDirs.
+	externalFs   afero.Fs
+	externalDirs []string
+
+	mu    sync.Mutex
+	cache map[string]*importedFile
+}
+
+var _ gojsonnet.ASTImporter = (*Importer)(nil)
+
+// importedFile is a cached file. found is false if the file doesn't exist.
+type importedFile struct {
+	found   bool
+	content string
+	err     error
+
+	parse    sync.Once
+	node     ast.Node
+	parseErr error
+}
+
+// hash returns the hash of the content of f, or an empty string if f
+// doesn't exist.
+func (f *importedFile) hash() string {
+	if !f.found {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(f.content)))
+}
+
+// NewImporter creates an instance of Importer.
+func NewImporter(fs afero.Fs, searchPaths ...string) *Importer {
+	return &Importer{
+		fs:          fs,
+		searchPaths: searchPaths,
+		cache:       make(map[string]*importedFile),
+	}
+}
+
+// SearchPaths returns the paths that are searched after the directory of the
+// importing file.
+func (i *Importer) SearchPaths() []string {
+	return i.searchPaths
+}
+
+// AddExternalPaths appends search paths whose files, and the files below
+// them, are read from fs instead of the filesystem of the importer. This lets
+// an app which is read from another filesystem, e.g. a git revision, import
+// libraries from jpaths on disk. It must be called before the first import.
+func (i *Importer) AddExternalPaths(fs afero.Fs, dirs ...string) {
+	i.externalFs = fs
+	for _, dir := range dirs {
+		i.searchPaths = append(i.searchPaths, dir)
+		i.externalDirs = append(i.externalDirs, filepath.Clean(dir))
+	}
+}
+
+// fsFor returns the filesystem path is read from.
+func (i *Importer) fsFor(path string) afero.Fs {
+	path = filepath.Clean(path)
+	for _, dir := range i.externalDirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return i.externalFs
+		}
+	}
+	return i.fs
+}
+
+// Import imports importedPath from a file in codeDir.
+func (i *Importer) Import(codeDir, importedPath string) (*gojsonnet.ImportedData, error) {
+	return i.importWith(codeDir, importedPath, nil)
+}
+
+// importWith imports importedPath from a file in codeDir. visit is called
+// with every path that was looked at, if it isn't nil.
+func (i *Importer) importWith(codeDir, importedPath string, visit func(string, *importedFile)) (*gojsonnet.ImportedData, error) {
+	var candidates []string
+	if filepath.IsAbs(importedPath) {
+		candidates = []string{importedPath}
+	} else {
+		if codeDir != "" {
+			candidates = append(candidates, filepath.Join(codeDir, importedPath))
+		}
+		for _, dir := range i.searchPaths {
+			candidates = append(candidates, filepath.Join(dir, importedPath))
+		}
+	}
+
+	for _, path := range candidates {
+		f := i.read(path)
+		if visit != nil {
+			visit(path, f)
+		}
+		if f.err != nil {
+			return nil, f.err
+		}
+		if f.found {
+			return &gojsonnet.ImportedData{FoundHere: path, Content: f.content}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("couldn't open import %q: not found in the importing directory or in %v", importedPath, i.searchPaths)
+}
+
+// ImportAST parses imported code. The AST of a file is shared by all imports
+// of the file.
+func (i *Importer) ImportAST(data *gojsonnet.ImportedData) (ast.Node, error) {
+	f := i.read(data.FoundHere)
+	if !f.found || f.content != data.Content {
+		return gojsonnet.SnippetToAST(data.FoundHere, data.Content)
+	}
+
+	f.parse.Do(func() {
+		f.node, f.parseErr = gojsonnet.SnippetToAST(data.FoundHere, f.content)
+	})
+	return f.node, f.parseErr
+}
+
+// Hash returns the hash of the content of path, or an empty string if it
+// doesn't exist.
+func (i *Importer) Hash(path string) (string, error) {
+	f := i.read(path)
+	if f.err != nil {
+		return "", f.err
+	}
+	return f.hash(), nil
+}
+
+// Track returns an importer that imports with i, and records the files the
+// imports depend on.
+func (i *Importer) Track() *Tracker {
+	return &Tracker{
+		importer:     i,
+		dependencies: make(map[string]string),
+	}
+}
+
+// Dependency is a file an evaluation depends on.
+type Dependency struct {
+	Path string `json:"path"`
+	// Hash is the hash of the content of the file, or empty if the file
+	// didn't exist. Imports depend on the files that were looked at before
+	// the one that was found, since creating one of them changes the import.
+	Hash string `json:"hash"`
+}
+
+// Tracker is an importer which records the dependencies of imports. It is
+// safe for concurrent use.
+type Tracker struct {
+	importer *Importer
+
+	mu           sync.Mutex
+	dependencies map[string]string
+}
+
+var _ gojsonnet.ASTImporter = (*Tracker)(nil)
+
+// Import imports importedPath from a file in codeDir.
+func (t *Tracker) Import(codeDir, importedPath string) (*gojsonnet.ImportedData, error) {
+	return t.importer.importWith(codeDir, importedPath, func(path string, f *importedFile) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.dependencies[path] = f.hash()
+	})
+}
+
+// ImportAST parses imported code.
+func (t *Tracker) ImportAST(data *gojsonnet.ImportedData) (ast.Node, error) {
+	return t.importer.ImportAST(data)
+}
+
+// Dependencies returns the files the imports so far depend on, sorted by
+// path.
+func (t *Tracker) Dependencies() []Dependency {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	deps := make([]Dependency, 0, len(t.dependencies))
+	for path, hash := range t.dependencies {
+		deps = append(deps, Dependency{Path: path, Hash: hash})
+	}
+	sort.Slice(deps, func(i, j int) bool {
+		return deps[i].Path < deps[j].Path
+	})
+	return deps
+}
+
+// read reads path, or returns its cached version.
+func (i *Importer) read(path string) *importedFile {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if f, ok := i.cache[path]; ok {
+		return f
+	}
+
+	fs := i.fsFor(path)
+	f := &importedFile{}
+	fi, err := fs.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		f.err = err
+	case fi.IsDir():
+		// A directory with the name of the import isn't a match.
+	default:
+		var b []byte
+		b, f.err = afero.ReadFile(fs, path)
+		f.found = f.err == nil
+		f.content = string(b)
+	}
+
+	i.cache[path] = f
+	return f
+}
@@ -0,0 +1,201 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package jsonnet
+
+import (
+	"testing"
+
+	gojsonnet "github.com/google/go-jsonnet"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImporter_Import(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		"/app/components/sibling.libsonnet":     "sibling",
+		"/app/lib/shared.libsonnet":             "lib",
+		"/app/vendor/shared.libsonnet":          "vendor",
+		"/app/vendor/incubator/redis.libsonnet": "redis",
+		"/app/lib/v1.8.7/k.libsonnet":           "k",
+		"/jpath/k.libsonnet":                    "jpath k",
+		"/jpath/extra.libsonnet":                "extra",
+		"/abs.libsonnet":                        "abs",
+	}
+	for path, content := range files {
+		require.NoError(t, afero.WriteFile(fs, path, []byte(content), 0644))
+	}
+	require.NoError(t, fs.MkdirAll("/app/lib/dir.libsonnet", 0755))
+
+	importer := NewImporter(fs, "/app/lib", "/app/vendor", "/app/lib/v1.8.7", "/jpath")
+
+	cases := []struct {
+		name      string
+		path      string
+		foundHere string
+		content   string
+		isErr     bool
+	}{
+		{name: "importing directory", path: "sibling.libsonnet", foundHere: "/app/components/sibling.libsonnet", content: "sibling"},
+		{name: "search paths in order", path: "shared.libsonnet", foundHere: "/app/lib/shared.libsonnet", content: "lib"},
+		{name: "vendored package", path: "incubator/redis.libsonnet", foundHere: "/app/vendor/incubator/redis.libsonnet", content: "redis"},
+		{name: "environment library before jpath", path: "k.libsonnet", foundHere: "/app/lib/v1.8.7/k.libsonnet", content: "k"},
+		{name: "jpath", path: "extra.libsonnet", foundHere: "/jpath/extra.libsonnet", content: "extra"},
+		{name: "absolute path", path: "/abs.libsonnet", foundHere: "/abs.libsonnet", content: "abs"},
+		{name: "directories are skipped", path: "dir.libsonnet", isErr: true},
+		{name: "missing", path: "missing.libsonnet", isErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := importer.Import("/app/components", tc.path)
+			if tc.isErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, &gojsonnet.ImportedData{FoundHere: tc.foundHere, Content: tc.content}, data)
+		})
+	}
+}
+
+func TestImporter_AddExternalPaths(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/app/components/a.jsonnet", []byte("app"), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/jpath/app.libsonnet", []byte("shadowed"), 0644))
+
+	external := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(external, "/jpath/lib.libsonnet", []byte("lib"), 0644))
+	require.NoError(t, afero.WriteFile(external, "/jpath/nested/util.libsonnet", []byte("util"), 0644))
+	require.NoError(t, afero.WriteFile(external, "/app/components/b.jsonnet", []byte("other app"), 0644))
+
+	importer := NewImporter(fs, "/app/lib")
+	importer.AddExternalPaths(external, "/jpath")
+
+	data, err := importer.Import("/app/components", "lib.libsonnet")
+	require.NoError(t, err)
+	require.Equal(t, &gojsonnet.ImportedData{FoundHere: "/jpath/lib.libsonnet", Content: "lib"}, data)
+
+	// Imports relative to an external file are external as well.
+	data, err = importer.Import("/jpath", "nested/util.libsonnet")
+	require.NoError(t, err)
+	require.Equal(t, &gojsonnet.ImportedData{FoundHere: "/jpath/nested/util.libsonnet", Content: "util"}, data)
+
+	_, err = importer.Import("/app/components", "app.libsonnet")
+	require.Error(t, err)
+
+	_, err = importer.Import("/app/components", "b.jsonnet")
+	require.Error(t, err)
+}
+
+func TestImporter_cache(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/lib/a.libsonnet", []byte("1"), 0644))
+
+	importer := NewImporter(fs, "/lib")
+
+	data, err := importer.Import("/components", "a.libsonnet")
+	require.NoError(t, err)
+	require.Equal(t, "1", data.Content)
+
+	// Files are only read once.
+	require.NoError(t, afero.WriteFile(fs, "/lib/a.libsonnet", []byte("2"), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/components/a.libsonnet", []byte("3"), 0644))
+
+	data, err = importer.Import("/components", "a.libsonnet")
+	require.NoError(t, err)
+	require.Equal(t, "1", data.Content)
+}
+
+func TestImporter_vm(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/vendor/pkg/mixin.libsonnet", []byte(`{ util: import "util.libsonnet" }`), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/vendor/pkg/util.libsonnet", []byte(`{ value: 1 }`), 0644))
+
+	vm := gojsonnet.MakeVM()
+	vm.Importer(NewImporter(fs, "/lib", "/vendor"))
+
+	out, err := vm.EvaluateSnippet("/components/c.jsonnet", `(import "pkg/mixin.libsonnet").util.value`)
+	require.NoError(t, err)
+	require.Equal(t, "1\n", out)
+}
+
+func TestImporter_ImportAST(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/lib/util.libsonnet", []byte(`{ value: 1 }`), 0644))
+
+	importer := NewImporter(fs, "/lib")
+	data, err := importer.Import("/components", "util.libsonnet")
+	require.NoError(t, err)
+
+	node, err := importer.ImportAST(data)
+	require.NoError(t, err)
+	again, err := importer.Import("/other", "util.libsonnet")
+	require.NoError(t, err)
+	shared, err := importer.ImportAST(again)
+	require.NoError(t, err)
+	require.True(t, node == shared, "imports of the same file should share the AST")
+
+	// VMs evaluate the shared AST independently.
+	for i := 0; i < 2; i++ {
+		vm := gojsonnet.MakeVM()
+		vm.Importer(importer.Track())
+		out, err := vm.EvaluateSnippet("/components/c.jsonnet", `(import "util.libsonnet").value`)
+		require.NoError(t, err)
+		require.Equal(t, "1\n", out)
+	}
+
+	// Syntax errors are cached as well.
+	require.NoError(t, afero.WriteFile(fs, "/lib/invalid.libsonnet", []byte(`{`), 0644))
+	data, err = importer.Import("/components", "invalid.libsonnet")
+	require.NoError(t, err)
+	_, err = importer.ImportAST(data)
+	require.Error(t, err)
+}
+
+func TestTracker(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/vendor/pkg/mixin.libsonnet", []byte(`import "util.libsonnet"`), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/vendor/pkg/util.libsonnet", []byte(`1`), 0644))
+
+	importer := NewImporter(fs, "/lib", "/vendor")
+	tracker := importer.Track()
+
+	vm := gojsonnet.MakeVM()
+	vm.Importer(tracker)
+	_, err := vm.EvaluateSnippet("/components/c.jsonnet", `import "pkg/mixin.libsonnet"`)
+	require.NoError(t, err)
+
+	mixinHash, err := importer.Hash("/vendor/pkg/mixin.libsonnet")
+	require.NoError(t, err)
+	utilHash, err := importer.Hash("/vendor/pkg/util.libsonnet")
+	require.NoError(t, err)
+	require.NotEqual(t, mixinHash, utilHash)
+
+	// Files that were looked for before the import was found are recorded
+	// as missing, since creating them would change the import.
+	expected := []Dependency{
+		{Path: "/components/pkg/mixin.libsonnet"},
+		{Path: "/lib/pkg/mixin.libsonnet"},
+		{Path: "/vendor/pkg/mixin.libsonnet", Hash: mixinHash},
+		{Path: "/vendor/pkg/util.libsonnet", Hash: utilHash},
+	}
+	require.Equal(t, expected, tracker.Dependencies())
+
+	// Trackers of the same importer record their own dependencies.
+	require.Empty(t, importer.Track().Dependencies())
+}
@@ -0,0 +1,176 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package k8s
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const definitionRefPrefix = "#/definitions/"
+
+// schemaProperty is the subset of an OpenAPI v2 schema that is needed to
+// walk Kubernetes objects.
+type schemaProperty struct {
+	Ref        string                     `json:"$ref,omitempty"`
+	Type       string                     `json:"type,omitempty"`
+	Default    interface{}                `json:"default,omitempty"`
+	Items      *schemaProperty            `json:"items,omitempty"`
+	Properties map[string]*schemaProperty `json:"properties,omitempty"`
+
+	PatchMergeKey string `json:"x-kubernetes-patch-merge-key,omitempty"`
+	PatchStrategy string `json:"x-kubernetes-patch-strategy,omitempty"`
+
+	GroupVersionKinds []struct {
+		Group   string `json:"group"`
+		Version string `json:"version"`
+		Kind    string `json:"kind"`
+	} `json:"x-kubernetes-group-version-kind,omitempty"`
+}
+
+// Schema is the OpenAPI schema of the Kubernetes API, as found in the
+// swagger.json of an environment.
+type Schema struct {
+	definitions map[string]*schemaProperty
+	kinds       map[schema.GroupVersionKind]string
+}
+
+// ParseSchema parses an OpenAPI v2 document.
+func ParseSchema(data []byte) (*Schema, error) {
+	var doc struct {
+		Definitions map[string]*schemaProperty `json:"definitions"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(err, "parse OpenAPI schema")
+	}
+
+	s := &Schema{
+		definitions: doc.Definitions,
+		kinds:       make(map[schema.GroupVersionKind]string),
+	}
+
+	for name, def := range doc.Definitions {
+		for _, gvk := range def.GroupVersionKinds {
+			s.kinds[schema.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind}] = name
+		}
+	}
+
+	return s, nil
+}
+
+// ReadSchema reads the OpenAPI v2 document at path.
+func ReadSchema(fs afero.Fs, path string) (*Schema, error) {
+	data, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSchema(data)
+}
+
+// TypeFor returns the type of objects of the given kind, or nil if the
+// schema doesn't define the kind.
+func (s *Schema) TypeFor(gvk schema.GroupVersionKind) *SchemaType {
+	if s == nil {
+		return nil
+	}
+
+	name, ok := s.kinds[gvk]
+	if !ok {
+		return nil
+	}
+
+	return &SchemaType{schema: s, prop: &schemaProperty{Ref: definitionRefPrefix + name}}
+}
+
+// SchemaType is a property of a schema. A nil SchemaType is an unknown type;
+// all its methods return zero values.
+type SchemaType struct {
+	schema *Schema
+	prop   *schemaProperty
+}
+
+// resolved returns the definition the property refers to, or the property
+// itself if it isn't a reference.
+func (t *SchemaType) resolved() *schemaProperty {
+	if t == nil || t.prop == nil {
+		return nil
+	}
+
+	p := t.prop
+	for i := 0; p.Ref != "" && i < 10; i++ {
+		def, ok := t.schema.definitions[strings.TrimPrefix(p.Ref, definitionRefPrefix)]
+		if !ok {
+			return nil
+		}
+		p = def
+	}
+	return p
+}
+
+// Name is the name of the definition the type refers to, e.g.
+// `io.k8s.api.core.v1.Container`. It is empty for anonymous types.
+func (t *SchemaType) Name() string {
+	if t == nil || t.prop == nil {
+		return ""
+	}
+	return strings.TrimPrefix(t.prop.Ref, definitionRefPrefix)
+}
+
+// Field returns the type of a field of an object type.
+func (t *SchemaType) Field(name string) *SchemaType {
+	p := t.resolved()
+	if p == nil {
+		return nil
+	}
+
+	field, ok := p.Properties[name]
+	if !ok {
+		return nil
+	}
+	return &SchemaType{schema: t.schema, prop: field}
+}
+
+// Items returns the type of the elements of an array type.
+func (t *SchemaType) Items() *SchemaType {
+	p := t.resolved()
+	if p == nil || p.Items == nil {
+		return nil
+	}
+	return &SchemaType{schema: t.schema, prop: p.Items}
+}
+
+// Default returns the default value of the property, if the schema
+// declares one.
+func (t *SchemaType) Default() (interface{}, bool) {
+	if t == nil || t.prop == nil || t.prop.Default == nil {
+		return nil, false
+	}
+	return t.prop.Default, true
+}
+
+// MergeKey returns the key that identifies the elements of an array
+// property when it is merged, e.g. `name` for the containers of a pod.
+func (t *SchemaType) MergeKey() string {
+	if t == nil || t.prop == nil {
+		return ""
+	}
+	return t.prop.PatchMergeKey
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const testSchema = `{
+  "definitions": {
+    "io.k8s.api.core.v1.Pod": {
+      "properties": {
+        "spec": {"$ref": "#/definitions/io.k8s.api.core.v1.PodSpec"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "", "version": "v1", "kind": "Pod"}]
+    },
+    "io.k8s.api.core.v1.PodSpec": {
+      "properties": {
+        "containers": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/io.k8s.api.core.v1.Container"},
+          "x-kubernetes-patch-merge-key": "name",
+          "x-kubernetes-patch-strategy": "merge"
+        },
+        "priority": {"type": "integer", "default": 0}
+      }
+    },
+    "io.k8s.api.core.v1.Container": {
+      "properties": {
+        "name": {"type": "string"}
+      }
+    }
+  }
+}`
+
+func TestSchema(t *testing.T) {
+	s, err := ParseSchema([]byte(testSchema))
+	require.NoError(t, err)
+
+	require.Nil(t, s.TypeFor(schema.GroupVersionKind{Version: "v1", Kind: "Service"}))
+
+	pod := s.TypeFor(schema.GroupVersionKind{Version: "v1", Kind: "Pod"})
+	require.NotNil(t, pod)
+	require.Equal(t, "io.k8s.api.core.v1.Pod", pod.Name())
+
+	spec := pod.Field("spec")
+	require.Equal(t, "io.k8s.api.core.v1.PodSpec", spec.Name())
+
+	containers := spec.Field("containers")
+	require.Equal(t, "name", containers.MergeKey())
+	require.Equal(t, "io.k8s.api.core.v1.Container", containers.Items().Name())
+	require.NotNil(t, containers.Items().Field("name"))
+
+	def, ok := spec.Field("priority").Default()
+	require.True(t, ok)
+	require.EqualValues(t, 0, def)
+
+	_, ok = containers.Default()
+	require.False(t, ok)
+}
+
+func TestSchemaTypeNil(t *testing.T) {
+	var s *Schema
+	typ := s.TypeFor(schema.GroupVersionKind{Version: "v1", Kind: "Pod"})
+	require.Nil(t, typ)
+
+	require.Nil(t, typ.Field("spec"))
+	require.Nil(t, typ.Items())
+	require.Equal(t, "", typ.Name())
+	require.Equal(t, "", typ.MergeKey())
+	_, ok := typ.Default()
+	require.False(t, ok)
+}
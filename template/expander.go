@@ -93,80 +93,99 @@ func (spec *Expander) jsonnetVM() (*jsonnet.VM, error) {
 
 	vm.Importer(&importer)
 
-	for _, extvar := range spec.ExtVars {
-		kv := strings.SplitN(extvar, "=", 2)
-		switch len(kv) {
-		case 1:
-			v, present := os.LookupEnv(kv[0])
-			if present {
-				vm.ExtVar(kv[0], v)
-			} else {
-				return nil, fmt.Errorf("Missing environment variable: %s", kv[0])
-			}
-		case 2:
-			vm.ExtVar(kv[0], kv[1])
-		}
+	vars, err := spec.Vars()
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range vars.Ext {
+		vm.ExtVar(k, v)
+	}
+	for k, v := range vars.ExtCode {
+		vm.ExtCode(k, v)
+	}
+	for k, v := range vars.TLA {
+		vm.TLAVar(k, v)
 	}
 
-	for _, extvar := range spec.ExtVarFiles {
-		kv := strings.SplitN(extvar, "=", 2)
-		if len(kv) != 2 {
-			return nil, fmt.Errorf("Failed to parse ext var files: missing '=' in %s", extvar)
-		}
-		v, err := afero.ReadFile(spec.fs, kv[1])
-		if err != nil {
-			return nil, err
-		}
-		vm.ExtVar(kv[0], string(v))
+	resolver, err := spec.BuildResolver()
+	if err != nil {
+		return nil, err
+	}
+	utils.RegisterNativeFuncs(vm, resolver)
+
+	return vm, nil
+}
+
+// Vars are Jsonnet variables by name.
+type Vars struct {
+	// Ext are external string variables.
+	Ext map[string]string
+	// ExtCode are external variables containing Jsonnet code.
+	ExtCode map[string]string
+	// TLA are top level string arguments.
+	TLA map[string]string
+}
+
+// Vars returns the variables of the expander. Variables without a value are
+// read from the environment, and variables given as files are read from the
+// file system.
+func (spec *Expander) Vars() (Vars, error) {
+	vars := Vars{
+		Ext:     make(map[string]string),
+		ExtCode: make(map[string]string),
+		TLA:     make(map[string]string),
+	}
+
+	if err := spec.parseVars(vars.Ext, spec.ExtVars); err != nil {
+		return vars, err
+	}
+	if err := spec.parseVarFiles(vars.Ext, spec.ExtVarFiles, "ext var"); err != nil {
+		return vars, err
+	}
+	if err := spec.parseVars(vars.TLA, spec.TlaVars); err != nil {
+		return vars, err
+	}
+	if err := spec.parseVarFiles(vars.TLA, spec.TlaVarFiles, "tla var"); err != nil {
+		return vars, err
+	}
+	if err := spec.parseVars(vars.ExtCode, spec.ExtCodes); err != nil {
+		return vars, err
 	}
 
-	for _, tlavar := range spec.TlaVars {
-		kv := strings.SplitN(tlavar, "=", 2)
+	return vars, nil
+}
+
+// parseVars adds variables of the form <name>=<value> or <name> to vars.
+func (spec *Expander) parseVars(vars map[string]string, flags []string) error {
+	for _, flag := range flags {
+		kv := strings.SplitN(flag, "=", 2)
 		switch len(kv) {
 		case 1:
 			v, present := os.LookupEnv(kv[0])
 			if present {
-				vm.TLAVar(kv[0], v)
+				vars[kv[0]] = v
 			} else {
-				return nil, fmt.Errorf("Missing environment variable: %s", kv[0])
+				return fmt.Errorf("Missing environment variable: %s", kv[0])
 			}
 		case 2:
-			vm.TLAVar(kv[0], kv[1])
+			vars[kv[0]] = kv[1]
 		}
 	}
+	return nil
+}
 
-	for _, tlavar := range spec.TlaVarFiles {
-		kv := strings.SplitN(tlavar, "=", 2)
+// parseVarFiles adds variables of the form <name>=<file> to vars.
+func (spec *Expander) parseVarFiles(vars map[string]string, flags []string, kind string) error {
+	for _, flag := range flags {
+		kv := strings.SplitN(flag, "=", 2)
 		if len(kv) != 2 {
-			return nil, fmt.Errorf("Failed to parse tla var files: missing '=' in %s", tlavar)
+			return fmt.Errorf("Failed to parse %s files: missing '=' in %s", kind, flag)
 		}
 		v, err := afero.ReadFile(spec.fs, kv[1])
 		if err != nil {
-			return nil, err
+			return err
 		}
-		vm.TLAVar(kv[0], string(v))
+		vars[kv[0]] = string(v)
 	}
-
-	for _, extcode := range spec.ExtCodes {
-		kv := strings.SplitN(extcode, "=", 2)
-		switch len(kv) {
-		case 1:
-			v, present := os.LookupEnv(kv[0])
-			if present {
-				vm.ExtCode(kv[0], v)
-			} else {
-				return nil, fmt.Errorf("Missing environment variable: %s", kv[0])
-			}
-		case 2:
-			vm.ExtCode(kv[0], kv[1])
-		}
-	}
-
-	resolver, err := spec.buildResolver()
-	if err != nil {
-		return nil, err
-	}
-	utils.RegisterNativeFuncs(vm, resolver)
-
-	return vm, nil
+	return nil
 }
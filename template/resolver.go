@@ -23,7 +23,8 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func (spec *Expander) buildResolver() (utils.Resolver, error) {
+// BuildResolver builds the resolver of the resolveImage native function.
+func (spec *Expander) BuildResolver() (utils.Resolver, error) {
 	ret := resolverErrorWrapper{}
 
 	switch spec.FailAction {
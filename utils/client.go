@@ -61,6 +61,7 @@ func (c *memcachedDiscoveryClient) Invalidate() {
 	c.servergroups = nil
 	c.serverresources = make(map[string]*metav1.APIResourceList)
 	c.schemas = make(map[string]*swagger.ApiDeclaration)
+	c.schema = nil
 }
 
 func (c *memcachedDiscoveryClient) RESTClient() rest.Interface {
@@ -16,6 +16,8 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -25,6 +27,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/version"
 	"k8s.io/client-go/discovery"
 )
@@ -140,6 +143,42 @@ func SetMetaDataAnnotation(obj metav1.Object, key, value string) {
 	obj.SetAnnotations(a)
 }
 
+// SetMetaDataLabel sets a label value
+func SetMetaDataLabel(obj metav1.Object, key, value string) {
+	l := obj.GetLabels()
+	if l == nil {
+		l = make(map[string]string)
+	}
+	l[key] = value
+	obj.SetLabels(l)
+}
+
+// LabelValue converts s into a valid label value. Values which are already
+// valid are returned unchanged. Otherwise invalid characters are replaced and
+// a short hash of s is appended, so distinct inputs stay distinct.
+func LabelValue(s string) string {
+	if len(validation.IsValidLabelValue(s)) == 0 {
+		return s
+	}
+
+	sum := sha256.Sum256([]byte(s))
+	suffix := hex.EncodeToString(sum[:])[:8]
+
+	clean := reInvalidLabelChars.ReplaceAllString(s, "-")
+	maxLen := validation.LabelValueMaxLength - len(suffix) - 1
+	if len(clean) > maxLen {
+		clean = clean[:maxLen]
+	}
+	clean = strings.Trim(clean, "-_.")
+	if clean == "" {
+		return suffix
+	}
+
+	return clean + "-" + suffix
+}
+
+var reInvalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
 // ResourceNameFor returns a lowercase plural form of a type, for
 // human messages.  Returns lowercased kind if discovery lookup fails.
 func ResourceNameFor(disco discovery.ServerResourcesInterface, o runtime.Object) string {
@@ -16,11 +16,13 @@
 package utils
 
 import (
+	"strings"
 	"testing"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/version"
 	fakediscovery "k8s.io/client-go/discovery/fake"
 	ktesting "k8s.io/client-go/testing"
@@ -172,3 +174,35 @@ func TestFqName(t *testing.T) {
 		t.Errorf("Got %q for %v", n, obj)
 	}
 }
+
+func TestLabelValue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "dev", expected: "dev"},
+		{input: "my-app_1.0", expected: "my-app_1.0"},
+		{input: "", expected: ""},
+		{input: "us-west/dev", expected: "us-west-dev-"},
+		{input: "/", expected: ""},
+	}
+
+	for _, test := range tests {
+		got := LabelValue(test.input)
+		if errs := validation.IsValidLabelValue(got); len(errs) != 0 {
+			t.Errorf("LabelValue(%q) = %q is not a valid label value: %v", test.input, got, errs)
+		}
+		if !strings.HasPrefix(got, test.expected) {
+			t.Errorf("LabelValue(%q) = %q; expected prefix %q", test.input, got, test.expected)
+		}
+	}
+
+	if LabelValue("us-west/dev") == LabelValue("us-west.dev") {
+		t.Error("distinct inputs should produce distinct label values")
+	}
+
+	long := strings.Repeat("a", 100)
+	if got := LabelValue(long); len(got) > validation.LabelValueMaxLength {
+		t.Errorf("LabelValue(%q) = %q is too long", long, got)
+	}
+}
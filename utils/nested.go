@@ -0,0 +1,69 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+// NestedField returns the value at the path described by fields, or nil if
+// the path doesn't exist.
+func NestedField(obj map[string]interface{}, fields ...string) interface{} {
+	var val interface{} = obj
+	for _, field := range fields {
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		val, ok = m[field]
+		if !ok {
+			return nil
+		}
+	}
+	return val
+}
+
+// NestedString returns the string at the path described by fields, or an
+// empty string if it doesn't exist.
+func NestedString(obj map[string]interface{}, fields ...string) string {
+	s, _ := NestedField(obj, fields...).(string)
+	return s
+}
+
+// NestedInt64 returns the number at the path described by fields. The second
+// return value is false if the path doesn't exist or isn't a number.
+func NestedInt64(obj map[string]interface{}, fields ...string) (int64, bool) {
+	switch n := NestedField(obj, fields...).(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// NestedSlice returns the list at the path described by fields, or nil if it
+// doesn't exist.
+func NestedSlice(obj map[string]interface{}, fields ...string) []interface{} {
+	l, _ := NestedField(obj, fields...).([]interface{})
+	return l
+}
+
+// NestedMap returns the object at the path described by fields, or nil if it
+// doesn't exist.
+func NestedMap(obj map[string]interface{}, fields ...string) map[string]interface{} {
+	m, _ := NestedField(obj, fields...).(map[string]interface{})
+	return m
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNested(t *testing.T) {
+	obj := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": int64(3),
+			"name":     "app",
+			"float":    float64(2),
+			"list":     []interface{}{"a"},
+		},
+	}
+
+	i, ok := NestedInt64(obj, "spec", "replicas")
+	require.True(t, ok)
+	require.Equal(t, int64(3), i)
+
+	i, ok = NestedInt64(obj, "spec", "float")
+	require.True(t, ok)
+	require.Equal(t, int64(2), i)
+
+	_, ok = NestedInt64(obj, "spec", "missing")
+	require.False(t, ok)
+
+	require.Equal(t, "app", NestedString(obj, "spec", "name"))
+	require.Equal(t, "", NestedString(obj, "spec", "name", "deeper"))
+	require.Equal(t, []interface{}{"a"}, NestedSlice(obj, "spec", "list"))
+	require.NotNil(t, NestedMap(obj, "spec"))
+	require.Nil(t, NestedField(obj, "status", "phase"))
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 )
 
 const defaultRegistry = "registry-1.docker.io"
@@ -135,7 +136,10 @@ func NewRegistryResolver(httpClient *http.Client) Resolver {
 
 type registryResolver struct {
 	Client *http.Client
-	cache  map[string]string
+
+	// mu guards cache, since images may be resolved concurrently.
+	mu    sync.Mutex
+	cache map[string]string
 }
 
 func (r *registryResolver) Resolve(n *ImageName) error {
@@ -144,7 +148,10 @@ func (r *registryResolver) Resolve(n *ImageName) error {
 		return nil
 	}
 
-	if digest, ok := r.cache[n.String()]; ok {
+	r.mu.Lock()
+	digest, ok := r.cache[n.String()]
+	r.mu.Unlock()
+	if ok {
 		n.Digest = digest
 		return nil
 	}
@@ -155,7 +162,9 @@ func (r *registryResolver) Resolve(n *ImageName) error {
 		return fmt.Errorf("Unable to fetch digest for %s: %v", n, err)
 	}
 
+	r.mu.Lock()
 	r.cache[n.String()] = digest
+	r.mu.Unlock()
 	n.Digest = digest
 	return nil
 }
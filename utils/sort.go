@@ -16,49 +16,116 @@
 package utils
 
 import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
-var (
-	gkNamespace    = schema.GroupKind{Group: "", Kind: "Namespace"}
-	gkTpr          = schema.GroupKind{Group: "extensions", Kind: "ThirdPartyResource"}
-	gkStorageClass = schema.GroupKind{Group: "storage.k8s.io", Kind: "StorageClass"}
+// AnnotationApplyAfter lists the objects an object must be applied after,
+// as comma separated `<kind>/<name>` references.
+const AnnotationApplyAfter = "ksonnet.io/apply-after"
 
-	gkPod         = schema.GroupKind{Group: "", Kind: "Pod"}
-	gkJob         = schema.GroupKind{Group: "batch", Kind: "Job"}
-	gkDeployment  = schema.GroupKind{Group: "extensions", Kind: "Deployment"}
-	gkDaemonSet   = schema.GroupKind{Group: "extensions", Kind: "DaemonSet"}
-	gkStatefulSet = schema.GroupKind{Group: "apps", Kind: "StatefulSet"}
+// Dependency tiers of the built-in kinds. Objects in lower tiers are applied
+// first.
+const (
+	tierNamespace = 10
+	// Kinds that define other kinds or cluster wide policy.
+	tierDefinition = 20
+	// Kinds that constrain what can be created in a namespace.
+	tierNamespacePolicy = 30
+	tierIdentity        = 40
+	tierBinding         = 45
+	// Kinds consumed by pods.
+	tierConfig = 50
+	// Kinds that are not known, e.g. custom resources.
+	tierDefault = 60
+	tierService = 70
+	// Kinds that start pods.
+	tierWorkload = 100
+	// Kinds that reference workloads.
+	tierWorkloadPolicy = 110
+	// Kinds that route API requests to workloads. Registering them before
+	// their backends are running would fail requests to the API server.
+	tierWebhook = 120
 )
 
-// These kinds all start pods.
-// TODO: expand this list.
-func isPodOrSimilar(gk schema.GroupKind) bool {
-	return gk == gkPod ||
-		gk == gkJob ||
-		gk == gkDeployment ||
-		gk == gkDaemonSet ||
-		gk == gkStatefulSet
+var kindTiers = map[string]int{
+	"Namespace": tierNamespace,
+
+	"CustomResourceDefinition": tierDefinition,
+	"ThirdPartyResource":       tierDefinition,
+	"StorageClass":             tierDefinition,
+	"PriorityClass":            tierDefinition,
+	"PodSecurityPolicy":        tierDefinition,
+	"PodPreset":                tierDefinition,
+
+	"ResourceQuota": tierNamespacePolicy,
+	"LimitRange":    tierNamespacePolicy,
+	"NetworkPolicy": tierNamespacePolicy,
+
+	"ServiceAccount": tierIdentity,
+	"ClusterRole":    tierIdentity,
+	"Role":           tierIdentity,
+
+	"ClusterRoleBinding": tierBinding,
+	"RoleBinding":        tierBinding,
+
+	"ConfigMap":             tierConfig,
+	"Secret":                tierConfig,
+	"PersistentVolume":      tierConfig,
+	"PersistentVolumeClaim": tierConfig,
+
+	"Service": tierService,
+
+	"Pod":                   tierWorkload,
+	"ReplicationController": tierWorkload,
+	"ReplicaSet":            tierWorkload,
+	"Deployment":            tierWorkload,
+	"DaemonSet":             tierWorkload,
+	"StatefulSet":           tierWorkload,
+	"Job":                   tierWorkload,
+	"CronJob":               tierWorkload,
+
+	"HorizontalPodAutoscaler": tierWorkloadPolicy,
+	"PodDisruptionBudget":     tierWorkloadPolicy,
+	"Ingress":                 tierWorkloadPolicy,
+
+	"APIService":                     tierWebhook,
+	"MutatingWebhookConfiguration":   tierWebhook,
+	"ValidatingWebhookConfiguration": tierWebhook,
+	"InitializerConfiguration":       tierWebhook,
+}
+
+// isBuiltinGroup reports whether group is one of the API groups served by
+// Kubernetes itself, rather than by a custom resource or aggregated API.
+func isBuiltinGroup(group string) bool {
+	return !strings.Contains(group, ".") || strings.HasSuffix(group, ".k8s.io")
 }
 
-// Arbitrary numbers used to do a simple topological sort of resources.
-// TODO: expand this list.
+// Arbitrary numbers used to order resources by kind.
 func depTier(o schema.ObjectKind) int {
 	gk := o.GroupVersionKind().GroupKind()
-	if gk == gkNamespace || gk == gkTpr || gk == gkStorageClass {
-		return 10
-	} else if isPodOrSimilar(gk) {
-		return 100
-	} else {
-		return 50
+	if !isBuiltinGroup(gk.Group) {
+		return tierDefault
 	}
+	if tier, ok := kindTiers[gk.Kind]; ok {
+		return tier
+	}
+	return tierDefault
 }
 
 // DependencyOrder is a `sort.Interface` that *best-effort* sorts the
 // objects so that known dependencies appear earlier in the list.  The
 // idea is to prevent *some* of the "crash-restart" loops when
 // creating inter-dependent resources.
+//
+// DependencyOrder only considers the kind of objects. Use DependencySort to
+// honour the AnnotationApplyAfter annotation as well.
 type DependencyOrder []*unstructured.Unstructured
 
 func (l DependencyOrder) Len() int      { return len(l) }
@@ -67,6 +134,212 @@ func (l DependencyOrder) Less(i, j int) bool {
 	return depTier(l[i].GetObjectKind()) < depTier(l[j].GetObjectKind())
 }
 
+// DependencySort sorts objs in place so that every object appears after the
+// objects named in its AnnotationApplyAfter annotation. Objects that are not
+// ordered by annotations are sorted by the tier of their kind, as in
+// DependencyOrder, and then by their original position. Within a tier,
+// objects that are annotated to follow other objects of the tier come last.
+//
+// References to objects that are not in objs are ignored, since they may
+// have been applied separately. An error is returned if a reference is
+// malformed or the annotations form a cycle.
+func DependencySort(objs []*unstructured.Unstructured) error {
+	order, _, err := dependencyGraph(objs)
+	if err != nil {
+		return err
+	}
+
+	sorted := make([]*unstructured.Unstructured, len(objs))
+	for i, j := range order {
+		sorted[i] = objs[j]
+	}
+
+	copy(objs, sorted)
+	return nil
+}
+
+// DependencyTiers groups objs into tiers that can be applied one after the
+// other. The objects of a tier share the tier of their kind and don't depend
+// on each other, so they can be applied concurrently. Concatenating the
+// tiers gives the order of DependencySort.
+func DependencyTiers(objs []*unstructured.Unstructured) ([][]*unstructured.Unstructured, error) {
+	order, dependents, err := dependencyGraph(objs)
+	if err != nil {
+		return nil, err
+	}
+
+	dependencies := make([][]int, len(objs))
+	for i, ds := range dependents {
+		for _, d := range ds {
+			dependencies[d] = append(dependencies[d], i)
+		}
+	}
+
+	var tiers [][]*unstructured.Unstructured
+	inTier := make(map[int]bool)
+	tier := -1
+	for _, i := range order {
+		kindTier := depTier(objs[i].GetObjectKind())
+
+		split := len(tiers) == 0 || kindTier != tier
+		for _, d := range dependencies[i] {
+			if inTier[d] {
+				split = true
+			}
+		}
+
+		if split {
+			tiers = append(tiers, nil)
+			inTier = make(map[int]bool)
+			tier = kindTier
+		}
+
+		tiers[len(tiers)-1] = append(tiers[len(tiers)-1], objs[i])
+		inTier[i] = true
+	}
+
+	return tiers, nil
+}
+
+// dependencyGraph topologically sorts objs. It returns the sorted indexes of
+// objs and, for each object, the indexes of the objects that depend on it.
+func dependencyGraph(objs []*unstructured.Unstructured) ([]int, [][]int, error) {
+	byRef := make(map[string][]int)
+	for i, obj := range objs {
+		ref := dependencyRef(obj.GetKind(), obj.GetName())
+		byRef[ref] = append(byRef[ref], i)
+	}
+
+	dependents := make([][]int, len(objs))
+	inDegree := make([]int, len(objs))
+	for i, obj := range objs {
+		refs, err := applyAfter(obj)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, ref := range refs {
+			deps, ok := byRef[ref]
+			if !ok {
+				log.Debugf("%s: ignoring %s %q, no such object", FqName(obj), AnnotationApplyAfter, ref)
+				continue
+			}
+			for _, dep := range deps {
+				if dep == i {
+					continue
+				}
+				dependents[dep] = append(dependents[dep], i)
+				inDegree[i]++
+			}
+		}
+	}
+
+	q := &depQueue{objs: objs, depth: make([]int, len(objs))}
+	for i := range objs {
+		if inDegree[i] == 0 {
+			heap.Push(q, i)
+		}
+	}
+
+	order := make([]int, 0, len(objs))
+	for q.Len() > 0 {
+		i := heap.Pop(q).(int)
+		order = append(order, i)
+		for _, d := range dependents[i] {
+			// Objects of the same tier are ordered by the length of the
+			// chain of annotations leading to them, so independent
+			// objects stay together.
+			if depTier(objs[d].GetObjectKind()) == depTier(objs[i].GetObjectKind()) && q.depth[i]+1 > q.depth[d] {
+				q.depth[d] = q.depth[i] + 1
+			}
+			inDegree[d]--
+			if inDegree[d] == 0 {
+				heap.Push(q, d)
+			}
+		}
+	}
+
+	if len(order) != len(objs) {
+		var cycle []string
+		for i, obj := range objs {
+			if inDegree[i] > 0 {
+				cycle = append(cycle, fmt.Sprintf("%s/%s", obj.GetKind(), obj.GetName()))
+			}
+		}
+		sort.Strings(cycle)
+		return nil, nil, fmt.Errorf("%s annotations form a cycle between %s",
+			AnnotationApplyAfter, strings.Join(cycle, ", "))
+	}
+
+	return order, dependents, nil
+}
+
+// ReverseDependencySort sorts objs in place in the reverse order of
+// DependencySort, which is the order objects can be deleted in.
+func ReverseDependencySort(objs []*unstructured.Unstructured) error {
+	if err := DependencySort(objs); err != nil {
+		return err
+	}
+	for i, j := 0, len(objs)-1; i < j; i, j = i+1, j-1 {
+		objs[i], objs[j] = objs[j], objs[i]
+	}
+	return nil
+}
+
+func dependencyRef(kind, name string) string {
+	return strings.ToLower(kind) + "/" + name
+}
+
+// applyAfter returns the normalized references in the AnnotationApplyAfter
+// annotation of obj.
+func applyAfter(obj *unstructured.Unstructured) ([]string, error) {
+	value := obj.GetAnnotations()[AnnotationApplyAfter]
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	var refs []string
+	for _, ref := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(ref), "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("%s: invalid %s reference %q, expected <kind>/<name>",
+				FqName(obj), AnnotationApplyAfter, ref)
+		}
+		refs = append(refs, dependencyRef(parts[0], parts[1]))
+	}
+
+	return refs, nil
+}
+
+// depQueue is a heap of indexes into objs, ordered by tier, depth and then
+// index.
+type depQueue struct {
+	objs    []*unstructured.Unstructured
+	depth   []int
+	indexes []int
+}
+
+func (q *depQueue) Len() int      { return len(q.indexes) }
+func (q *depQueue) Swap(i, j int) { q.indexes[i], q.indexes[j] = q.indexes[j], q.indexes[i] }
+func (q *depQueue) Less(i, j int) bool {
+	a, b := q.indexes[i], q.indexes[j]
+	ta, tb := depTier(q.objs[a].GetObjectKind()), depTier(q.objs[b].GetObjectKind())
+	if ta != tb {
+		return ta < tb
+	}
+	if q.depth[a] != q.depth[b] {
+		return q.depth[a] < q.depth[b]
+	}
+	return a < b
+}
+func (q *depQueue) Push(x interface{}) { q.indexes = append(q.indexes, x.(int)) }
+func (q *depQueue) Pop() interface{} {
+	n := len(q.indexes)
+	x := q.indexes[n-1]
+	q.indexes = q.indexes[:n-1]
+	return x
+}
+
 // AlphabeticalOrder is a `sort.Interface` that sorts the
 // objects by namespace/name/kind alphabetical order
 type AlphabeticalOrder []*unstructured.Unstructured
@@ -52,6 +52,120 @@ func TestDepSort(t *testing.T) {
 	}
 }
 
+func TestDepSort_tiers(t *testing.T) {
+	newObj := func(apiVersion, kind string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": apiVersion,
+				"kind":       kind,
+			},
+		}
+	}
+
+	objs := []*unstructured.Unstructured{
+		newObj("admissionregistration.k8s.io/v1beta1", "ValidatingWebhookConfiguration"),
+		newObj("apps/v1", "Deployment"),
+		newObj("example.com/v1", "Deployment"),
+		newObj("v1", "Service"),
+		newObj("v1", "PersistentVolumeClaim"),
+		newObj("rbac.authorization.k8s.io/v1", "RoleBinding"),
+		newObj("rbac.authorization.k8s.io/v1", "Role"),
+		newObj("v1", "ServiceAccount"),
+		newObj("apiextensions.k8s.io/v1beta1", "CustomResourceDefinition"),
+		newObj("v1", "Namespace"),
+	}
+
+	if err := DependencySort(objs); err != nil {
+		t.Fatal(err)
+	}
+
+	var kinds []string
+	for _, o := range objs {
+		kinds = append(kinds, o.GetAPIVersion()+" "+o.GetKind())
+	}
+
+	expected := []string{
+		"v1 Namespace",
+		"apiextensions.k8s.io/v1beta1 CustomResourceDefinition",
+		"rbac.authorization.k8s.io/v1 Role",
+		"v1 ServiceAccount",
+		"rbac.authorization.k8s.io/v1 RoleBinding",
+		"v1 PersistentVolumeClaim",
+		"example.com/v1 Deployment",
+		"v1 Service",
+		"apps/v1 Deployment",
+		"admissionregistration.k8s.io/v1beta1 ValidatingWebhookConfiguration",
+	}
+
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("actual != expected: %v != %v", kinds, expected)
+	}
+}
+
+func TestDepSort_applyAfter(t *testing.T) {
+	newObj := func(kind, name, after string) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{}
+		o.SetAPIVersion("v1")
+		o.SetKind(kind)
+		o.SetName(name)
+		if after != "" {
+			o.SetAnnotations(map[string]string{AnnotationApplyAfter: after})
+		}
+		return o
+	}
+
+	objs := []*unstructured.Unstructured{
+		newObj("ConfigMap", "a", "secret/b, service/missing"),
+		newObj("Secret", "b", "Service/c"),
+		newObj("Service", "c", ""),
+		newObj("Namespace", "d", ""),
+	}
+
+	if err := DependencySort(objs); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, o := range objs {
+		names = append(names, o.GetName())
+	}
+	if expected := []string{"d", "c", "b", "a"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("actual != expected: %v != %v", names, expected)
+	}
+
+	if err := ReverseDependencySort(objs); err != nil {
+		t.Fatal(err)
+	}
+	if objs[0].GetName() != "a" || objs[3].GetName() != "d" {
+		t.Errorf("unexpected reverse order: %v", objs)
+	}
+}
+
+func TestDepSort_errors(t *testing.T) {
+	newObj := func(kind, name, after string) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{}
+		o.SetKind(kind)
+		o.SetName(name)
+		o.SetAnnotations(map[string]string{AnnotationApplyAfter: after})
+		return o
+	}
+
+	cycle := []*unstructured.Unstructured{
+		newObj("ConfigMap", "a", "Secret/b"),
+		newObj("Secret", "b", "ConfigMap/a"),
+	}
+	if err := DependencySort(cycle); err == nil {
+		t.Error("expected an error for a cycle")
+	}
+
+	invalid := []*unstructured.Unstructured{
+		newObj("ConfigMap", "a", "b"),
+	}
+	if err := DependencySort(invalid); err == nil {
+		t.Error("expected an error for an invalid reference")
+	}
+}
+
 func TestAlphaSort(t *testing.T) {
 	newObj := func(ns, name, kind string) *unstructured.Unstructured {
 		o := unstructured.Unstructured{}
@@ -83,3 +197,43 @@ func TestAlphaSort(t *testing.T) {
 		t.Errorf("actual != expected: %v != %v", objs, expected)
 	}
 }
+
+func TestDependencyTiers(t *testing.T) {
+	newObj := func(kind, name, after string) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{}
+		o.SetAPIVersion("v1")
+		o.SetKind(kind)
+		o.SetName(name)
+		if after != "" {
+			o.SetAnnotations(map[string]string{AnnotationApplyAfter: after})
+		}
+		return o
+	}
+
+	objs := []*unstructured.Unstructured{
+		newObj("Pod", "p", ""),
+		newObj("ConfigMap", "a", ""),
+		newObj("ConfigMap", "b", "ConfigMap/a"),
+		newObj("Secret", "c", ""),
+		newObj("Namespace", "ns", ""),
+	}
+
+	tiers, err := DependencyTiers(objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names [][]string
+	for _, tier := range tiers {
+		var tierNames []string
+		for _, o := range tier {
+			tierNames = append(tierNames, o.GetName())
+		}
+		names = append(names, tierNames)
+	}
+
+	expected := [][]string{{"ns"}, {"a", "c"}, {"b"}, {"p"}}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("actual != expected: %v != %v", names, expected)
+	}
+}